@@ -0,0 +1,203 @@
+package infrared
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+var ErrAccessDenied = errors.New("access denied")
+
+// AccessListConfig is a data representation of IP allow and deny lists
+type AccessListConfig struct {
+	// Allow are the CIDRs that are allowed to connect. If empty, every IP is allowed.
+	Allow []string `json:"allow"`
+	// Deny are the CIDRs that are denied. Deny takes precedence over Allow.
+	Deny []string `json:"deny"`
+	// DenyMessage is sent to denied clients that try to log in.
+	// Denied status requests never get a response.
+	DenyMessage string `json:"denyMessage"`
+}
+
+func (cfg AccessListConfig) isEmpty() bool {
+	return len(cfg.Allow) == 0 && len(cfg.Deny) == 0 && cfg.DenyMessage == ""
+}
+
+// AccessList decides which IPs are allowed to connect
+type AccessList struct {
+	mu          sync.RWMutex
+	allow       []*net.IPNet
+	deny        []*net.IPNet
+	denyMessage string
+}
+
+// NewAccessList compiles the CIDRs of the config into an AccessList
+func NewAccessList(cfg AccessListConfig) (*AccessList, error) {
+	var list AccessList
+	if err := list.Update(cfg); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// Update replaces the rules of the AccessList with the ones from the config
+func (list *AccessList) Update(cfg AccessListConfig) error {
+	allow, err := parseCIDRList(cfg.Allow)
+	if err != nil {
+		return err
+	}
+
+	deny, err := parseCIDRList(cfg.Deny)
+	if err != nil {
+		return err
+	}
+
+	list.mu.Lock()
+	defer list.mu.Unlock()
+	list.allow = allow
+	list.deny = deny
+	list.denyMessage = cfg.DenyMessage
+	return nil
+}
+
+// IsAllowed checks if the IP is not denied and, if an allow list exists, allowed
+func (list *AccessList) IsAllowed(ip net.IP) bool {
+	if list == nil {
+		return true
+	}
+
+	list.mu.RLock()
+	defer list.mu.RUnlock()
+
+	if containsIP(list.deny, ip) {
+		return false
+	}
+
+	return len(list.allow) == 0 || containsIP(list.allow, ip)
+}
+
+func (list *AccessList) DenyMessage() string {
+	if list == nil {
+		return ""
+	}
+
+	list.mu.RLock()
+	defer list.mu.RUnlock()
+	return list.denyMessage
+}
+
+func containsIP(ipNets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range ipNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCIDRList(cidrs []string) ([]*net.IPNet, error) {
+	var ipNets []*net.IPNet
+	for _, cidr := range cidrs {
+		parsed, err := ParseCIDRs(cidr)
+		if err != nil {
+			return nil, err
+		}
+		ipNets = append(ipNets, parsed...)
+	}
+	return ipNets, nil
+}
+
+// LoadAccessListFromPath loads an AccessList from a JSON file and then starts watching
+// it for changes. On change the AccessList will automatically reload itself.
+func LoadAccessListFromPath(path string) (*AccessList, error) {
+	log.Println("Loading access list", path)
+
+	cfg, err := readAccessListConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := NewAccessList(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := watcher.Add(path); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	go func() {
+		defer watcher.Close()
+		log.Printf("Starting to watch %s", path)
+		list.watch(watcher, path, time.Millisecond*50)
+		log.Printf("Stopping to watch %s", path)
+	}()
+
+	return list, nil
+}
+
+func (list *AccessList) watch(watcher *fsnotify.Watcher, path string, interval time.Duration) {
+	// The interval protects the watcher from write event spams
+	tick := time.Tick(interval)
+	changed := false
+
+	for {
+		select {
+		case <-tick:
+			if !changed {
+				continue
+			}
+			changed = false
+
+			log.Println("Updating access list", path)
+			cfg, err := readAccessListConfig(path)
+			if err != nil {
+				log.Printf("Failed update on %s; error %s", path, err)
+				continue
+			}
+
+			if err := list.Update(cfg); err != nil {
+				log.Printf("Failed update on %s; error %s", path, err)
+			}
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&fsnotify.Write == fsnotify.Write {
+				changed = true
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Failed watching %s; error %s", path, err)
+		}
+	}
+}
+
+func readAccessListConfig(path string) (AccessListConfig, error) {
+	var cfg AccessListConfig
+	bb, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+
+	if err := json.Unmarshal(bb, &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid access list %s; %s", path, err)
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,114 @@
+package infrared
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAccessList_IsAllowed(t *testing.T) {
+	tt := []struct {
+		name    string
+		cfg     AccessListConfig
+		ip      string
+		allowed bool
+	}{
+		{
+			name:    "Empty",
+			cfg:     AccessListConfig{},
+			ip:      "109.226.143.210",
+			allowed: true,
+		},
+		{
+			name: "Denied",
+			cfg: AccessListConfig{
+				Deny: []string{"109.226.143.0/24"},
+			},
+			ip:      "109.226.143.210",
+			allowed: false,
+		},
+		{
+			name: "NotDenied",
+			cfg: AccessListConfig{
+				Deny: []string{"109.226.143.0/24"},
+			},
+			ip:      "109.226.144.210",
+			allowed: true,
+		},
+		{
+			name: "Allowed",
+			cfg: AccessListConfig{
+				Allow: []string{"10.0.0.0/8", "2001:db8::/32"},
+			},
+			ip:      "2001:db8::1",
+			allowed: true,
+		},
+		{
+			name: "NotAllowed",
+			cfg: AccessListConfig{
+				Allow: []string{"10.0.0.0/8"},
+			},
+			ip:      "109.226.143.210",
+			allowed: false,
+		},
+		{
+			name: "DenyBeforeAllow",
+			cfg: AccessListConfig{
+				Allow: []string{"10.0.0.0/8"},
+				Deny:  []string{"10.1.2.3"},
+			},
+			ip:      "10.1.2.3",
+			allowed: false,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			list, err := NewAccessList(tc.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if list.IsAllowed(net.ParseIP(tc.ip)) != tc.allowed {
+				t.Errorf("got: %v; want: %v", !tc.allowed, tc.allowed)
+			}
+		})
+	}
+}
+
+func TestNewAccessList_InvalidCIDR(t *testing.T) {
+	if _, err := NewAccessList(AccessListConfig{Deny: []string{"10.0.0.0/33"}}); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestLoadAccessListFromPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access-list.json")
+	if err := ioutil.WriteFile(path, []byte(`{"deny": ["10.0.0.0/8"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := LoadAccessListFromPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip := net.ParseIP("10.1.2.3")
+	if list.IsAllowed(ip) {
+		t.Fatal("ip should be denied")
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"deny": []}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for !list.IsAllowed(ip) {
+		if time.Now().After(deadline) {
+			t.Fatal("access list was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -10,10 +11,11 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 )
 
 // ListenAndServe StartWebserver Start Webserver if environment variable "api-enable" is set to true
-func ListenAndServe(configPath string, apiBind string) {
+func ListenAndServe(configPath string, apiBind string, players *infrared.PlayerRegistry) {
 	fmt.Println("Starting WebAPI on " + apiBind)
 	router := chi.NewRouter()
 	router.Use(middleware.Logger)
@@ -21,6 +23,10 @@ func ListenAndServe(configPath string, apiBind string) {
 	router.Post("/proxies", addProxy(configPath))
 	router.Post("/proxies/{fileName}", addProxyWithName(configPath))
 	router.Delete("/proxies/{fileName}", removeProxy(configPath))
+	router.Get("/proxies/{fileName}/maintenance", getMaintenance(configPath))
+	router.Put("/proxies/{fileName}/maintenance", updateMaintenance(configPath))
+	router.Get("/players", getPlayers(players))
+	router.Get("/players/{player}", getPlayer(players))
 
 	err := http.ListenAndServe(apiBind, router)
 	if err != nil {
@@ -37,15 +43,14 @@ func addProxy(configPath string) http.HandlerFunc {
 			return
 		}
 
-		jsonIsValid := checkJSONAndRegister(rawData, "", configPath)
-		if jsonIsValid {
-			w.WriteHeader(http.StatusOK)
-			return
-		} else {
+		format := formatFromContentType(r.Header.Get("Content-Type"))
+		err = checkConfigAndRegister(rawData, format, "", configPath)
+		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("{'error': 'domainName and proxyTo could not be found'}"))
+			w.Write([]byte(fmt.Sprintf("{\"error\": %q}", err)))
 			return
 		}
+		w.WriteHeader(http.StatusOK)
 	}
 }
 
@@ -59,15 +64,14 @@ func addProxyWithName(configPath string) http.HandlerFunc {
 			return
 		}
 
-		jsonIsValid := checkJSONAndRegister(rawData, fileName, configPath)
-		if jsonIsValid {
-			w.WriteHeader(http.StatusOK)
-			return
-		} else {
+		format := infrared.ConfigFormatFromPath(fileName)
+		err = checkConfigAndRegister(rawData, format, fileName, configPath)
+		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("{'error': 'domainName and proxyTo could not be found'}"))
+			w.Write([]byte(fmt.Sprintf("{\"error\": %q}", err)))
 			return
 		}
+		w.WriteHeader(http.StatusOK)
 	}
 }
 
@@ -85,31 +89,187 @@ func removeProxy(configPath string) http.HandlerFunc {
 	}
 }
 
-// Helper method to check for domainName and proxyTo in a given JSON array
+// Helper method to validate a given config of the format before it is stored
 // If the filename is empty the domain will be used as the filename - files with the same name will be overwritten
-func checkJSONAndRegister(rawData []byte, filename string, configPath string) (successful bool) {
-	var cfg infrared.ProxyConfig
-	err := json.Unmarshal(rawData, &cfg)
+func checkConfigAndRegister(rawData []byte, format string, filename string, configPath string) error {
+	rawCfg, err := infrared.UnmarshalConfig(format, rawData)
+	if err != nil {
+		return err
+	}
+
+	domainName, _ := rawCfg["domainName"].(string)
+	if filename == "" {
+		// If fileName is empty use domainName as filename
+		filename = domainName + formatExtensions[format]
+	}
+
+	// Bases of the config are relative to the config path
+	if err := infrared.ValidateProxyConfig(configPath+"/"+filename, rawData); err != nil {
+		return err
+	}
+
+	if domainName == "" {
+		return errors.New("domainName could not be found")
+	}
+
+	err = os.WriteFile(configPath+"/"+filename, rawData, 0644)
 	if err != nil {
 		fmt.Println(err)
-		return false
+		return err
 	}
 
-	if cfg.DomainName == "" || cfg.ProxyTo == "" {
-		return false
+	return nil
+}
+
+// formatExtensions are the file extensions of configs that are named after their domain.
+// JSON configs have no extension.
+var formatExtensions = map[string]string{
+	infrared.ConfigFormatYAML: ".yml",
+	infrared.ConfigFormatTOML: ".toml",
+}
+
+func formatFromContentType(contentType string) string {
+	switch {
+	case strings.Contains(contentType, "yaml"):
+		return infrared.ConfigFormatYAML
+	case strings.Contains(contentType, "toml"):
+		return infrared.ConfigFormatTOML
+	default:
+		return infrared.ConfigFormatJSON
 	}
+}
 
-	path := configPath + "/" + filename
-	// If fileName is empty use domainName as filename
-	if filename == "" {
-		path = configPath + "/" + cfg.DomainName
+func getMaintenance(configPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fileName := chi.URLParam(r, "fileName")
+
+		cfg, err := readProxyConfigFile(configPath + "/" + fileName)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		maintenance, _ := cfg["maintenance"].(map[string]interface{})
+		writeConfig(w, infrared.ConfigFormatFromPath(fileName), maintenance)
+	}
+}
+
+// updateMaintenance merges the fields of the request into the maintenance config of the file.
+// The request and response use the format of the file. Only the maintenance key of the file
+// is rewritten, so that its comments and the order of the other keys are kept.
+func updateMaintenance(configPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fileName := chi.URLParam(r, "fileName")
+		path := configPath + "/" + fileName
+		format := infrared.ConfigFormatFromPath(fileName)
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		cfg, err := infrared.UnmarshalConfig(format, content)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("{\"error\": %q}", err)))
+			return
+		}
+
+		rawData, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		update, err := infrared.UnmarshalConfig(format, rawData)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("{\"error\": %q}", err)))
+			return
+		}
+
+		maintenance, ok := cfg["maintenance"].(map[string]interface{})
+		if !ok {
+			maintenance = map[string]interface{}{}
+		}
+
+		for k, v := range update {
+			maintenance[k] = v
+		}
+
+		// Check that the merged config is still a valid maintenance config
+		bb, err := json.Marshal(maintenance)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if err := json.Unmarshal(bb, &infrared.MaintenanceConfig{}); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("{\"error\": %q}", err)))
+			return
+		}
+
+		bb, err = infrared.SetConfigKey(format, content, "maintenance", maintenance)
+		if err != nil {
+			log.Printf("Failed updating maintenance of %s; error: %s", path, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if err := os.WriteFile(path, bb, 0644); err != nil {
+			log.Printf("Failed writing %s; error: %s", path, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		writeConfig(w, format, maintenance)
+	}
+}
+
+func readProxyConfigFile(path string) (map[string]interface{}, error) {
+	bb, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
 	}
 
-	err = os.WriteFile(path, rawData, 0644)
+	return infrared.UnmarshalConfig(infrared.ConfigFormatFromPath(path), bb)
+}
+
+// writeConfig writes the config in the format
+func writeConfig(w http.ResponseWriter, format string, cfg map[string]interface{}) {
+	bb, err := infrared.MarshalConfig(format, cfg)
 	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", infrared.ConfigContentType(format))
+	w.Write(bb)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
 		fmt.Println(err)
-		return false
 	}
+}
 
-	return true
+func getPlayers(players *infrared.PlayerRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, players.Players())
+	}
+}
+
+func getPlayer(players *infrared.PlayerRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		player, ok := players.Player(chi.URLParam(r, "player"))
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeJSON(w, player)
+	}
 }
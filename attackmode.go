@@ -0,0 +1,183 @@
+package infrared
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	attackModeActive = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "infrared_attack_mode",
+		Help: "Whether the attack mode of a listener is on",
+	}, []string{"listener"})
+)
+
+const (
+	DefaultAttackModeCooldown   = 2 * time.Minute
+	DefaultAttackModePingWindow = time.Minute
+	DefaultAttackModeMessage    = "Please refresh your server list and join again."
+
+	// LoginDeniedReasonAttackMode is the reason of logins that did not ping first during an attack
+	LoginDeniedReasonAttackMode = "attackMode"
+
+	// attackModeStatusCacheTTL is the time a status response is served from cache during an attack
+	attackModeStatusCacheTTL = 5 * time.Second
+)
+
+var ErrPingRequired = errors.New("ping before login required")
+
+// AttackModeConfig configures the attack mode of the listeners.
+// The attack mode turns on when a listener accepts more than Threshold
+// connections within a second and turns off after Cooldown without that happening again.
+// While it is on, status requests are served from cache, clients have to ping
+// the server before they can log in and RateLimit replaces the regular rate limits.
+type AttackModeConfig struct {
+	// Threshold is the number of new connections per second that turns the attack mode on. Zero disables it.
+	Threshold int
+	// Cooldown is the time the connection rate has to stay below the threshold to turn the attack mode off
+	Cooldown time.Duration
+	// PingWindow is the time in which a client has to have pinged the server to be allowed to log in
+	PingWindow time.Duration
+	// Message is the disconnect message for logins without a ping
+	Message string
+	// RateLimit holds the limits that are used instead of the regular ones. Zero values keep the regular limit.
+	RateLimit ListenerRateLimitConfig
+}
+
+func (cfg AttackModeConfig) IsEnabled() bool {
+	return cfg.Threshold > 0
+}
+
+func (cfg AttackModeConfig) cooldown() time.Duration {
+	if cfg.Cooldown > 0 {
+		return cfg.Cooldown
+	}
+	return DefaultAttackModeCooldown
+}
+
+func (cfg AttackModeConfig) pingWindow() time.Duration {
+	if cfg.PingWindow > 0 {
+		return cfg.PingWindow
+	}
+	return DefaultAttackModePingWindow
+}
+
+func (cfg AttackModeConfig) message() string {
+	if cfg.Message != "" {
+		return cfg.Message
+	}
+	return DefaultAttackModeMessage
+}
+
+// rateLimit returns the regular limits tightened by the limits of the attack mode
+func (cfg AttackModeConfig) rateLimit(regular ListenerRateLimitConfig) ListenerRateLimitConfig {
+	limits := cfg.RateLimit
+	if !limits.PerIP.IsEnabled() {
+		limits.PerIP = regular.PerIP
+	}
+	if !limits.PerSubnet.IsEnabled() {
+		limits.PerSubnet = regular.PerSubnet
+	}
+	if limits.MaxConcurrentPerIP <= 0 {
+		limits.MaxConcurrentPerIP = regular.MaxConcurrentPerIP
+	}
+	if !limits.Handshake.Status.IsEnabled() {
+		limits.Handshake.Status = regular.Handshake.Status
+	}
+	if !limits.Handshake.Login.IsEnabled() {
+		limits.Handshake.Login = regular.Handshake.Login
+	}
+	return limits
+}
+
+// attackMode tracks the connection rate and the pings of a single listener
+type attackMode struct {
+	mu          sync.Mutex
+	active      bool
+	windowStart time.Time
+	connections int
+	lastAttack  time.Time
+	pings       map[string]time.Time
+	lastSweep   time.Time
+}
+
+// observe counts a new connection and returns true if this turned the attack mode on
+func (mode *attackMode) observe(cfg AttackModeConfig, now time.Time) bool {
+	mode.mu.Lock()
+	defer mode.mu.Unlock()
+
+	if now.Sub(mode.windowStart) >= time.Second {
+		mode.windowStart = now
+		mode.connections = 0
+	}
+	mode.connections++
+
+	if mode.connections <= cfg.Threshold {
+		return false
+	}
+
+	mode.lastAttack = now
+	if mode.active {
+		return false
+	}
+
+	mode.active = true
+	return true
+}
+
+// expire turns the attack mode off if the cooldown passed since the last attack.
+// Otherwise it returns the remaining cooldown.
+func (mode *attackMode) expire(cooldown time.Duration, now time.Time) time.Duration {
+	mode.mu.Lock()
+	defer mode.mu.Unlock()
+
+	remaining := mode.lastAttack.Add(cooldown).Sub(now)
+	if remaining > 0 {
+		return remaining
+	}
+
+	mode.active = false
+	return 0
+}
+
+func (mode *attackMode) isActive() bool {
+	if mode == nil {
+		return false
+	}
+
+	mode.mu.Lock()
+	defer mode.mu.Unlock()
+	return mode.active
+}
+
+func (mode *attackMode) recordPing(ip string, now time.Time) {
+	mode.mu.Lock()
+	defer mode.mu.Unlock()
+
+	if mode.pings == nil {
+		mode.pings = map[string]time.Time{}
+	}
+	mode.pings[ip] = now
+}
+
+// hasPinged checks if the IP pinged the server within the window
+func (mode *attackMode) hasPinged(ip string, window time.Duration, now time.Time) bool {
+	mode.mu.Lock()
+	defer mode.mu.Unlock()
+
+	if now.Sub(mode.lastSweep) >= window {
+		mode.lastSweep = now
+		for key, pingedAt := range mode.pings {
+			if now.Sub(pingedAt) > window {
+				delete(mode.pings, key)
+			}
+		}
+	}
+
+	pingedAt, ok := mode.pings[ip]
+	return ok && now.Sub(pingedAt) <= window
+}
@@ -0,0 +1,82 @@
+package infrared
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAttackMode_ObserveAndExpire(t *testing.T) {
+	cfg := AttackModeConfig{Threshold: 2, Cooldown: time.Minute}
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	mode := attackMode{}
+
+	for i := 0; i < 2; i++ {
+		if mode.observe(cfg, now) {
+			t.Fatal("attack mode should not turn on below the threshold")
+		}
+	}
+
+	if !mode.observe(cfg, now) || !mode.isActive() {
+		t.Fatal("attack mode should turn on above the threshold")
+	}
+
+	if mode.observe(cfg, now) {
+		t.Fatal("attack mode should only report turning on once")
+	}
+
+	// A new window starts after a second
+	mode.observe(cfg, now.Add(2*time.Second))
+	if !mode.isActive() {
+		t.Fatal("attack mode should stay on during the cooldown")
+	}
+
+	if remaining := mode.expire(cfg.cooldown(), now.Add(30*time.Second)); remaining != 30*time.Second {
+		t.Errorf("got: %v; want: %v", remaining, 30*time.Second)
+	}
+
+	if remaining := mode.expire(cfg.cooldown(), now.Add(time.Minute)); remaining != 0 || mode.isActive() {
+		t.Error("attack mode should turn off after the cooldown")
+	}
+}
+
+func TestAttackMode_HasPinged(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	mode := attackMode{}
+
+	if mode.hasPinged("127.0.0.1", time.Minute, now) {
+		t.Fatal("ip should not have pinged")
+	}
+
+	mode.recordPing("127.0.0.1", now)
+
+	if !mode.hasPinged("127.0.0.1", time.Minute, now.Add(time.Second)) {
+		t.Fatal("ip should have pinged")
+	}
+
+	if mode.hasPinged("127.0.0.1", time.Minute, now.Add(2*time.Minute)) {
+		t.Fatal("ping should be outside of the window")
+	}
+}
+
+func TestAttackModeConfig_RateLimit(t *testing.T) {
+	regular := ListenerRateLimitConfig{
+		PerIP:              RateLimit{Rate: 10},
+		PerSubnet:          RateLimit{Rate: 100},
+		MaxConcurrentPerIP: 5,
+	}
+	cfg := AttackModeConfig{
+		RateLimit: ListenerRateLimitConfig{
+			PerIP:              RateLimit{Rate: 1},
+			MaxConcurrentPerIP: 1,
+		},
+	}
+
+	limits := cfg.rateLimit(regular)
+	if limits.PerIP.Rate != 1 || limits.MaxConcurrentPerIP != 1 {
+		t.Errorf("attack mode limits should replace the regular ones; got: %+v", limits)
+	}
+
+	if limits.PerSubnet.Rate != 100 {
+		t.Errorf("unset attack mode limits should keep the regular ones; got: %+v", limits)
+	}
+}
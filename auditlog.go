@@ -0,0 +1,315 @@
+package infrared
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/haveachin/infrared/protocol/handshaking"
+)
+
+const (
+	AuditOutcomeProxied      = "proxied"
+	AuditOutcomeServed       = "served"
+	AuditOutcomeDenied       = "denied"
+	AuditOutcomeRateLimited  = "rateLimited"
+	AuditOutcomeTimeout      = "timeout"
+	AuditOutcomeUnknownProxy = "unknownProxy"
+	AuditOutcomeError        = "error"
+
+	auditLogTimeFormat = "2006-01-02T15-04-05.000"
+)
+
+// AuditEntry is a single line of the audit log and describes one connection
+type AuditEntry struct {
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	Listener        string    `json:"listener"`
+	ClientIP        string    `json:"clientIp"`
+	Hostname        string    `json:"hostname,omitempty"`
+	ProtocolVersion int       `json:"protocolVersion,omitempty"`
+	NextState       string    `json:"nextState,omitempty"`
+	Username        string    `json:"username,omitempty"`
+	ProxyUID        string    `json:"proxyUid,omitempty"`
+	Backend         string    `json:"backend,omitempty"`
+	Outcome         string    `json:"outcome"`
+	Error           string    `json:"error,omitempty"`
+	// BytesIn is the number of bytes piped from the client to the backend
+	BytesIn int64 `json:"bytesIn"`
+	// BytesOut is the number of bytes piped from the backend to the client
+	BytesOut   int64 `json:"bytesOut"`
+	DurationMs int64 `json:"durationMs"`
+}
+
+// finish sets the end, duration and outcome of the entry based on the error the connection was closed with
+func (entry *AuditEntry) finish(err error, now time.Time) {
+	entry.End = now
+	entry.DurationMs = now.Sub(entry.Start).Milliseconds()
+	entry.Outcome = auditOutcome(entry, err)
+	if err != nil {
+		entry.Error = err.Error()
+	}
+}
+
+func auditOutcome(entry *AuditEntry, err error) string {
+	switch {
+	case err == nil && entry.Backend != "":
+		return AuditOutcomeProxied
+	case err == nil:
+		return AuditOutcomeServed
+	case errors.Is(err, ErrRateLimited):
+		return AuditOutcomeRateLimited
+	case errors.Is(err, ErrTimeout):
+		return AuditOutcomeTimeout
+	case errors.Is(err, ErrNoProxy):
+		return AuditOutcomeUnknownProxy
+	case errors.Is(err, ErrAccessDenied),
+		errors.Is(err, ErrCountryDenied),
+		errors.Is(err, ErrPlayerDenied),
+		errors.Is(err, ErrMaintenance),
+		errors.Is(err, ErrServerFull),
+		errors.Is(err, ErrDuplicateLogin),
+		errors.Is(err, ErrPingRequired):
+		return AuditOutcomeDenied
+	default:
+		return AuditOutcomeError
+	}
+}
+
+// nextStateName returns the state the client requested in the handshake
+func nextStateName(hs handshaking.ServerBoundHandshake) string {
+	switch {
+	case hs.IsStatusRequest():
+		return "status"
+	case hs.IsLoginRequest():
+		return "login"
+	default:
+		return strconv.Itoa(int(hs.NextState))
+	}
+}
+
+// addrString returns the IP of the address or the whole address if it has no IP
+func addrString(addr net.Addr) string {
+	if ip := addrIP(addr); ip != nil {
+		return ip.String()
+	}
+	return addr.String()
+}
+
+// AuditLogConfig configures where the audit log is written to and when it is rotated
+type AuditLogConfig struct {
+	// Path of the current log file. Rotated files get the time of rotation appended to their name.
+	Path string
+	// MaxSize is the size in bytes after which the file is rotated. Zero disables size based rotation.
+	MaxSize int64
+	// MaxAge is the time after which the file is rotated. Zero disables time based rotation.
+	MaxAge time.Duration
+	// MaxBackups is the number of rotated files that are kept. Zero keeps all of them.
+	MaxBackups int
+	// Compress rotated files with gzip
+	Compress bool
+}
+
+// AuditLog is an append-only JSONL log of all connections of a gateway
+type AuditLog struct {
+	cfg AuditLogConfig
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	wg       sync.WaitGroup
+}
+
+// OpenAuditLog opens the file of the config for appending and creates it if necessary
+func OpenAuditLog(cfg AuditLogConfig) (*AuditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0755); err != nil {
+		return nil, err
+	}
+
+	auditLog := &AuditLog{cfg: cfg}
+	if err := auditLog.open(time.Now()); err != nil {
+		return nil, err
+	}
+	return auditLog, nil
+}
+
+func (auditLog *AuditLog) open(now time.Time) error {
+	file, err := os.OpenFile(auditLog.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	auditLog.file = file
+	auditLog.size = info.Size()
+	auditLog.openedAt = now
+	return nil
+}
+
+// Write appends the entry as a single line and rotates the file beforehand if it is due
+func (auditLog *AuditLog) Write(entry AuditEntry) error {
+	if auditLog == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	auditLog.mu.Lock()
+	defer auditLog.mu.Unlock()
+
+	if auditLog.file == nil {
+		return os.ErrClosed
+	}
+
+	now := time.Now()
+	if auditLog.shouldRotate(int64(len(line)), now) {
+		if err := auditLog.rotate(now); err != nil {
+			return err
+		}
+	}
+
+	n, err := auditLog.file.Write(line)
+	auditLog.size += int64(n)
+	return err
+}
+
+func (auditLog *AuditLog) shouldRotate(size int64, now time.Time) bool {
+	if auditLog.size == 0 {
+		return false
+	}
+
+	if auditLog.cfg.MaxSize > 0 && auditLog.size+size > auditLog.cfg.MaxSize {
+		return true
+	}
+
+	return auditLog.cfg.MaxAge > 0 && now.Sub(auditLog.openedAt) >= auditLog.cfg.MaxAge
+}
+
+// rotate renames the current file, opens a new one and compresses and prunes the old files in the background
+func (auditLog *AuditLog) rotate(now time.Time) error {
+	if err := auditLog.file.Close(); err != nil {
+		return err
+	}
+
+	ext := filepath.Ext(auditLog.cfg.Path)
+	rotatedPath := strings.TrimSuffix(auditLog.cfg.Path, ext) + "-" + now.Format(auditLogTimeFormat) + ext
+	if err := os.Rename(auditLog.cfg.Path, rotatedPath); err != nil {
+		return err
+	}
+
+	if err := auditLog.open(now); err != nil {
+		return err
+	}
+
+	auditLog.wg.Add(1)
+	go func() {
+		defer auditLog.wg.Done()
+
+		if auditLog.cfg.Compress {
+			if err := compressFile(rotatedPath); err != nil {
+				log.Printf("[w] Failed compressing audit log %s; error: %s", rotatedPath, err)
+			}
+		}
+
+		if err := auditLog.prune(); err != nil {
+			log.Printf("[w] Failed removing old audit logs; error: %s", err)
+		}
+	}()
+	return nil
+}
+
+// prune removes the oldest rotated files that exceed MaxBackups
+func (auditLog *AuditLog) prune() error {
+	if auditLog.cfg.MaxBackups <= 0 {
+		return nil
+	}
+
+	ext := filepath.Ext(auditLog.cfg.Path)
+	paths, err := filepath.Glob(strings.TrimSuffix(auditLog.cfg.Path, ext) + "-*" + ext + "*")
+	if err != nil {
+		return err
+	}
+
+	// The time format sorts rotated files from old to new
+	sort.Strings(paths)
+	for len(paths) > auditLog.cfg.MaxBackups {
+		if err := os.Remove(paths[0]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		paths = paths[1:]
+	}
+	return nil
+}
+
+// Close closes the current file and waits for pending compressions
+func (auditLog *AuditLog) Close() error {
+	if auditLog == nil {
+		return nil
+	}
+
+	auditLog.mu.Lock()
+	var err error
+	if auditLog.file != nil {
+		err = auditLog.file.Close()
+		auditLog.file = nil
+	}
+	auditLog.mu.Unlock()
+
+	auditLog.wg.Wait()
+	return err
+}
+
+// compressFile replaces the file with a gzip compressed copy of it
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		gz.Close()
+		dst.Close()
+		os.Remove(dst.Name())
+		return err
+	}
+
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return err
+	}
+
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	src.Close()
+	return os.Remove(path)
+}
@@ -0,0 +1,168 @@
+package infrared
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditOutcome(t *testing.T) {
+	tt := []struct {
+		backend  string
+		err      error
+		expected string
+	}{
+		{backend: "localhost:25565", expected: AuditOutcomeProxied},
+		{expected: AuditOutcomeServed},
+		{err: fmt.Errorf("%w by ip limit", ErrRateLimited), expected: AuditOutcomeRateLimited},
+		{err: TimeoutError{Phase: TimeoutPhaseLogin}, expected: AuditOutcomeTimeout},
+		{err: fmt.Errorf("%w with uid example.com@:25565", ErrNoProxy), expected: AuditOutcomeUnknownProxy},
+		{err: ErrMaintenance, expected: AuditOutcomeDenied},
+		{err: fmt.Errorf("%w; %q", ErrCountryDenied, "XX"), expected: AuditOutcomeDenied},
+		{backend: "localhost:25565", err: errors.New("broken pipe"), expected: AuditOutcomeError},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.expected, func(t *testing.T) {
+			entry := AuditEntry{Backend: tc.backend}
+			if outcome := auditOutcome(&entry, tc.err); outcome != tc.expected {
+				t.Errorf("got: %v; want: %v", outcome, tc.expected)
+			}
+		})
+	}
+}
+
+func readAuditLines(t *testing.T, path string) []AuditEntry {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer gz.Close()
+		reader = bufio.NewReader(gz)
+	}
+
+	var entries []AuditEntry
+	decoder := json.NewDecoder(reader)
+	for decoder.More() {
+		var entry AuditEntry
+		if err := decoder.Decode(&entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestAuditLog_Rotate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.jsonl")
+
+	line, err := json.Marshal(AuditEntry{Username: "Steve"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auditLog, err := OpenAuditLog(AuditLogConfig{
+		Path: path,
+		// Two entries fit into a file
+		MaxSize:    int64(len(line)+1) * 2,
+		MaxBackups: 2,
+		Compress:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 7; i++ {
+		if err := auditLog.Write(AuditEntry{Username: "Steve"}); err != nil {
+			t.Fatal(err)
+		}
+		// Rotated files are named by the millisecond they were rotated at
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	if err := auditLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if entries := readAuditLines(t, path); len(entries) != 1 {
+		t.Errorf("got: %d entries in current file; want: 1", len(entries))
+	}
+
+	rotated, err := filepath.Glob(filepath.Join(dir, "audit-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rotated) != 2 {
+		t.Fatalf("got: %v; want 2 rotated files", rotated)
+	}
+
+	for _, path := range rotated {
+		if !strings.HasSuffix(path, ".jsonl.gz") {
+			t.Errorf("%s should be compressed", path)
+			continue
+		}
+
+		entries := readAuditLines(t, path)
+		if len(entries) != 2 || entries[0].Username != "Steve" {
+			t.Errorf("got: %v; want 2 entries of Steve", entries)
+		}
+	}
+}
+
+func TestAuditLog_RotateByAge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.jsonl")
+
+	auditLog, err := OpenAuditLog(AuditLogConfig{
+		Path:   path,
+		MaxAge: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+
+	if err := auditLog.Write(AuditEntry{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := auditLog.Write(AuditEntry{}); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if err := auditLog.Write(AuditEntry{}); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := filepath.Glob(filepath.Join(dir, "audit-*.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rotated) != 1 {
+		t.Fatalf("got: %v; want 1 rotated file", rotated)
+	}
+
+	if entries := readAuditLines(t, rotated[0]); len(entries) != 2 {
+		t.Errorf("got: %d entries in rotated file; want: 2", len(entries))
+	}
+}
@@ -6,6 +6,10 @@ const (
 	EventTypePlayerLeave    string = "PlayerLeave"
 	EventTypeContainerStart string = "ContainerStart"
 	EventTypeContainerStop  string = "ContainerStop"
+	EventTypeRateLimited    string = "RateLimited"
+	EventTypeLoginDenied    string = "LoginDenied"
+	EventTypeAttackModeOn   string = "AttackModeOn"
+	EventTypeAttackModeOff  string = "AttackModeOff"
 )
 
 type Event interface {
@@ -26,6 +30,8 @@ type PlayerJoinEvent struct {
 	RemoteAddress string `json:"remoteAddress"`
 	TargetAddress string `json:"targetAddress"`
 	ProxyUID      string `json:"proxyUid"`
+	Country       string `json:"country"`
+	ASN           uint   `json:"asn"`
 }
 
 func (event PlayerJoinEvent) EventType() string {
@@ -58,3 +64,42 @@ type ContainerStopEvent struct {
 func (event ContainerStopEvent) EventType() string {
 	return EventTypeContainerStop
 }
+
+type RateLimitedEvent struct {
+	RemoteAddress string `json:"remoteAddress"`
+	ProxyUID      string `json:"proxyUid"`
+	Reason        string `json:"reason"`
+}
+
+func (event RateLimitedEvent) EventType() string {
+	return EventTypeRateLimited
+}
+
+type LoginDeniedEvent struct {
+	Username      string `json:"username"`
+	RemoteAddress string `json:"remoteAddress"`
+	ProxyUID      string `json:"proxyUid"`
+	Reason        string `json:"reason"`
+}
+
+func (event LoginDeniedEvent) EventType() string {
+	return EventTypeLoginDenied
+}
+
+type AttackModeOnEvent struct {
+	ProxyUID string `json:"proxyUid"`
+	Listener string `json:"listener"`
+}
+
+func (event AttackModeOnEvent) EventType() string {
+	return EventTypeAttackModeOn
+}
+
+type AttackModeOffEvent struct {
+	ProxyUID string `json:"proxyUid"`
+	Listener string `json:"listener"`
+}
+
+func (event AttackModeOffEvent) EventType() string {
+	return EventTypeAttackModeOff
+}
@@ -27,6 +27,22 @@ func TestErrorEvent_EventType(t *testing.T) {
 			event:     ContainerStopEvent{},
 			eventType: EventTypeContainerStop,
 		},
+		{
+			event:     RateLimitedEvent{},
+			eventType: EventTypeRateLimited,
+		},
+		{
+			event:     LoginDeniedEvent{},
+			eventType: EventTypeLoginDenied,
+		},
+		{
+			event:     AttackModeOnEvent{},
+			eventType: EventTypeAttackModeOn,
+		},
+		{
+			event:     AttackModeOffEvent{},
+			eventType: EventTypeAttackModeOff,
+		},
 	}
 
 	for _, tc := range tt {
@@ -1,10 +1,18 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/haveachin/infrared/api"
 
@@ -13,28 +21,166 @@ import (
 
 const (
 	envPrefix               = "INFRARED_"
+	envConfigFile           = envPrefix + "CONFIG_FILE"
 	envConfigPath           = envPrefix + "CONFIG_PATH"
+	envConfigRecursive      = envPrefix + "CONFIG_RECURSIVE"
+	envConfigIgnore         = envPrefix + "CONFIG_IGNORE"
+	envConfigHTTPURL        = envPrefix + "CONFIG_HTTP_URL"
+	envConfigHTTPInterval   = envPrefix + "CONFIG_HTTP_INTERVAL"
+	envConfigHTTPLongPoll   = envPrefix + "CONFIG_HTTP_LONG_POLL"
+	envConfigHTTPToken      = envPrefix + "CONFIG_HTTP_TOKEN"
+	envConfigDocker         = envPrefix + "CONFIG_DOCKER"
+	envConfigDockerPrefix   = envPrefix + "CONFIG_DOCKER_LABEL_PREFIX"
+	envConfigKubernetes     = envPrefix + "CONFIG_KUBERNETES"
+	envConfigK8sNamespace   = envPrefix + "CONFIG_KUBERNETES_NAMESPACE"
+	envConfigK8sAPIServer   = envPrefix + "CONFIG_KUBERNETES_API_SERVER"
 	envReceiveProxyProtocol = envPrefix + "RECEIVE_PROXY_PROTOCOL"
+	envReceiveRealIP        = envPrefix + "RECEIVE_REAL_IP"
+	envRealIPTrustedCIDRs   = envPrefix + "REAL_IP_TRUSTED_CIDRS"
+	envRealIPPublicKey      = envPrefix + "REAL_IP_PUBLIC_KEY"
+	envAccessList           = envPrefix + "ACCESS_LIST"
+	envRateLimitIP          = envPrefix + "RATE_LIMIT_IP"
+	envRateLimitSubnet      = envPrefix + "RATE_LIMIT_SUBNET"
+	envRateLimitStatus      = envPrefix + "RATE_LIMIT_STATUS"
+	envRateLimitLogin       = envPrefix + "RATE_LIMIT_LOGIN"
+	envMaxConnsPerIP        = envPrefix + "MAX_CONNS_PER_IP"
+	envMaxConnections       = envPrefix + "MAX_CONNECTIONS"
+	envDuplicateLogin       = envPrefix + "DUPLICATE_LOGIN"
+	envHandshakeTimeout     = envPrefix + "HANDSHAKE_TIMEOUT"
+	envStatusTimeout        = envPrefix + "STATUS_TIMEOUT"
+	envLoginTimeout         = envPrefix + "LOGIN_TIMEOUT"
+	envIdleTimeout          = envPrefix + "IDLE_TIMEOUT"
+	envAttackThreshold      = envPrefix + "ATTACK_THRESHOLD"
+	envAttackCooldown       = envPrefix + "ATTACK_COOLDOWN"
+	envAttackPingWindow     = envPrefix + "ATTACK_PING_WINDOW"
+	envAttackMessage        = envPrefix + "ATTACK_MESSAGE"
+	envAttackRateLimitIP    = envPrefix + "ATTACK_RATE_LIMIT_IP"
+	envAttackMaxConnsPerIP  = envPrefix + "ATTACK_MAX_CONNS_PER_IP"
+	envGeoIPDatabases       = envPrefix + "GEOIP_DATABASES"
+	envAuditLog             = envPrefix + "AUDIT_LOG"
+	envAuditLogMaxSize      = envPrefix + "AUDIT_LOG_MAX_SIZE"
+	envAuditLogMaxAge       = envPrefix + "AUDIT_LOG_MAX_AGE"
+	envAuditLogMaxBackups   = envPrefix + "AUDIT_LOG_MAX_BACKUPS"
+	envAuditLogCompress     = envPrefix + "AUDIT_LOG_COMPRESS"
+	envLogFile              = envPrefix + "LOG_FILE"
 	envApiEnabled           = envPrefix + "API_ENABLED"
 	envApiBind              = envPrefix + "API_BIND"
 	envPrometheusEnabled    = envPrefix + "PROMETHEUS_ENABLED"
 	envPrometheusBind       = envPrefix + "PROMETHEUS_BIND"
+	envPrometheusGeoLabels  = envPrefix + "PROMETHEUS_GEO_LABELS"
 )
 
 const (
+	clfConfigFile           = "config-file"
 	clfConfigPath           = "config-path"
+	clfConfigRecursive      = "config-recursive"
+	clfConfigIgnore         = "config-ignore"
+	clfConfigHTTPURL        = "config-http-url"
+	clfConfigHTTPInterval   = "config-http-interval"
+	clfConfigHTTPLongPoll   = "config-http-long-poll"
+	clfConfigHTTPToken      = "config-http-token"
+	clfConfigDocker         = "config-docker"
+	clfConfigDockerPrefix   = "config-docker-label-prefix"
+	clfConfigKubernetes     = "config-kubernetes"
+	clfConfigK8sNamespace   = "config-kubernetes-namespace"
+	clfConfigK8sAPIServer   = "config-kubernetes-api-server"
 	clfReceiveProxyProtocol = "receive-proxy-protocol"
+	clfReceiveRealIP        = "receive-real-ip"
+	clfRealIPTrustedCIDRs   = "real-ip-trusted-cidrs"
+	clfRealIPPublicKey      = "real-ip-public-key"
+	clfAccessList           = "access-list"
+	clfRateLimitIP          = "rate-limit-ip"
+	clfRateLimitSubnet      = "rate-limit-subnet"
+	clfRateLimitStatus      = "rate-limit-status"
+	clfRateLimitLogin       = "rate-limit-login"
+	clfMaxConnsPerIP        = "max-conns-per-ip"
+	clfMaxConnections       = "max-connections"
+	clfDuplicateLogin       = "duplicate-login"
+	clfHandshakeTimeout     = "handshake-timeout"
+	clfStatusTimeout        = "status-timeout"
+	clfLoginTimeout         = "login-timeout"
+	clfIdleTimeout          = "idle-timeout"
+	clfAttackThreshold      = "attack-threshold"
+	clfAttackCooldown       = "attack-cooldown"
+	clfAttackPingWindow     = "attack-ping-window"
+	clfAttackMessage        = "attack-message"
+	clfAttackRateLimitIP    = "attack-rate-limit-ip"
+	clfAttackMaxConnsPerIP  = "attack-max-conns-per-ip"
+	clfGeoIPDatabases       = "geoip-databases"
+	clfAuditLog             = "audit-log"
+	clfAuditLogMaxSize      = "audit-log-max-size"
+	clfAuditLogMaxAge       = "audit-log-max-age"
+	clfAuditLogMaxBackups   = "audit-log-max-backups"
+	clfAuditLogCompress     = "audit-log-compress"
+	clfLogFile              = "log-file"
+	clfApiEnabled           = "enable-api"
+	clfApiBind              = "api-bind"
 	clfPrometheusEnabled    = "enable-prometheus"
 	clfPrometheusBind       = "prometheus-bind"
+	clfPrometheusGeoLabels  = "prometheus-geo-labels"
+)
+
+// Defaults of the settings that are reloaded with the global config file
+const (
+	defaultHandshakeTimeout = 5 * time.Second
+	defaultStatusTimeout    = 10 * time.Second
+	defaultLoginTimeout     = 10 * time.Second
+	defaultIdleTimeout      = time.Duration(0)
+	defaultMaxConnections   = 0
+	defaultDuplicateLogin   = infrared.DuplicateLoginPolicyAllow
 )
 
 var (
+	configFile           = "infrared.yml"
 	configPath           = "./configs"
+	configRecursive      = false
+	configIgnore         = strings.Join(infrared.DefaultConfigIgnore, ",")
+	configHTTPURL        = ""
+	configHTTPInterval   = infrared.DefaultHTTPConfigInterval
+	configHTTPLongPoll   = time.Duration(0)
+	configHTTPToken      = ""
+	configDocker         = false
+	configDockerPrefix   = infrared.DefaultDockerLabelPrefix
+	configKubernetes     = false
+	configK8sNamespace   = ""
+	configK8sAPIServer   = ""
 	receiveProxyProtocol = false
+	receiveRealIP        = false
+	realIPTrustedCIDRs   = ""
+	realIPPublicKey      = ""
+	accessListPath       = ""
+	rateLimitIP          = ""
+	rateLimitSubnet      = ""
+	rateLimitStatus      = ""
+	rateLimitLogin       = ""
+	maxConnsPerIP        = 0
+	maxConnections       = defaultMaxConnections
+	duplicateLogin       = defaultDuplicateLogin
+	handshakeTimeout     = defaultHandshakeTimeout
+	statusTimeout        = defaultStatusTimeout
+	loginTimeout         = defaultLoginTimeout
+	idleTimeout          = defaultIdleTimeout
+	attackThreshold      = 0
+	attackCooldown       = infrared.DefaultAttackModeCooldown
+	attackPingWindow     = infrared.DefaultAttackModePingWindow
+	attackMessage        = infrared.DefaultAttackModeMessage
+	attackRateLimitIP    = ""
+	attackMaxConnsPerIP  = 0
 	prometheusEnabled    = false
 	prometheusBind       = ":9100"
+	prometheusGeoLabels  = false
+	geoIPDatabases       = ""
+	auditLogPath         = ""
+	auditLogMaxSize      = 100
+	auditLogMaxAge       = time.Duration(0)
+	auditLogMaxBackups   = 0
+	auditLogCompress     = false
+	logFile              = ""
 	apiEnabled           = false
 	apiBind              = "127.0.0.1:8080"
+
+	// listenerAddresses are the settings of single listeners from the global config
+	listenerAddresses map[string]infrared.GlobalListenerAddressConfig
 )
 
 func envBool(name string, value bool) bool {
@@ -51,6 +197,34 @@ func envBool(name string, value bool) bool {
 	return envBool
 }
 
+func envInt(name string, value int) int {
+	envString := os.Getenv(name)
+	if envString == "" {
+		return value
+	}
+
+	envInt, err := strconv.Atoi(envString)
+	if err != nil {
+		return value
+	}
+
+	return envInt
+}
+
+func envDuration(name string, value time.Duration) time.Duration {
+	envString := os.Getenv(name)
+	if envString == "" {
+		return value
+	}
+
+	envDuration, err := time.ParseDuration(envString)
+	if err != nil {
+		return value
+	}
+
+	return envDuration
+}
+
 func envString(name string, value string) string {
 	envString := os.Getenv(name)
 	if envString == "" {
@@ -62,67 +236,653 @@ func envString(name string, value string) string {
 
 func initEnv() {
 	configPath = envString(envConfigPath, configPath)
+	configRecursive = envBool(envConfigRecursive, configRecursive)
+	configIgnore = envString(envConfigIgnore, configIgnore)
+	configHTTPURL = envString(envConfigHTTPURL, configHTTPURL)
+	configHTTPInterval = envDuration(envConfigHTTPInterval, configHTTPInterval)
+	configHTTPLongPoll = envDuration(envConfigHTTPLongPoll, configHTTPLongPoll)
+	configHTTPToken = envString(envConfigHTTPToken, configHTTPToken)
+	configDocker = envBool(envConfigDocker, configDocker)
+	configDockerPrefix = envString(envConfigDockerPrefix, configDockerPrefix)
+	configKubernetes = envBool(envConfigKubernetes, configKubernetes)
+	configK8sNamespace = envString(envConfigK8sNamespace, configK8sNamespace)
+	configK8sAPIServer = envString(envConfigK8sAPIServer, configK8sAPIServer)
 	receiveProxyProtocol = envBool(envReceiveProxyProtocol, receiveProxyProtocol)
+	receiveRealIP = envBool(envReceiveRealIP, receiveRealIP)
+	realIPTrustedCIDRs = envString(envRealIPTrustedCIDRs, realIPTrustedCIDRs)
+	realIPPublicKey = envString(envRealIPPublicKey, realIPPublicKey)
+	accessListPath = envString(envAccessList, accessListPath)
+	rateLimitIP = envString(envRateLimitIP, rateLimitIP)
+	rateLimitSubnet = envString(envRateLimitSubnet, rateLimitSubnet)
+	rateLimitStatus = envString(envRateLimitStatus, rateLimitStatus)
+	rateLimitLogin = envString(envRateLimitLogin, rateLimitLogin)
+	maxConnsPerIP = envInt(envMaxConnsPerIP, maxConnsPerIP)
+	maxConnections = envInt(envMaxConnections, maxConnections)
+	duplicateLogin = envString(envDuplicateLogin, duplicateLogin)
+	handshakeTimeout = envDuration(envHandshakeTimeout, handshakeTimeout)
+	statusTimeout = envDuration(envStatusTimeout, statusTimeout)
+	loginTimeout = envDuration(envLoginTimeout, loginTimeout)
+	idleTimeout = envDuration(envIdleTimeout, idleTimeout)
+	attackThreshold = envInt(envAttackThreshold, attackThreshold)
+	attackCooldown = envDuration(envAttackCooldown, attackCooldown)
+	attackPingWindow = envDuration(envAttackPingWindow, attackPingWindow)
+	attackMessage = envString(envAttackMessage, attackMessage)
+	attackRateLimitIP = envString(envAttackRateLimitIP, attackRateLimitIP)
+	attackMaxConnsPerIP = envInt(envAttackMaxConnsPerIP, attackMaxConnsPerIP)
+	geoIPDatabases = envString(envGeoIPDatabases, geoIPDatabases)
+	auditLogPath = envString(envAuditLog, auditLogPath)
+	auditLogMaxSize = envInt(envAuditLogMaxSize, auditLogMaxSize)
+	auditLogMaxAge = envDuration(envAuditLogMaxAge, auditLogMaxAge)
+	auditLogMaxBackups = envInt(envAuditLogMaxBackups, auditLogMaxBackups)
+	auditLogCompress = envBool(envAuditLogCompress, auditLogCompress)
+	logFile = envString(envLogFile, logFile)
 	apiEnabled = envBool(envApiEnabled, apiEnabled)
 	apiBind = envString(envApiBind, apiBind)
 	prometheusEnabled = envBool(envPrometheusEnabled, prometheusEnabled)
 	prometheusBind = envString(envPrometheusBind, prometheusBind)
+	prometheusGeoLabels = envBool(envPrometheusGeoLabels, prometheusGeoLabels)
 }
 
 func initFlags() {
+	flag.StringVar(&configFile, clfConfigFile, configFile, "path of the global config file")
 	flag.StringVar(&configPath, clfConfigPath, configPath, "path of all proxy configs")
+	flag.BoolVar(&configRecursive, clfConfigRecursive, configRecursive, "should load and watch proxy configs in subdirectories of the config path")
+	flag.StringVar(&configIgnore, clfConfigIgnore, configIgnore, "comma separated glob patterns of config files and directories that are skipped")
+	flag.StringVar(&configHTTPURL, clfConfigHTTPURL, configHTTPURL, "URL of an endpoint that proxy configs are polled from")
+	flag.DurationVar(&configHTTPInterval, clfConfigHTTPInterval, configHTTPInterval, "time between two polls of the proxy config endpoint")
+	flag.DurationVar(&configHTTPLongPoll, clfConfigHTTPLongPoll, configHTTPLongPoll, "time that the proxy config endpoint may hold a poll until the configs change; 0 disables long polling")
+	flag.StringVar(&configHTTPToken, clfConfigHTTPToken, configHTTPToken, "bearer token that is sent to the proxy config endpoint")
+	flag.BoolVar(&configDocker, clfConfigDocker, configDocker, "should create proxy configs from the labels of Docker containers")
+	flag.StringVar(&configDockerPrefix, clfConfigDockerPrefix, configDockerPrefix, "prefix of the Docker labels that proxy configs are created from")
+	flag.BoolVar(&configKubernetes, clfConfigKubernetes, configKubernetes, "should create proxy configs from the annotations of Kubernetes Services")
+	flag.StringVar(&configK8sNamespace, clfConfigK8sNamespace, configK8sNamespace, "namespace of the Kubernetes Services; all namespaces if empty")
+	flag.StringVar(&configK8sAPIServer, clfConfigK8sAPIServer, configK8sAPIServer, "URL of the Kubernetes API server; the cluster that Infrared runs in if empty")
 	flag.BoolVar(&receiveProxyProtocol, clfReceiveProxyProtocol, receiveProxyProtocol, "should accept proxy protocol")
+	flag.BoolVar(&receiveRealIP, clfReceiveRealIP, receiveRealIP, "should accept RealIP handshakes from trusted sources")
+	flag.StringVar(&realIPTrustedCIDRs, clfRealIPTrustedCIDRs, realIPTrustedCIDRs, "comma separated CIDRs that are allowed to send RealIP handshakes")
+	flag.StringVar(&realIPPublicKey, clfRealIPPublicKey, realIPPublicKey, "path of the public key to verify RealIP signatures with")
+	flag.StringVar(&accessListPath, clfAccessList, accessListPath, "path of the global IP access list")
+	flag.StringVar(&rateLimitIP, clfRateLimitIP, rateLimitIP, "new connections per second and burst per IP; format: rate[:burst]")
+	flag.StringVar(&rateLimitSubnet, clfRateLimitSubnet, rateLimitSubnet, "new connections per second and burst per /24 or /64 network; format: rate[:burst]")
+	flag.StringVar(&rateLimitStatus, clfRateLimitStatus, rateLimitStatus, "status handshakes per second and burst per IP; format: rate[:burst]")
+	flag.StringVar(&rateLimitLogin, clfRateLimitLogin, rateLimitLogin, "login handshakes per second and burst per IP; format: rate[:burst]")
+	flag.IntVar(&maxConnsPerIP, clfMaxConnsPerIP, maxConnsPerIP, "maximum concurrent connections per IP")
+	flag.IntVar(&maxConnections, clfMaxConnections, maxConnections, "maximum players of all proxies per listener")
+	flag.StringVar(&duplicateLogin, clfDuplicateLogin, duplicateLogin, "what happens if a connected player joins again; one of allow, reject or kick")
+	flag.DurationVar(&handshakeTimeout, clfHandshakeTimeout, handshakeTimeout, "time a client has to send the proxy protocol header and handshake")
+	flag.DurationVar(&statusTimeout, clfStatusTimeout, statusTimeout, "time a client has to finish the status request and ping")
+	flag.DurationVar(&loginTimeout, clfLoginTimeout, loginTimeout, "time a client has to send the login start")
+	flag.DurationVar(&idleTimeout, clfIdleTimeout, idleTimeout, "time a session can go without receiving data; 0 disables it")
+	flag.IntVar(&attackThreshold, clfAttackThreshold, attackThreshold, "new connections per second on a listener that turn on the attack mode; 0 disables it")
+	flag.DurationVar(&attackCooldown, clfAttackCooldown, attackCooldown, "time without an attack after which the attack mode turns off")
+	flag.DurationVar(&attackPingWindow, clfAttackPingWindow, attackPingWindow, "time in which a client has to have pinged the server before logging in during an attack")
+	flag.StringVar(&attackMessage, clfAttackMessage, attackMessage, "disconnect message for logins without a ping during an attack")
+	flag.StringVar(&attackRateLimitIP, clfAttackRateLimitIP, attackRateLimitIP, "new connections per second and burst per IP during an attack; format: rate[:burst]")
+	flag.IntVar(&attackMaxConnsPerIP, clfAttackMaxConnsPerIP, attackMaxConnsPerIP, "maximum concurrent connections per IP during an attack")
+	flag.StringVar(&geoIPDatabases, clfGeoIPDatabases, geoIPDatabases, "comma separated paths of MaxMind DB files to look up countries and ASNs")
+	flag.StringVar(&auditLogPath, clfAuditLog, auditLogPath, "path of the JSONL audit log of all connections")
+	flag.IntVar(&auditLogMaxSize, clfAuditLogMaxSize, auditLogMaxSize, "size in megabytes after which the audit log is rotated; 0 disables it")
+	flag.DurationVar(&auditLogMaxAge, clfAuditLogMaxAge, auditLogMaxAge, "time after which the audit log is rotated; 0 disables it")
+	flag.IntVar(&auditLogMaxBackups, clfAuditLogMaxBackups, auditLogMaxBackups, "number of rotated audit logs to keep; 0 keeps all")
+	flag.BoolVar(&auditLogCompress, clfAuditLogCompress, auditLogCompress, "should compress rotated audit logs with gzip")
+	flag.StringVar(&logFile, clfLogFile, logFile, "path of a file that logs are written to in addition to stderr")
+	flag.BoolVar(&apiEnabled, clfApiEnabled, apiEnabled, "should run the REST API")
+	flag.StringVar(&apiBind, clfApiBind, apiBind, "bind address and/or port for the REST API")
 	flag.BoolVar(&prometheusEnabled, clfPrometheusEnabled, prometheusEnabled, "should run prometheus client exposing metrics")
 	flag.StringVar(&prometheusBind, clfPrometheusBind, prometheusBind, "bind address and/or port for prometheus")
+	flag.BoolVar(&prometheusGeoLabels, clfPrometheusGeoLabels, prometheusGeoLabels, "should export connected players by country and ASN")
 	flag.Parse()
 }
 
 func init() {
+	initGlobalConfig()
 	initEnv()
 	initFlags()
 }
 
+// initGlobalConfig applies the global config file before the environment variables and flags,
+// so that they take precedence over it. Its path has to be known before the flags are parsed.
+func initGlobalConfig() {
+	configFile = envString(envConfigFile, configFile)
+	path, explicit := lookupFlag(os.Args[1:], clfConfigFile)
+	if explicit {
+		configFile = path
+	} else {
+		explicit = os.Getenv(envConfigFile) != ""
+	}
+
+	if _, err := os.Stat(configFile); os.IsNotExist(err) && !explicit {
+		return
+	}
+
+	cfg, err := infrared.LoadGlobalConfig(configFile)
+	if err != nil {
+		log.Fatalf("Failed loading global config %s; error: %s", configFile, err)
+	}
+	applyGlobalConfig(cfg)
+}
+
+// lookupFlag finds the value of a flag in the command-line arguments
+func lookupFlag(args []string, name string) (string, bool) {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+
+		trimmed := strings.TrimLeft(arg, "-")
+		if dashes := len(arg) - len(trimmed); dashes < 1 || dashes > 2 {
+			continue
+		}
+
+		if trimmed == name && i+1 < len(args) {
+			return args[i+1], true
+		}
+
+		if strings.HasPrefix(trimmed, name+"=") {
+			return strings.TrimPrefix(trimmed, name+"="), true
+		}
+	}
+	return "", false
+}
+
+func applyGlobalConfig(cfg infrared.GlobalConfig) {
+	setString(&configPath, cfg.ConfigPath)
+	setBool(&configRecursive, cfg.ConfigRecursive)
+	if cfg.ConfigIgnore != nil {
+		configIgnore = strings.Join(cfg.ConfigIgnore, ",")
+	}
+	setString(&configHTTPURL, cfg.ConfigHTTP.URL)
+	setDuration(&configHTTPInterval, cfg.ConfigHTTP.Interval)
+	setDuration(&configHTTPLongPoll, cfg.ConfigHTTP.LongPoll)
+	setString(&configHTTPToken, cfg.ConfigHTTP.Token)
+	setBool(&configDocker, cfg.ConfigDocker.Enabled)
+	setString(&configDockerPrefix, cfg.ConfigDocker.LabelPrefix)
+	setBool(&configKubernetes, cfg.ConfigKubernetes.Enabled)
+	setString(&configK8sNamespace, cfg.ConfigKubernetes.Namespace)
+	setString(&configK8sAPIServer, cfg.ConfigKubernetes.APIServer)
+
+	listener := cfg.Listener
+	setBool(&receiveProxyProtocol, listener.ReceiveProxyProtocol)
+	setBool(&receiveRealIP, listener.ReceiveRealIP)
+	if listener.RealIPTrustedCIDRs != nil {
+		realIPTrustedCIDRs = strings.Join(listener.RealIPTrustedCIDRs, ",")
+	}
+	setString(&realIPPublicKey, listener.RealIPPublicKey)
+	setString(&accessListPath, listener.AccessList)
+	setString(&rateLimitIP, listener.RateLimit.IP)
+	setString(&rateLimitSubnet, listener.RateLimit.Subnet)
+	setString(&rateLimitStatus, listener.RateLimit.Status)
+	setString(&rateLimitLogin, listener.RateLimit.Login)
+	setInt(&maxConnsPerIP, listener.RateLimit.MaxConnsPerIP)
+	setInt(&maxConnections, listener.MaxConnections)
+	setString(&duplicateLogin, listener.DuplicateLogin.Policy)
+	setDuration(&handshakeTimeout, listener.Timeouts.Handshake)
+	setDuration(&statusTimeout, listener.Timeouts.Status)
+	setDuration(&loginTimeout, listener.Timeouts.Login)
+	setDuration(&idleTimeout, listener.Timeouts.Idle)
+	setInt(&attackThreshold, listener.AttackMode.Threshold)
+	setDuration(&attackCooldown, listener.AttackMode.Cooldown)
+	setDuration(&attackPingWindow, listener.AttackMode.PingWindow)
+	setString(&attackMessage, listener.AttackMode.Message)
+	setString(&attackRateLimitIP, listener.AttackMode.RateLimitIP)
+	setInt(&attackMaxConnsPerIP, listener.AttackMode.MaxConnsPerIP)
+	if listener.GeoIPDatabases != nil {
+		geoIPDatabases = strings.Join(listener.GeoIPDatabases, ",")
+	}
+	listenerAddresses = cfg.Listeners
+
+	setBool(&apiEnabled, cfg.API.Enabled)
+	setString(&apiBind, cfg.API.Bind)
+	setBool(&prometheusEnabled, cfg.Prometheus.Enabled)
+	setString(&prometheusBind, cfg.Prometheus.Bind)
+	setBool(&prometheusGeoLabels, cfg.Prometheus.GeoLabels)
+
+	setString(&logFile, cfg.Logging.File)
+	setString(&auditLogPath, cfg.Logging.AuditLog.Path)
+	setInt(&auditLogMaxSize, cfg.Logging.AuditLog.MaxSize)
+	setDuration(&auditLogMaxAge, cfg.Logging.AuditLog.MaxAge)
+	setInt(&auditLogMaxBackups, cfg.Logging.AuditLog.MaxBackups)
+	setBool(&auditLogCompress, cfg.Logging.AuditLog.Compress)
+
+	infrared.SetProxyDefaults(cfg.Defaults)
+}
+
+func setString(value *string, cfgValue *string) {
+	if cfgValue != nil {
+		*value = *cfgValue
+	}
+}
+
+func setBool(value *bool, cfgValue *bool) {
+	if cfgValue != nil {
+		*value = *cfgValue
+	}
+}
+
+func setInt(value *int, cfgValue *int) {
+	if cfgValue != nil {
+		*value = *cfgValue
+	}
+}
+
+func setDuration(value *time.Duration, cfgValue *infrared.Duration) {
+	if cfgValue != nil {
+		*value = time.Duration(*cfgValue)
+	}
+}
+
+// reloadGlobalConfig applies the settings of the global config file that are safe to change
+// while Infrared is running. Settings that are set by a flag or an environment variable are kept.
+func reloadGlobalConfig(gateway *infrared.Gateway) {
+	log.Println("Reloading", configFile)
+	cfg, err := infrared.LoadGlobalConfig(configFile)
+	if err != nil {
+		log.Printf("Failed reloading %s; error: %s", configFile, err)
+		return
+	}
+
+	listener := cfg.Listener
+	gateway.UpdateSettings(infrared.GatewaySettings{
+		Timeouts: infrared.TimeoutConfig{
+			Handshake: reloadDuration(clfHandshakeTimeout, envHandshakeTimeout, handshakeTimeout, listener.Timeouts.Handshake, defaultHandshakeTimeout),
+			Status:    reloadDuration(clfStatusTimeout, envStatusTimeout, statusTimeout, listener.Timeouts.Status, defaultStatusTimeout),
+			Login:     reloadDuration(clfLoginTimeout, envLoginTimeout, loginTimeout, listener.Timeouts.Login, defaultLoginTimeout),
+			Idle:      reloadDuration(clfIdleTimeout, envIdleTimeout, idleTimeout, listener.Timeouts.Idle, defaultIdleTimeout),
+		},
+		MaxConnections: reloadInt(clfMaxConnections, envMaxConnections, maxConnections, listener.MaxConnections, defaultMaxConnections),
+		DuplicateLogin: infrared.DuplicateLoginConfig{
+			Policy: reloadString(clfDuplicateLogin, envDuplicateLogin, duplicateLogin, listener.DuplicateLogin.Policy, defaultDuplicateLogin),
+		},
+	})
+	infrared.SetProxyDefaults(cfg.Defaults)
+
+	log.Printf("Reloaded timeouts, connection limits, duplicate logins and proxy defaults; the defaults apply to proxy configs that are loaded or changed afterwards and other settings of %s apply after a restart", configFile)
+}
+
+// isPinned checks if a setting is set by a flag or an environment variable
+func isPinned(clf, env string) bool {
+	if os.Getenv(env) != "" {
+		return true
+	}
+
+	pinned := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == clf {
+			pinned = true
+		}
+	})
+	return pinned
+}
+
+func reloadDuration(clf, env string, value time.Duration, cfgValue *infrared.Duration, defaultValue time.Duration) time.Duration {
+	if isPinned(clf, env) {
+		return value
+	}
+	if cfgValue != nil {
+		return time.Duration(*cfgValue)
+	}
+	return defaultValue
+}
+
+func reloadInt(clf, env string, value int, cfgValue *int, defaultValue int) int {
+	if isPinned(clf, env) {
+		return value
+	}
+	if cfgValue != nil {
+		return *cfgValue
+	}
+	return defaultValue
+}
+
+func reloadString(clf, env string, value string, cfgValue *string, defaultValue string) string {
+	if isPinned(clf, env) {
+		return value
+	}
+	if cfgValue != nil {
+		return *cfgValue
+	}
+	return defaultValue
+}
+
+func loadRealIPConfig(receive bool, trustedCIDRs, publicKeyPath string) (infrared.RealIPConfig, error) {
+	var cfg infrared.RealIPConfig
+	cidrs, err := infrared.ParseCIDRs(trustedCIDRs)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.TrustedCIDRs = cidrs
+
+	// Without trusted sources every client could spoof its address
+	if receive && len(cidrs) == 0 {
+		return cfg, errors.New("receiving RealIP requires trusted CIDRs")
+	}
+
+	if publicKeyPath != "" {
+		publicKey, err := infrared.LoadRealIPPublicKey(publicKeyPath)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.PublicKey = publicKey
+	}
+
+	return cfg, nil
+}
+
+// loadListenerConfigs returns the settings of the listeners in the global config.
+// Their fields that are not set keep the settings of all listeners.
+func loadListenerConfigs() (map[string]infrared.ListenerConfig, error) {
+	cfgs := map[string]infrared.ListenerConfig{}
+	for addr, listener := range listenerAddresses {
+		receive, trustedCIDRs, publicKeyPath := receiveRealIP, realIPTrustedCIDRs, realIPPublicKey
+		setBool(&receive, listener.ReceiveRealIP)
+		if listener.RealIPTrustedCIDRs != nil {
+			trustedCIDRs = strings.Join(listener.RealIPTrustedCIDRs, ",")
+		}
+		setString(&publicKeyPath, listener.RealIPPublicKey)
+
+		realIPCfg, err := loadRealIPConfig(receive, trustedCIDRs, publicKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("listener %s: %w", addr, err)
+		}
+
+		ip, subnet, status, login, maxConcurrentPerIP := rateLimitIP, rateLimitSubnet, rateLimitStatus, rateLimitLogin, maxConnsPerIP
+		setString(&ip, listener.RateLimit.IP)
+		setString(&subnet, listener.RateLimit.Subnet)
+		setString(&status, listener.RateLimit.Status)
+		setString(&login, listener.RateLimit.Login)
+		setInt(&maxConcurrentPerIP, listener.RateLimit.MaxConnsPerIP)
+
+		rateLimitCfg, err := loadRateLimitConfig(ip, subnet, status, login, maxConcurrentPerIP)
+		if err != nil {
+			return nil, fmt.Errorf("listener %s: %w", addr, err)
+		}
+
+		cfgs[addr] = infrared.ListenerConfig{
+			ReceiveRealIP: receive,
+			RealIP:        realIPCfg,
+			RateLimit:     rateLimitCfg,
+		}
+	}
+	return cfgs, nil
+}
+
+func loadRateLimitConfig(ip, subnet, status, login string, maxConcurrentPerIP int) (infrared.ListenerRateLimitConfig, error) {
+	cfg := infrared.ListenerRateLimitConfig{
+		MaxConcurrentPerIP: maxConcurrentPerIP,
+	}
+
+	var err error
+	if cfg.PerIP, err = infrared.ParseRateLimit(ip); err != nil {
+		return cfg, err
+	}
+
+	if cfg.PerSubnet, err = infrared.ParseRateLimit(subnet); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Handshake.Status, err = infrared.ParseRateLimit(status); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Handshake.Login, err = infrared.ParseRateLimit(login); err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+func loadAttackModeConfig() (infrared.AttackModeConfig, error) {
+	cfg := infrared.AttackModeConfig{
+		Threshold:  attackThreshold,
+		Cooldown:   attackCooldown,
+		PingWindow: attackPingWindow,
+		Message:    attackMessage,
+		RateLimit: infrared.ListenerRateLimitConfig{
+			MaxConcurrentPerIP: attackMaxConnsPerIP,
+		},
+	}
+
+	var err error
+	cfg.RateLimit.PerIP, err = infrared.ParseRateLimit(attackRateLimitIP)
+	return cfg, err
+}
+
+// validate checks the proxy configs in the paths or the config path and returns the exit code for CI.
+// The global config file is already validated before, when it is loaded.
+func validate(paths []string) int {
+	if len(paths) == 0 {
+		paths = []string{configPath}
+	}
+
+	var filePaths []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+
+		if !info.IsDir() {
+			filePaths = append(filePaths, path)
+			continue
+		}
+
+		folder, err := newConfigFolder(path)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+
+		dirFilePaths, err := folder.FilePaths()
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		filePaths = append(filePaths, dirFilePaths...)
+	}
+
+	if err := infrared.ValidateProxyConfigFiles(filePaths); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	fmt.Printf("%d proxy configs are valid\n", len(filePaths))
+	return 0
+}
+
+func newConfigFolder(path string) (*infrared.ConfigFolder, error) {
+	ignore, err := infrared.ParseConfigIgnore(configIgnore)
+	if err != nil {
+		return nil, err
+	}
+
+	return &infrared.ConfigFolder{
+		Path:      path,
+		Recursive: configRecursive,
+		Ignore:    ignore,
+	}, nil
+}
+
+// newConfigProviders returns the config folder unless the config path is empty,
+// the config endpoint if its URL is set and the Docker and Kubernetes discoveries if they are enabled
+func newConfigProviders() ([]infrared.ConfigProvider, error) {
+	var providers []infrared.ConfigProvider
+	if configPath != "" {
+		folder, err := newConfigFolder(configPath)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, folder)
+	}
+
+	if configHTTPURL != "" {
+		provider := &infrared.HTTPConfigProvider{
+			URL:      configHTTPURL,
+			Interval: configHTTPInterval,
+			LongPoll: configHTTPLongPoll,
+		}
+		if configHTTPToken != "" {
+			infrared.AddSecret(configHTTPToken)
+			provider.Header = http.Header{"Authorization": []string{"Bearer " + configHTTPToken}}
+		}
+		providers = append(providers, provider)
+	}
+
+	if configDocker {
+		providers = append(providers, &infrared.DockerConfigProvider{
+			LabelPrefix: configDockerPrefix,
+		})
+	}
+
+	if configKubernetes {
+		providers = append(providers, &infrared.KubernetesConfigProvider{
+			APIServer: configK8sAPIServer,
+			Namespace: configK8sNamespace,
+		})
+	}
+
+	if len(providers) == 0 {
+		return nil, errors.New("neither a config path, a config URL nor a discovery is set")
+	}
+	return providers, nil
+}
+
+func printSchema() int {
+	bb, err := json.MarshalIndent(infrared.ProxyConfigSchema(), "", "  ")
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	fmt.Println(string(bb))
+	return 0
+}
+
 func main() {
+	switch flag.Arg(0) {
+	case "validate":
+		os.Exit(validate(flag.Args()[1:]))
+	case "schema":
+		os.Exit(printSchema())
+	}
+
+	var logOutput io.Writer = os.Stderr
+	if logFile != "" {
+		file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Printf("Failed opening log file %s; error: %s", logFile, err)
+			return
+		}
+		defer file.Close()
+		logOutput = io.MultiWriter(os.Stderr, file)
+	}
+	// Passwords and secret files of proxy configs must not end up in the logs
+	log.SetOutput(infrared.NewRedactWriter(logOutput))
+
 	log.Println("Loading proxy configs")
 
-	cfgs, err := infrared.LoadProxyConfigsFromPath(configPath, false)
+	providers, err := newConfigProviders()
+	if err != nil {
+		log.Println("Failed loading config providers; error:", err)
+		return
+	}
+
+	realIPCfg, err := loadRealIPConfig(receiveRealIP, realIPTrustedCIDRs, realIPPublicKey)
 	if err != nil {
-		log.Printf("Failed loading proxy configs from %s; error: %s", configPath, err)
+		log.Println("Failed loading RealIP config; error:", err)
 		return
 	}
 
-	var proxies []*infrared.Proxy
-	for _, cfg := range cfgs {
-		proxies = append(proxies, &infrared.Proxy{
-			Config: cfg,
+	rateLimitCfg, err := loadRateLimitConfig(rateLimitIP, rateLimitSubnet, rateLimitStatus, rateLimitLogin, maxConnsPerIP)
+	if err != nil {
+		log.Println("Failed loading rate limit config; error:", err)
+		return
+	}
+
+	listenerCfgs, err := loadListenerConfigs()
+	if err != nil {
+		log.Println("Failed loading listener configs; error:", err)
+		return
+	}
+
+	attackModeCfg, err := loadAttackModeConfig()
+	if err != nil {
+		log.Println("Failed loading attack mode config; error:", err)
+		return
+	}
+
+	var geoIP *infrared.GeoIP
+	if geoIPDatabases != "" {
+		geoIP, err = infrared.LoadGeoIPFromPaths(strings.Split(geoIPDatabases, ","))
+		if err != nil {
+			log.Printf("Failed loading GeoIP databases from %s; error: %s", geoIPDatabases, err)
+			return
+		}
+	}
+
+	var auditLog *infrared.AuditLog
+	if auditLogPath != "" {
+		auditLog, err = infrared.OpenAuditLog(infrared.AuditLogConfig{
+			Path:       auditLogPath,
+			MaxSize:    int64(auditLogMaxSize) << 20,
+			MaxAge:     auditLogMaxAge,
+			MaxBackups: auditLogMaxBackups,
+			Compress:   auditLogCompress,
 		})
+		if err != nil {
+			log.Printf("Failed opening audit log %s; error: %s", auditLogPath, err)
+			return
+		}
 	}
 
-	outCfgs := make(chan *infrared.ProxyConfig)
-	go func() {
-		if err := infrared.WatchProxyConfigFolder(configPath, outCfgs); err != nil {
-			log.Println("Failed watching config folder; error:", err)
-			log.Println("SYSTEM FAILURE: CONFIG WATCHER FAILED")
+	var accessList *infrared.AccessList
+	if accessListPath != "" {
+		accessList, err = infrared.LoadAccessListFromPath(accessListPath)
+		if err != nil {
+			log.Printf("Failed loading access list from %s; error: %s", accessListPath, err)
+			return
 		}
-	}()
+	}
 
-	gateway := infrared.Gateway{ReceiveProxyProtocol: receiveProxyProtocol}
-	go func() {
-		for {
-			cfg, ok := <-outCfgs
-			if !ok {
-				return
+	gateway := infrared.Gateway{
+		ReceiveProxyProtocol: receiveProxyProtocol,
+		ReceiveRealIP:        receiveRealIP,
+		RealIP:               realIPCfg,
+		Listeners:            listenerCfgs,
+		RateLimit:            rateLimitCfg,
+		AccessList:           accessList,
+		AttackMode:           attackModeCfg,
+		GeoIP:                geoIP,
+		PrometheusGeoLabels:  prometheusGeoLabels,
+		MaxConnections:       maxConnections,
+		AuditLog:             auditLog,
+		DuplicateLogin: infrared.DuplicateLoginConfig{
+			Policy: duplicateLogin,
+		},
+		Timeouts: infrared.TimeoutConfig{
+			Handshake: handshakeTimeout,
+			Status:    statusTimeout,
+			Login:     loginTimeout,
+			Idle:      idleTimeout,
+		},
+	}
+	events := make(chan infrared.ConfigEvent)
+	for _, provider := range providers {
+		go func(provider infrared.ConfigProvider) {
+			if err := provider.Provide(context.Background(), events); err != nil {
+				log.Fatal("Failed providing proxy configs; error: ", err)
 			}
+		}(provider)
+	}
 
-			proxy := &infrared.Proxy{Config: cfg}
-			if err := gateway.RegisterProxy(proxy); err != nil {
-				log.Println("Failed registering proxy; error:", err)
-			}
+	go func() {
+		if err := infrared.WatchGlobalConfig(configFile, func() { reloadGlobalConfig(&gateway) }); err != nil {
+			log.Printf("Failed watching global config %s; error: %s", configFile, err)
 		}
 	}()
 
 	if apiEnabled {
-		go api.ListenAndServe(configPath, apiBind)
+		go api.ListenAndServe(configPath, apiBind, &gateway.Players)
 	}
 
 	if prometheusEnabled {
@@ -130,9 +890,5 @@ func main() {
 	}
 
 	log.Println("Starting Infrared")
-	if err := gateway.ListenAndServe(proxies); err != nil {
-		log.Fatal("Gateway exited; error: ", err)
-	}
-
-	gateway.KeepProcessActive()
+	gateway.HandleConfigEvents(events)
 }
@@ -2,6 +2,7 @@ package infrared
 
 import (
 	"bufio"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -25,24 +26,43 @@ type ProxyConfig struct {
 	sync.RWMutex
 	watcher *fsnotify.Watcher
 
+	// bases are the paths of the files that the config extends
+	bases []string
+	// checksum is the SHA-256 of the last loaded content of the file and its bases
+	checksum       [sha256.Size]byte
+	closed         bool
 	removeCallback func()
 	changeCallback func()
 	dialer         *Dialer
 	process        process.Process
-
-	DomainName        string               `json:"domainName"`
-	ListenTo          string               `json:"listenTo"`
-	ProxyTo           string               `json:"proxyTo"`
-	ProxyBind         string               `json:"proxyBind"`
-	SpoofForcedHost   string               `json:"spoofForcedHost"`
-	ProxyProtocol     bool                 `json:"proxyProtocol"`
-	RealIP            bool                 `json:"realIp"`
-	Timeout           int                  `json:"timeout"`
-	DisconnectMessage string               `json:"disconnectMessage"`
-	Docker            DockerConfig         `json:"docker"`
-	OnlineStatus      StatusConfig         `json:"onlineStatus"`
-	OfflineStatus     StatusConfig         `json:"offlineStatus"`
-	CallbackServer    CallbackServerConfig `json:"callbackServer"`
+	accessList     *AccessList
+
+	Extends              ConfigExtends            `json:"extends"`
+	DomainName           string                   `json:"domainName"`
+	ListenTo             string                   `json:"listenTo"`
+	ProxyTo              string                   `json:"proxyTo"`
+	ProxyBind            string                   `json:"proxyBind"`
+	SpoofForcedHost      string                   `json:"spoofForcedHost"`
+	ProxyProtocol        bool                     `json:"proxyProtocol"`
+	ProxyProtocolVersion int                      `json:"proxyProtocolVersion"`
+	ProxyProtocolTLVs    ProxyProtocolTLVConfig   `json:"proxyProtocolTlvs"`
+	RealIP               bool                     `json:"realIp"`
+	Timeout              int                      `json:"timeout"`
+	DisconnectMessage    string                   `json:"disconnectMessage"`
+	Docker               DockerConfig             `json:"docker"`
+	OnlineStatus         StatusConfig             `json:"onlineStatus"`
+	OfflineStatus        StatusConfig             `json:"offlineStatus"`
+	CallbackServer       CallbackServerConfig     `json:"callbackServer"`
+	RateLimit            HandshakeRateLimitConfig `json:"rateLimit"`
+	AccessList           AccessListConfig         `json:"accessList"`
+	PlayerLists          PlayerListsConfig        `json:"playerLists"`
+	AllowCountries       []string                 `json:"allowCountries"`
+	DenyCountries        []string                 `json:"denyCountries"`
+	Maintenance          MaintenanceConfig        `json:"maintenance"`
+	MaxConnections       int                      `json:"maxConnections"`
+	ServerFullMessage    string                   `json:"serverFullMessage"`
+	PriorityPlayers      []string                 `json:"priorityPlayers"`
+	DuplicateLogin       DuplicateLoginConfig     `json:"duplicateLogin"`
 }
 
 func (cfg *ProxyConfig) Dialer() (*Dialer, error) {
@@ -61,6 +81,28 @@ func (cfg *ProxyConfig) Dialer() (*Dialer, error) {
 	return cfg.dialer, nil
 }
 
+// compiledAccessList returns the compiled access list of the config.
+// It is nil if the config has no access list.
+func (cfg *ProxyConfig) compiledAccessList() (*AccessList, error) {
+	if cfg.accessList != nil || cfg.AccessList.isEmpty() {
+		return cfg.accessList, nil
+	}
+
+	accessList, err := NewAccessList(cfg.AccessList)
+	if err != nil {
+		return nil, err
+	}
+	cfg.accessList = accessList
+	return accessList, nil
+}
+
+// ProxyProtocolTLVConfig selects the optional TLVs that are appended to
+// a version 2 PROXY protocol header
+type ProxyProtocolTLVConfig struct {
+	Authority bool `json:"authority"`
+	UniqueID  bool `json:"uniqueId"`
+}
+
 type DockerConfig struct {
 	DNSServer     string `json:"dnsServer"`
 	ContainerName string `json:"containerName"`
@@ -71,12 +113,128 @@ type DockerConfig struct {
 		Username   string `json:"username"`
 		Password   string `json:"password"`
 	} `json:"portainer"`
+	Kubernetes struct {
+		// APIServer defaults to the API server of the cluster that Infrared runs in
+		APIServer   string `json:"apiServer"`
+		Namespace   string `json:"namespace"`
+		Deployment  string `json:"deployment"`
+		StatefulSet string `json:"statefulSet"`
+	} `json:"kubernetes"`
+	Exec struct {
+		Command []string `json:"command"`
+		Dir     string   `json:"dir"`
+		Env     []string `json:"env"`
+		Stop    struct {
+			Stdin   string   `json:"stdin"`
+			Signal  string   `json:"signal"`
+			Command []string `json:"command"`
+			// GracePeriod is in milliseconds
+			GracePeriod int `json:"gracePeriod"`
+		} `json:"stop"`
+		Log struct {
+			Path string `json:"path"`
+			// MaxSize is in megabytes
+			MaxSize    int `json:"maxSize"`
+			MaxBackups int `json:"maxBackups"`
+		} `json:"log"`
+	} `json:"exec"`
+	Pterodactyl struct {
+		URL      string `json:"url"`
+		ServerID string `json:"serverId"`
+		APIKey   string `json:"apiKey"`
+	} `json:"pterodactyl"`
+	Webhook struct {
+		Start  WebhookCallConfig `json:"start"`
+		Stop   WebhookCallConfig `json:"stop"`
+		Status WebhookCallConfig `json:"status"`
+	} `json:"webhook"`
+}
+
+// WebhookCallConfig is an HTTP request that starts, stops or checks a server
+type WebhookCallConfig struct {
+	URL            string            `json:"url"`
+	Method         string            `json:"method"`
+	Header         map[string]string `json:"header"`
+	Body           string            `json:"body"`
+	ExpectedStatus []int             `json:"expectedStatus"`
+	// Timeout and Backoff are in milliseconds
+	Timeout int `json:"timeout"`
+	Retries int `json:"retries"`
+	Backoff int `json:"backoff"`
+	// StoppedStatus, Path and Running map the response of a status call to a running server
+	StoppedStatus []int    `json:"stoppedStatus"`
+	Path          string   `json:"path"`
+	Running       []string `json:"running"`
+}
+
+func (call WebhookCallConfig) webhookCall() process.WebhookCall {
+	return process.WebhookCall{
+		URL:            call.URL,
+		Method:         call.Method,
+		Header:         call.Header,
+		Body:           call.Body,
+		ExpectedStatus: call.ExpectedStatus,
+		Timeout:        time.Millisecond * time.Duration(call.Timeout),
+		Retries:        call.Retries,
+		Backoff:        time.Millisecond * time.Duration(call.Backoff),
+		StoppedStatus:  call.StoppedStatus,
+		Path:           call.Path,
+		Running:        call.Running,
+	}
 }
 
 func (docker DockerConfig) IsDocker() bool {
 	return docker.ContainerName != ""
 }
 
+// IsKubernetes reports whether a Deployment or StatefulSet is scaled instead of a container
+func (docker DockerConfig) IsKubernetes() bool {
+	return docker.Kubernetes.Deployment != "" || docker.Kubernetes.StatefulSet != ""
+}
+
+// IsExec reports whether a command on the host runs the server instead of a container
+func (docker DockerConfig) IsExec() bool {
+	return len(docker.Exec.Command) > 0
+}
+
+// ExecConfig returns the config of the command that runs the server
+func (docker DockerConfig) ExecConfig() process.ExecConfig {
+	exec := docker.Exec
+	return process.ExecConfig{
+		Command: exec.Command,
+		Dir:     exec.Dir,
+		Env:     exec.Env,
+		Stop: process.ExecStopConfig{
+			Stdin:       exec.Stop.Stdin,
+			Signal:      exec.Stop.Signal,
+			Command:     exec.Stop.Command,
+			GracePeriod: time.Millisecond * time.Duration(exec.Stop.GracePeriod),
+		},
+		LogPath:       exec.Log.Path,
+		LogMaxSize:    int64(exec.Log.MaxSize) * 1024 * 1024,
+		LogMaxBackups: exec.Log.MaxBackups,
+	}
+}
+
+// IsPterodactyl reports whether a server of a Pterodactyl panel is started and stopped instead of a container
+func (docker DockerConfig) IsPterodactyl() bool {
+	return docker.Pterodactyl.URL != "" || docker.Pterodactyl.ServerID != ""
+}
+
+// IsWebhook reports whether HTTP calls manage the server instead of a container
+func (docker DockerConfig) IsWebhook() bool {
+	return docker.Webhook.Start.URL != "" || docker.Webhook.Stop.URL != "" || docker.Webhook.Status.URL != ""
+}
+
+// WebhookConfig returns the config of the HTTP calls that manage the server
+func (docker DockerConfig) WebhookConfig() process.WebhookConfig {
+	return process.WebhookConfig{
+		Start:  docker.Webhook.Start.webhookCall(),
+		Stop:   docker.Webhook.Stop.webhookCall(),
+		Status: docker.Webhook.Status.webhookCall(),
+	}
+}
+
 func (docker DockerConfig) IsPortainer() bool {
 	return docker.ContainerName != "" &&
 		docker.Portainer.Address != "" &&
@@ -89,7 +247,8 @@ type PlayerSample struct {
 }
 
 type StatusConfig struct {
-	cachedPacket *protocol.Packet
+	cachedPacket   *protocol.Packet
+	cachedResponse *status.ResponseJSON
 
 	VersionName    string         `json:"versionName"`
 	ProtocolNumber int            `json:"protocolNumber"`
@@ -100,11 +259,33 @@ type StatusConfig struct {
 	MOTD           string         `json:"motd"`
 }
 
-func (cfg StatusConfig) StatusResponsePacket() (protocol.Packet, error) {
+// StatusResponsePacket builds the status response once and caches it in the config
+func (cfg *StatusConfig) StatusResponsePacket() (protocol.Packet, error) {
 	if cfg.cachedPacket != nil {
 		return *cfg.cachedPacket, nil
 	}
 
+	responseJSON, err := cfg.responseJSON()
+	if err != nil {
+		return protocol.Packet{}, err
+	}
+
+	packet, err := marshalStatusResponse(responseJSON)
+	if err != nil {
+		return protocol.Packet{}, err
+	}
+
+	cfg.cachedPacket = &packet
+	return packet, nil
+}
+
+// responseJSON builds the status response with the encoded icon once and caches it,
+// so that live player counts can be patched in without reading the icon again
+func (cfg *StatusConfig) responseJSON() (status.ResponseJSON, error) {
+	if cfg.cachedResponse != nil {
+		return *cfg.cachedResponse, nil
+	}
+
 	var samples []status.PlayerSampleJSON
 	for _, sample := range cfg.PlayerSamples {
 		samples = append(samples, status.PlayerSampleJSON{
@@ -131,22 +312,30 @@ func (cfg StatusConfig) StatusResponsePacket() (protocol.Packet, error) {
 	if cfg.IconPath != "" {
 		img64, err := loadImageAndEncodeToBase64String(cfg.IconPath)
 		if err != nil {
-			return protocol.Packet{}, err
+			return status.ResponseJSON{}, err
 		}
 		responseJSON.Favicon = fmt.Sprintf("data:image/png;base64,%s", img64)
 	}
 
+	cfg.cachedResponse = &responseJSON
+	return responseJSON, nil
+}
+
+// resetCache drops the cached response, so the next one is built from the config again
+func (cfg *StatusConfig) resetCache() {
+	cfg.cachedPacket = nil
+	cfg.cachedResponse = nil
+}
+
+func marshalStatusResponse(responseJSON status.ResponseJSON) (protocol.Packet, error) {
 	bb, err := json.Marshal(responseJSON)
 	if err != nil {
 		return protocol.Packet{}, err
 	}
 
-	packet := status.ClientBoundResponse{
+	return status.ClientBoundResponse{
 		JSONResponse: protocol.String(bb),
-	}.Marshal()
-
-	cfg.cachedPacket = &packet
-	return packet, nil
+	}.Marshal(), nil
 }
 
 func loadImageAndEncodeToBase64String(path string) (string, error) {
@@ -182,10 +371,12 @@ type CallbackServerConfig struct {
 
 func DefaultProxyConfig() ProxyConfig {
 	return ProxyConfig{
-		DomainName:        "localhost",
-		ListenTo:          ":25565",
-		Timeout:           1000,
-		DisconnectMessage: "Sorry {{username}}, but the server is offline.",
+		DomainName:           "localhost",
+		ListenTo:             ":25565",
+		ProxyProtocolVersion: 2,
+		Timeout:              1000,
+		DisconnectMessage:    "Sorry {{username}}, but the server is offline.",
+		ServerFullMessage:    "The server is full!",
 		Docker: DockerConfig{
 			DNSServer: "127.0.0.11",
 			Timeout:   300000,
@@ -199,6 +390,37 @@ func DefaultProxyConfig() ProxyConfig {
 	}
 }
 
+var (
+	proxyDefaultsMu sync.RWMutex
+	proxyDefaults   map[string]interface{}
+)
+
+// SetProxyDefaults overrides fields of the DefaultProxyConfig for all configs
+// that are loaded afterwards. The keys are the field names of the config file
+// and nested objects are merged deeply. Configs that are already loaded keep their values.
+func SetProxyDefaults(defaults map[string]interface{}) {
+	proxyDefaultsMu.Lock()
+	defer proxyDefaultsMu.Unlock()
+	proxyDefaults = defaults
+}
+
+// defaultProxyConfigMap returns the DefaultProxyConfig with the proxy defaults applied
+func defaultProxyConfigMap() (map[string]interface{}, error) {
+	var defaultCfg map[string]interface{}
+	bb, err := json.Marshal(DefaultProxyConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(bb, &defaultCfg); err != nil {
+		return nil, err
+	}
+
+	proxyDefaultsMu.RLock()
+	defer proxyDefaultsMu.RUnlock()
+	return deepMerge(defaultCfg, proxyDefaults), nil
+}
+
 func ReadFilePaths(path string, recursive bool) ([]string, error) {
 	if recursive {
 		return readFilePathsRecursively(path)
@@ -284,23 +506,13 @@ func isLinkedToDir(path string) (bool, error) {
 	return linkedFileInfo.IsDir(), nil
 }
 
+// LoadProxyConfigsFromPath validates and loads all proxy configs in the directory
 func LoadProxyConfigsFromPath(path string, recursive bool) ([]*ProxyConfig, error) {
-	filePaths, err := ReadFilePaths(path, recursive)
-	if err != nil {
-		return nil, err
-	}
-
-	var cfgs []*ProxyConfig
-
-	for _, filePath := range filePaths {
-		cfg, err := NewProxyConfigFromPath(filePath)
-		if err != nil {
-			return nil, err
-		}
-		cfgs = append(cfgs, cfg)
+	folder := ConfigFolder{
+		Path:      path,
+		Recursive: recursive,
 	}
-
-	return cfgs, nil
+	return folder.Load()
 }
 
 // NewProxyConfigFromPath loads a ProxyConfig from a file path and then starts watching
@@ -319,6 +531,13 @@ func NewProxyConfigFromPath(path string) (*ProxyConfig, error) {
 	}
 	cfg.watcher = watcher
 
+	// The directory is watched instead of the file, because editors and tools
+	// replace files by renaming other files or retargeting symlinks
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
 	go func() {
 		defer watcher.Close()
 		log.Printf("Starting to watch %s", path)
@@ -326,37 +545,31 @@ func NewProxyConfigFromPath(path string) (*ProxyConfig, error) {
 		log.Printf("Stopping to watch %s", path)
 	}()
 
-	if err := watcher.Add(path); err != nil {
-		return nil, err
-	}
-
-	return &cfg, err
+	return &cfg, nil
 }
 
-func (cfg *ProxyConfig) watch(path string, interval time.Duration) {
-	// The interval protects the watcher from write event spams
-	// This is necessary due to how some text editors handle file safes
-	tick := time.Tick(interval)
-	var lastEvent *fsnotify.Event
+// watch reloads the config once the events that might have changed the file at path stop for the delay.
+// This protects the watcher from write event spams and lets editors and tools finish replacing the file.
+func (cfg *ProxyConfig) watch(path string, delay time.Duration) {
+	path = filepath.Clean(path)
+	dirs := map[string]bool{filepath.Dir(path): true}
+	files := cfg.watchFiles(path, dirs)
+	var reload <-chan time.Time
 
 	for {
 		select {
-		case <-tick:
-			if lastEvent == nil {
-				continue
+		case <-reload:
+			reload = nil
+			if !cfg.reload(path) {
+				return
 			}
-			cfg.onConfigWrite(*lastEvent)
-			lastEvent = nil
+			files = cfg.watchFiles(path, dirs)
 		case event, ok := <-cfg.watcher.Events:
 			if !ok {
 				return
 			}
-			if event.Op&fsnotify.Remove == fsnotify.Remove {
-				cfg.removeCallback()
-				return
-			}
-			if event.Op&fsnotify.Write == fsnotify.Write {
-				lastEvent = &event
+			if isConfigEvent(event, files) {
+				reload = time.After(delay)
 			}
 		case err, ok := <-cfg.watcher.Errors:
 			if !ok {
@@ -367,110 +580,192 @@ func (cfg *ProxyConfig) watch(path string, interval time.Duration) {
 	}
 }
 
-func (cfg *ProxyConfig) onConfigWrite(event fsnotify.Event) {
-	log.Println("Updating", event.Name)
-	if err := cfg.LoadFromPath(event.Name); err != nil {
-		log.Printf("Failed update on %s; error %s", event.Name, err)
-		return
+// watchFiles returns the files that the config is loaded from, which are the file at path,
+// the file that it links to and its bases. It watches their directories and
+// stops watching the directories that are not needed anymore.
+func (cfg *ProxyConfig) watchFiles(path string, dirs map[string]bool) map[string]bool {
+	cfg.RLock()
+	files := map[string]bool{path: true}
+	for _, base := range cfg.bases {
+		files[filepath.Clean(base)] = true
 	}
-	cfg.OnlineStatus.cachedPacket = nil
-	cfg.OfflineStatus.cachedPacket = nil
-	cfg.dialer = nil
-	cfg.process = nil
-	cfg.changeCallback()
+	cfg.RUnlock()
+
+	for file := range files {
+		if target, err := filepath.EvalSymlinks(file); err == nil {
+			files[filepath.Clean(target)] = true
+		}
+	}
+
+	neededDirs := map[string]bool{}
+	for file := range files {
+		neededDirs[filepath.Dir(file)] = true
+	}
+
+	for dir := range dirs {
+		if !neededDirs[dir] {
+			_ = cfg.watcher.Remove(dir)
+			delete(dirs, dir)
+		}
+	}
+
+	for dir := range neededDirs {
+		if dirs[dir] {
+			continue
+		}
+
+		if err := cfg.watcher.Add(dir); err != nil {
+			log.Printf("Failed watching %s; error %s", dir, err)
+			continue
+		}
+		dirs[dir] = true
+	}
+	return files
 }
 
-// LoadFromPath loads the ProxyConfig from a file
-func (cfg *ProxyConfig) LoadFromPath(path string) error {
-	cfg.Lock()
-	defer cfg.Unlock()
+// isConfigEvent reports whether the event might have changed the content of one of the files.
+// Every event in the directory of a symlink might have retargeted it, like with ConfigMaps in Kubernetes.
+func isConfigEvent(event fsnotify.Event, files map[string]bool) bool {
+	name := filepath.Clean(event.Name)
+	if files[name] {
+		return true
+	}
 
-	var defaultCfg map[string]interface{}
-	bb, err := json.Marshal(DefaultProxyConfig())
-	if err != nil {
-		return err
+	for file := range files {
+		if filepath.Dir(file) != filepath.Dir(name) {
+			continue
+		}
+
+		fileInfo, err := os.Lstat(file)
+		if err == nil && fileInfo.Mode()&os.ModeSymlink == os.ModeSymlink {
+			return true
+		}
 	}
+	return false
+}
 
-	if err := json.Unmarshal(bb, &defaultCfg); err != nil {
-		return err
+// reload loads the config again if the content of the file or one of its bases changed.
+// It closes the config and returns false if the file does not exist anymore.
+func (cfg *ProxyConfig) reload(path string) bool {
+	bb, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Println("Removing", path)
+		cfg.close()
+		return false
 	}
 
-	bb, err = ioutil.ReadFile(path)
 	if err != nil {
-		return err
+		log.Printf("Failed reading %s; error %s", path, err)
+		return true
 	}
 
-	var loadedCfg map[string]interface{}
-	if err := json.Unmarshal(bb, &loadedCfg); err != nil {
-		log.Println(string(bb))
-		return err
+	cfg.RLock()
+	changed := cfg.checksum != configChecksum(bb, cfg.bases)
+	cfg.RUnlock()
+
+	if changed {
+		cfg.onConfigWrite(path)
 	}
+	return true
+}
 
-	for k, v := range loadedCfg {
-		defaultCfg[k] = v
+// close stops watching the config file and closes its proxy
+func (cfg *ProxyConfig) close() {
+	cfg.Lock()
+	cfg.closed = true
+	removeCallback := cfg.removeCallback
+	cfg.Unlock()
+
+	if cfg.watcher != nil {
+		cfg.watcher.Close()
 	}
 
-	bb, err = json.Marshal(defaultCfg)
-	if err != nil {
-		return err
+	if removeCallback != nil {
+		removeCallback()
 	}
+}
 
-	return json.Unmarshal(bb, cfg)
+func (cfg *ProxyConfig) isClosed() bool {
+	cfg.RLock()
+	defer cfg.RUnlock()
+	return cfg.closed
 }
 
-func WatchProxyConfigFolder(path string, out chan *ProxyConfig) error {
-	watcher, err := fsnotify.NewWatcher()
+func (cfg *ProxyConfig) onConfigWrite(path string) {
+	log.Println("Updating", path)
+	if err := cfg.LoadFromPath(path); err != nil {
+		log.Printf("Failed update on %s; error %s", path, err)
+		return
+	}
+	cfg.resetCaches()
+
+	cfg.RLock()
+	changeCallback := cfg.changeCallback
+	cfg.RUnlock()
+	if changeCallback != nil {
+		changeCallback()
+	}
+}
+
+// resetCaches drops everything that was built from the previous content of the config
+func (cfg *ProxyConfig) resetCaches() {
+	cfg.OnlineStatus.resetCache()
+	cfg.OfflineStatus.resetCache()
+	cfg.Maintenance.Status.resetCache()
+	cfg.dialer = nil
+	cfg.process = nil
+	cfg.accessList = nil
+}
+
+// LoadFromPath loads the ProxyConfig from a JSON, YAML or TOML file depending on its extension.
+// The file is deep merged on top of the bases it extends, which are merged on top of the defaults.
+func (cfg *ProxyConfig) LoadFromPath(path string) error {
+	bb, err := ioutil.ReadFile(path)
 	if err != nil {
 		return err
 	}
-	defer watcher.Close()
 
-	if err := watcher.Add(path); err != nil {
+	return cfg.load(path, bb)
+}
+
+// load loads the ProxyConfig from the content of a config file at path
+func (cfg *ProxyConfig) load(path string, bb []byte) error {
+	return cfg.loadConfig(path, bb, configOriginFile)
+}
+
+// loadConfig loads the ProxyConfig after validating it for the fields that its origin can set
+func (cfg *ProxyConfig) loadConfig(path string, bb []byte, origin configOrigin) error {
+	cfg.Lock()
+	defer cfg.Unlock()
+
+	// Only the API and the validate command reject the problems that configs did not have to pass before
+	_, file, errs := validateConfig(path, bb, origin, false)
+	file.logWarnings()
+	// Invalid content is not loaded again until the file or one of its bases changes
+	cfg.bases = file.basePaths()
+	cfg.checksum = configChecksum(bb, cfg.bases)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	bb, err := json.Marshal(file.merged)
+	if err != nil {
 		return err
 	}
 
-	defer close(out)
-	for {
-		select {
-		case event, ok := <-watcher.Events:
-			if !ok {
-				return nil
-			}
-			if event.Op&fsnotify.Create == fsnotify.Create {
-				fileInfo, err := os.Lstat(event.Name)
-				if err != nil {
-					log.Printf("%s was created, but we failed to stat it: %v", event.Name, err)
-					continue
-				}
-
-				if fileInfo.IsDir() {
-					continue
-				}
-
-				// check the type of file that is behind symlinks link
-				if fileInfo.Mode()&os.ModeSymlink == os.ModeSymlink {
-					linkedToDir, err := isLinkedToDir(event.Name)
-					if err != nil {
-						return err
-					}
-
-					if linkedToDir {
-						continue
-					}
-				}
-
-				proxyCfg, err := NewProxyConfigFromPath(event.Name)
-				if err != nil {
-					log.Printf("Failed loading %s; error %s", event.Name, err)
-					continue
-				}
-				out <- proxyCfg
-			}
-		case err, ok := <-watcher.Errors:
-			if !ok {
-				return nil
-			}
-			log.Printf("Failed watching %s; error %s", path, err)
-		}
+	return json.Unmarshal(bb, cfg)
+}
+
+// configChecksum returns the SHA-256 of the content of a config file and its bases
+func configChecksum(bb []byte, bases []string) [sha256.Size]byte {
+	hash := sha256.New()
+	hash.Write(bb)
+	for _, base := range bases {
+		baseBB, _ := ioutil.ReadFile(base)
+		hash.Write(baseBB)
 	}
+
+	var checksum [sha256.Size]byte
+	copy(checksum[:], hash.Sum(nil))
+	return checksum
 }
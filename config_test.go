@@ -0,0 +1,180 @@
+package infrared
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProxyConfig_Watch(t *testing.T) {
+	const (
+		oldContent = "domainName: old.example.com\nproxyTo: \":25566\"\n"
+		newContent = "domainName: new.example.com\nproxyTo: \":25566\"\n"
+	)
+
+	writeFile := func(t *testing.T, path, content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// symlinkFile places the file like a Kubernetes ConfigMap volume:
+	// proxy.yml -> ..data/proxy.yml and ..data -> ..<version>
+	symlinkFile := func(t *testing.T, dir, version, content string) {
+		versionDir := filepath.Join(dir, ".."+version)
+		if err := os.Mkdir(versionDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, filepath.Join(versionDir, "proxy.yml"), content)
+
+		tmpLink := filepath.Join(dir, "..data_tmp")
+		if err := os.Symlink(".."+version, tmpLink); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Rename(tmpLink, filepath.Join(dir, "..data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tt := []struct {
+		name    string
+		setup   func(t *testing.T, dir string) string
+		change  func(t *testing.T, path string)
+		changes int
+		removed bool
+	}{
+		{
+			name: "Write",
+			change: func(t *testing.T, path string) {
+				writeFile(t, path, newContent)
+			},
+			changes: 1,
+		},
+		{
+			name: "AtomicRename",
+			change: func(t *testing.T, path string) {
+				tmpPath := path + ".tmp"
+				writeFile(t, tmpPath, newContent)
+				if err := os.Chmod(tmpPath, 0600); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Rename(tmpPath, path); err != nil {
+					t.Fatal(err)
+				}
+			},
+			changes: 1,
+		},
+		{
+			name: "RemoveAndCreate",
+			change: func(t *testing.T, path string) {
+				if err := os.Remove(path); err != nil {
+					t.Fatal(err)
+				}
+				writeFile(t, path, newContent)
+			},
+			changes: 1,
+		},
+		{
+			name: "SymlinkRetarget",
+			setup: func(t *testing.T, dir string) string {
+				symlinkFile(t, dir, "v1", oldContent)
+				path := filepath.Join(dir, "proxy.yml")
+				if err := os.Symlink(filepath.Join("..data", "proxy.yml"), path); err != nil {
+					t.Fatal(err)
+				}
+				return path
+			},
+			change: func(t *testing.T, path string) {
+				dir := filepath.Dir(path)
+				symlinkFile(t, dir, "v2", newContent)
+				if err := os.RemoveAll(filepath.Join(dir, "..v1")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			changes: 1,
+		},
+		{
+			name: "Chmod",
+			change: func(t *testing.T, path string) {
+				if err := os.Chmod(path, 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			changes: 0,
+		},
+		{
+			name: "Remove",
+			change: func(t *testing.T, path string) {
+				if err := os.Remove(path); err != nil {
+					t.Fatal(err)
+				}
+			},
+			removed: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "proxy.yml")
+			if tc.setup != nil {
+				path = tc.setup(t, dir)
+			} else {
+				writeFile(t, path, oldContent)
+			}
+
+			cfg, err := NewProxyConfigFromPath(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cfg.watcher.Close()
+
+			changed := make(chan bool, 10)
+			removed := make(chan bool, 1)
+			cfg.Lock()
+			cfg.changeCallback = func() {
+				changed <- true
+			}
+			cfg.removeCallback = func() {
+				removed <- true
+			}
+			cfg.Unlock()
+			// Give the watcher time to start
+			time.Sleep(50 * time.Millisecond)
+
+			tc.change(t, path)
+
+			if tc.removed {
+				select {
+				case <-removed:
+				case <-time.After(time.Second):
+					t.Fatal("config was not removed")
+				}
+				return
+			}
+
+			for i := 0; i < tc.changes; i++ {
+				select {
+				case <-changed:
+				case <-time.After(time.Second):
+					t.Fatal("change was not noticed")
+				}
+			}
+
+			select {
+			case <-changed:
+				t.Errorf("expected %d reloads, got more", tc.changes)
+			case <-removed:
+				t.Error("config was removed")
+			case <-time.After(200 * time.Millisecond):
+			}
+
+			if tc.changes > 0 && cfg.DomainName != "new.example.com" {
+				t.Errorf("expected domain new.example.com, got %s", cfg.DomainName)
+			}
+		})
+	}
+}
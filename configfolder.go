@@ -0,0 +1,399 @@
+package infrared
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// ConfigIgnore are glob patterns like "*.swp" or "_*.json" of config files and directories that are skipped.
+// The patterns are matched against the base name of a path.
+type ConfigIgnore []string
+
+// DefaultConfigIgnore skips dotfiles, the swap and backup files of editors
+// and base configs like _base.yml, which are not complete proxy configs
+var DefaultConfigIgnore = ConfigIgnore{".*", "*.swp", "*~", "_*"}
+
+// ParseConfigIgnore parses comma separated glob patterns
+func ParseConfigIgnore(patterns string) (ConfigIgnore, error) {
+	var ignore ConfigIgnore
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid ignore pattern %q; %w", pattern, err)
+		}
+		ignore = append(ignore, pattern)
+	}
+	return ignore, nil
+}
+
+// Match reports whether the base name of the path matches one of the patterns
+func (ignore ConfigIgnore) Match(path string) bool {
+	name := filepath.Base(path)
+	for _, pattern := range ignore {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigFolder is a directory of proxy configs.
+// In recursive mode the configs of all subdirectories are loaded and watched as well.
+type ConfigFolder struct {
+	Path      string
+	Recursive bool
+	Ignore    ConfigIgnore
+
+	mu sync.Mutex
+	// configs are the loaded proxy configs by their file path
+	configs map[string]*ProxyConfig
+	// dirs are the watched directories
+	dirs map[string]bool
+	// events and done are set by Provide
+	events chan<- ConfigEvent
+	done   <-chan struct{}
+}
+
+// FilePaths returns the paths of all config files in the folder that are not ignored
+func (folder *ConfigFolder) FilePaths() ([]string, error) {
+	return folder.filePaths(folder.Path)
+}
+
+func (folder *ConfigFolder) filePaths(dir string) ([]string, error) {
+	var filePaths []string
+
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if path == dir {
+			return nil
+		}
+
+		if entry.IsDir() {
+			if !folder.Recursive || folder.Ignore.Match(path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if folder.Ignore.Match(path) {
+			return nil
+		}
+
+		// check the type of file that is behind symlinks link
+		if entry.Type()&os.ModeSymlink == os.ModeSymlink {
+			linkedToDir, err := isLinkedToDir(path)
+			if err != nil {
+				return err
+			}
+
+			if linkedToDir {
+				return nil
+			}
+		}
+
+		filePaths = append(filePaths, path)
+		return nil
+	})
+
+	return filePaths, err
+}
+
+// Load validates and loads all proxy configs in the folder
+func (folder *ConfigFolder) Load() ([]*ProxyConfig, error) {
+	filePaths, err := folder.FilePaths()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ValidateProxyConfigFiles(filePaths); err != nil {
+		return nil, err
+	}
+
+	var cfgs []*ProxyConfig
+
+	for _, filePath := range filePaths {
+		cfg, err := NewProxyConfigFromPath(filePath)
+		if err != nil {
+			return nil, err
+		}
+		folder.track(filePath, cfg)
+		cfgs = append(cfgs, cfg)
+	}
+
+	return cfgs, nil
+}
+
+// Provide loads all proxy configs in the folder and sends them as added events.
+// Then it watches the folder and sends the configs of new files as added events
+// and the changes of the configs as updated and removed events until the context is done.
+// In recursive mode new subdirectories are watched as well and
+// the configs of removed subdirectories are removed.
+func (folder *ConfigFolder) Provide(ctx context.Context, events chan<- ConfigEvent) error {
+	folder.events = events
+	folder.done = ctx.Done()
+
+	if _, err := folder.Load(); err != nil {
+		return err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err := folder.watchDirs(watcher, folder.Path); err != nil {
+		return err
+	}
+
+	folder.mu.Lock()
+	filePaths := make([]string, 0, len(folder.configs))
+	for filePath := range folder.configs {
+		filePaths = append(filePaths, filePath)
+	}
+	folder.mu.Unlock()
+
+	sort.Strings(filePaths)
+	for _, filePath := range filePaths {
+		folder.mu.Lock()
+		cfg := folder.configs[filePath]
+		folder.mu.Unlock()
+		folder.send(ConfigEvent{Type: ConfigAdded, ID: filePath, Config: cfg})
+	}
+
+	// New files are loaded on the next tick, so that they are likely written completely.
+	// Files that fail to load are loaded again when they are written to.
+	tick := time.NewTicker(time.Millisecond * 50)
+	defer tick.Stop()
+	pending := map[string]bool{}
+
+	for {
+		select {
+		case <-ctx.Done():
+			folder.stopWatching()
+			return nil
+		case <-tick.C:
+			for path := range pending {
+				folder.load(path)
+			}
+			pending = map[string]bool{}
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			folder.onEvent(watcher, event, pending)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("Failed watching %s; error %s", folder.Path, err)
+		}
+	}
+}
+
+// send sends the event to the events of Provide unless the context is done
+func (folder *ConfigFolder) send(event ConfigEvent) {
+	if folder.events == nil {
+		return
+	}
+
+	select {
+	case folder.events <- event:
+	case <-folder.done:
+	}
+}
+
+// stopWatching stops the watchers of all configs without removing them
+func (folder *ConfigFolder) stopWatching() {
+	folder.mu.Lock()
+	defer folder.mu.Unlock()
+	for _, cfg := range folder.configs {
+		if cfg.watcher != nil {
+			cfg.watcher.Close()
+		}
+	}
+}
+
+// watchDirs adds the directory and in recursive mode all of its subdirectories to the watcher
+func (folder *ConfigFolder) watchDirs(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !entry.IsDir() {
+			return nil
+		}
+
+		if path != dir && (!folder.Recursive || folder.Ignore.Match(path)) {
+			return filepath.SkipDir
+		}
+
+		if err := watcher.Add(path); err != nil {
+			return err
+		}
+
+		folder.mu.Lock()
+		if folder.dirs == nil {
+			folder.dirs = map[string]bool{}
+		}
+		folder.dirs[path] = true
+		folder.mu.Unlock()
+		return nil
+	})
+}
+
+func (folder *ConfigFolder) onEvent(watcher *fsnotify.Watcher, event fsnotify.Event, pending map[string]bool) {
+	if folder.Ignore.Match(event.Name) {
+		return
+	}
+
+	if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		folder.untrack(watcher, event.Name)
+		return
+	}
+
+	if event.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+		return
+	}
+
+	fileInfo, err := os.Lstat(event.Name)
+	if err != nil {
+		log.Printf("%s was created, but we failed to stat it: %v", event.Name, err)
+		return
+	}
+
+	if fileInfo.IsDir() {
+		if folder.Recursive {
+			folder.watchDir(watcher, event.Name, pending)
+		}
+		return
+	}
+
+	// check the type of file that is behind symlinks link
+	if fileInfo.Mode()&os.ModeSymlink == os.ModeSymlink {
+		linkedToDir, err := isLinkedToDir(event.Name)
+		if err != nil {
+			log.Printf("%s was created, but we failed to resolve it: %v", event.Name, err)
+			return
+		}
+
+		if linkedToDir {
+			return
+		}
+	}
+
+	pending[event.Name] = true
+}
+
+// watchDir watches a new directory and marks the configs that were created in it before it was watched as pending
+func (folder *ConfigFolder) watchDir(watcher *fsnotify.Watcher, dir string, pending map[string]bool) {
+	if err := folder.watchDirs(watcher, dir); err != nil {
+		log.Printf("Failed watching %s; error %s", dir, err)
+		return
+	}
+
+	filePaths, err := folder.filePaths(dir)
+	if err != nil {
+		log.Printf("Failed reading %s; error %s", dir, err)
+		return
+	}
+
+	for _, filePath := range filePaths {
+		pending[filePath] = true
+	}
+}
+
+func (folder *ConfigFolder) load(path string) {
+	// The config reloads itself if its file was replaced
+	folder.mu.Lock()
+	cfg, ok := folder.configs[path]
+	folder.mu.Unlock()
+	if ok && !cfg.isClosed() {
+		return
+	}
+
+	newCfg, err := NewProxyConfigFromPath(path)
+	if err != nil {
+		log.Printf("Failed loading %s; error %s", path, err)
+		return
+	}
+	folder.track(path, newCfg)
+	folder.send(ConfigEvent{Type: ConfigAdded, ID: path, Config: newCfg})
+}
+
+// track keeps the config and sends its changes as events
+func (folder *ConfigFolder) track(path string, cfg *ProxyConfig) {
+	cfg.Lock()
+	cfg.changeCallback = func() {
+		folder.send(ConfigEvent{Type: ConfigUpdated, ID: path, Config: cfg})
+	}
+	cfg.removeCallback = func() {
+		folder.send(ConfigEvent{Type: ConfigRemoved, ID: path, Config: cfg})
+	}
+	cfg.Unlock()
+
+	folder.mu.Lock()
+	defer folder.mu.Unlock()
+	if folder.configs == nil {
+		folder.configs = map[string]*ProxyConfig{}
+	}
+	folder.configs[path] = cfg
+}
+
+// untrack stops watching a removed directory and closes the proxies of all configs in it.
+// Configs watch their own file and close their proxy if it is removed.
+func (folder *ConfigFolder) untrack(watcher *fsnotify.Watcher, path string) {
+	// The configs are closed after unlocking, since closing sends their removed events
+	for filePath, cfg := range folder.untrackDir(watcher, path) {
+		log.Println("Removing", filePath)
+		cfg.close()
+	}
+}
+
+// untrackDir forgets a removed directory and returns the configs in it by their paths
+func (folder *ConfigFolder) untrackDir(watcher *fsnotify.Watcher, path string) map[string]*ProxyConfig {
+	folder.mu.Lock()
+	defer folder.mu.Unlock()
+
+	if !folder.dirs[path] {
+		return nil
+	}
+
+	prefix := path + string(filepath.Separator)
+	for dir := range folder.dirs {
+		if dir != path && !strings.HasPrefix(dir, prefix) {
+			continue
+		}
+		// Removed directories are no longer watched anyway, but moved ones are
+		_ = watcher.Remove(dir)
+		delete(folder.dirs, dir)
+	}
+
+	configs := map[string]*ProxyConfig{}
+	for filePath, cfg := range folder.configs {
+		if !strings.HasPrefix(filePath, prefix) {
+			continue
+		}
+		configs[filePath] = cfg
+		delete(folder.configs, filePath)
+	}
+	return configs
+}
@@ -0,0 +1,198 @@
+package infrared
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestConfigIgnore_Match(t *testing.T) {
+	ignore, err := ParseConfigIgnore(".*, *.swp,_*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		path  string
+		match bool
+	}{
+		{path: "configs/mc.example.com.yml", match: false},
+		{path: "configs/.hidden.yml", match: true},
+		{path: "configs/.git", match: true},
+		{path: "configs/mc.example.com.yml.swp", match: true},
+		{path: "configs/_template.json", match: true},
+		{path: "configs/_template.yml", match: false},
+		{path: "configs/team_a/lobby.json", match: false},
+	}
+
+	for _, tc := range tt {
+		if match := ignore.Match(tc.path); match != tc.match {
+			t.Errorf("%s: expected match to be %v", tc.path, tc.match)
+		}
+	}
+}
+
+func TestDefaultConfigIgnore_SkipsBases(t *testing.T) {
+	for _, path := range []string{"configs/_base.yml", "configs/_docker.json"} {
+		if !DefaultConfigIgnore.Match(path) {
+			t.Errorf("%s: base config should be skipped", path)
+		}
+	}
+
+	if DefaultConfigIgnore.Match("configs/team_a/lobby.yml") {
+		t.Error("proxy config should not be skipped")
+	}
+}
+
+func TestParseConfigIgnore_InvalidPattern(t *testing.T) {
+	if _, err := ParseConfigIgnore("*.swp,[a-"); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func writeConfigFiles(t *testing.T, dir string, paths ...string) {
+	for i, path := range paths {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		content := fmt.Sprintf("domainName: mc%d.example.com\nproxyTo: \":25566\"\n", i)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestConfigFolder_FilePaths(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFiles(t, dir,
+		"lobby.yml",
+		".lobby.yml.swp",
+		"_template.json",
+		"team_a/survival.yml",
+		"team_a/nested/creative.json",
+		".git/config",
+	)
+
+	tt := []struct {
+		name      string
+		recursive bool
+		expected  []string
+	}{
+		{
+			name:      "TopDirectory",
+			recursive: false,
+			expected:  []string{"lobby.yml"},
+		},
+		{
+			name:      "Recursive",
+			recursive: true,
+			expected:  []string{"lobby.yml", "team_a/nested/creative.json", "team_a/survival.yml"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			folder := ConfigFolder{
+				Path:      dir,
+				Recursive: tc.recursive,
+				Ignore:    ConfigIgnore{".*", "*.swp", "_*.json"},
+			}
+
+			filePaths, err := folder.FilePaths()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var relPaths []string
+			for _, filePath := range filePaths {
+				relPath, err := filepath.Rel(dir, filePath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				relPaths = append(relPaths, filepath.ToSlash(relPath))
+			}
+			sort.Strings(relPaths)
+
+			if !reflect.DeepEqual(relPaths, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, relPaths)
+			}
+		})
+	}
+}
+
+func TestConfigFolder_Provide(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFiles(t, dir, "team_a/lobby.yml")
+
+	folder := ConfigFolder{
+		Path:      dir,
+		Recursive: true,
+		Ignore:    DefaultConfigIgnore,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan ConfigEvent)
+	go folder.Provide(ctx, events)
+
+	receive := func(t *testing.T, msg string) ConfigEvent {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(time.Second):
+			t.Fatal(msg)
+			return ConfigEvent{}
+		}
+	}
+
+	lobbyPath := filepath.Join(dir, "team_a", "lobby.yml")
+	event := receive(t, "existing config was not added")
+	if event.Type != ConfigAdded || event.ID != lobbyPath {
+		t.Fatalf("expected %s to be added, got %s %s", lobbyPath, event.ID, event.Type)
+	}
+	// Give the watcher time to start
+	time.Sleep(50 * time.Millisecond)
+
+	// Configs in new subdirectories are loaded, dotfiles are not
+	writeConfigFiles(t, filepath.Join(dir, "team_b"), "survival.yml", ".survival.yml.swp")
+
+	event = receive(t, "config in new directory was not added")
+	if event.Type != ConfigAdded || event.Config.DomainName != "mc0.example.com" {
+		t.Errorf("expected config of mc0.example.com to be added, got %s %s", event.ID, event.Type)
+	}
+
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event %s %s", event.ID, event.Type)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Changed configs are updated
+	if err := ioutil.WriteFile(lobbyPath, []byte("domainName: lobby.example.com\nproxyTo: \":25566\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	event = receive(t, "changed config was not updated")
+	if event.Type != ConfigUpdated || event.ID != lobbyPath || event.Config.DomainName != "lobby.example.com" {
+		t.Errorf("expected %s to be updated, got %s %s", lobbyPath, event.ID, event.Type)
+	}
+
+	// Moving a directory out of the folder removes its configs
+	if err := os.Rename(filepath.Join(dir, "team_a"), filepath.Join(t.TempDir(), "team_a")); err != nil {
+		t.Fatal(err)
+	}
+
+	event = receive(t, "config of moved directory was not removed")
+	if event.Type != ConfigRemoved || event.ID != lobbyPath {
+		t.Errorf("expected %s to be removed, got %s %s", lobbyPath, event.ID, event.Type)
+	}
+}
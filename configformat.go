@@ -0,0 +1,289 @@
+package infrared
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ConfigFormatJSON = "json"
+	ConfigFormatYAML = "yaml"
+	ConfigFormatTOML = "toml"
+)
+
+// ConfigFormatFromPath picks the format of a config file by its extension.
+// Files without a known extension are JSON.
+func ConfigFormatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		return ConfigFormatYAML
+	case ".toml":
+		return ConfigFormatTOML
+	default:
+		return ConfigFormatJSON
+	}
+}
+
+// ConfigContentType returns the MIME type of the format
+func ConfigContentType(format string) string {
+	switch format {
+	case ConfigFormatYAML:
+		return "application/yaml"
+	case ConfigFormatTOML:
+		return "application/toml"
+	default:
+		return "application/json"
+	}
+}
+
+// UnmarshalConfig decodes a config of the format into a map that can be marshaled to JSON
+func UnmarshalConfig(format string, bb []byte) (map[string]interface{}, error) {
+	cfg := map[string]interface{}{}
+	var err error
+	switch format {
+	case ConfigFormatYAML:
+		err = yaml.Unmarshal(bb, &cfg)
+	case ConfigFormatTOML:
+		err = toml.Unmarshal(bb, &cfg)
+	default:
+		err = json.Unmarshal(bb, &cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s; %w", format, err)
+	}
+
+	// An empty YAML document decodes to nil
+	if cfg == nil {
+		cfg = map[string]interface{}{}
+	}
+	return cfg, nil
+}
+
+// MarshalConfig encodes a config map in the format
+func MarshalConfig(format string, cfg map[string]interface{}) ([]byte, error) {
+	switch format {
+	case ConfigFormatYAML:
+		return yaml.Marshal(cfg)
+	case ConfigFormatTOML:
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(cfg); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return json.MarshalIndent(cfg, "", "  ")
+	}
+}
+
+// SetConfigKey sets a top-level key in the content of a config file of the format.
+// Only the value of the key is rewritten, so that the comments, key order and formatting
+// of the rest of the file stay as they are. In TOML files the comments inside the
+// tables of the key are lost, since the tables are replaced as a whole.
+func SetConfigKey(format string, bb []byte, key string, value interface{}) ([]byte, error) {
+	switch format {
+	case ConfigFormatYAML:
+		return setYAMLKey(bb, key, value)
+	case ConfigFormatTOML:
+		return setTOMLKey(bb, key, value)
+	default:
+		return setJSONKey(bb, key, value)
+	}
+}
+
+func setJSONKey(bb []byte, key string, value interface{}) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(bb))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, errors.New("invalid json; the config has to be an object")
+	}
+
+	members := 0
+	indent := "  "
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("invalid json; %w", err)
+		}
+
+		keyEnd := int(dec.InputOffset())
+		if members == 0 {
+			indent = lineIndent(bb, keyEnd)
+		}
+		members++
+
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, fmt.Errorf("invalid json; %w", err)
+		}
+
+		if tok != key {
+			continue
+		}
+
+		end := int(dec.InputOffset())
+		encoded, err := marshalJSONIndent(value, lineIndent(bb, keyEnd))
+		if err != nil {
+			return nil, err
+		}
+		return splice(bb, end-len(raw), end, encoded), nil
+	}
+
+	encodedKey, _ := json.Marshal(key)
+	encoded, err := marshalJSONIndent(value, indent)
+	if err != nil {
+		return nil, err
+	}
+
+	member := append(append(encodedKey, ": "...), encoded...)
+	closing := bytes.LastIndexByte(bb, '}')
+	start := len(bytes.TrimRight(bb[:closing], " \t\r\n"))
+	var insert []byte
+	switch {
+	case indent == "" && members > 0:
+		insert = append([]byte(", "), member...)
+	case indent == "":
+		insert = member
+	case members > 0:
+		insert = append(append([]byte(",\n"+indent), member...), '\n')
+	default:
+		insert = append(append([]byte("\n"+indent), member...), '\n')
+	}
+	return splice(bb, start, closing, insert), nil
+}
+
+// marshalJSONIndent encodes the value of a key that is indented by indent.
+// Values of keys on a single line stay on a single line.
+func marshalJSONIndent(value interface{}, indent string) ([]byte, error) {
+	if indent == "" {
+		return json.Marshal(value)
+	}
+
+	unit := indent
+	if strings.HasPrefix(indent, "\t") {
+		unit = "\t"
+	}
+	return json.MarshalIndent(value, indent, unit)
+}
+
+// lineIndent returns the whitespace at the start of the line of the offset,
+// or nothing if the line starts with something else like the braces of a single line config
+func lineIndent(bb []byte, offset int) string {
+	start := bytes.LastIndexByte(bb[:offset], '\n') + 1
+	line := bb[start:offset]
+	trimmed := bytes.TrimLeft(line, " \t")
+	if len(trimmed) == 0 || trimmed[0] != '"' {
+		return ""
+	}
+	return string(line[:len(line)-len(trimmed)])
+}
+
+func splice(bb []byte, start, end int, insert []byte) []byte {
+	result := make([]byte, 0, len(bb)-(end-start)+len(insert))
+	result = append(result, bb[:start]...)
+	result = append(result, insert...)
+	return append(result, bb[end:]...)
+}
+
+var yamlIndentRegexp = regexp.MustCompile(`(?m)^( +)[^ #-]`)
+
+func setYAMLKey(bb []byte, key string, value interface{}) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(bb, &doc); err != nil {
+		return nil, fmt.Errorf("invalid yaml; %w", err)
+	}
+
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{
+			Kind:    yaml.DocumentNode,
+			Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}},
+		}
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, errors.New("invalid yaml; the config has to be a mapping")
+	}
+
+	valueNode := &yaml.Node{}
+	if err := valueNode.Encode(value); err != nil {
+		return nil, err
+	}
+
+	replaced := false
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != key {
+			continue
+		}
+		// Aliases of the value elsewhere in the file keep working
+		valueNode.Anchor = root.Content[i+1].Anchor
+		root.Content[i+1] = valueNode
+		replaced = true
+		break
+	}
+
+	if !replaced {
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, valueNode)
+	}
+
+	indent := 2
+	if match := yamlIndentRegexp.FindSubmatch(bb); match != nil {
+		indent = len(match[1])
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(indent)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func setTOMLKey(bb []byte, key string, value interface{}) ([]byte, error) {
+	if _, err := UnmarshalConfig(ConfigFormatTOML, bb); err != nil {
+		return nil, err
+	}
+
+	var encoded bytes.Buffer
+	if err := toml.NewEncoder(&encoded).Encode(map[string]interface{}{key: value}); err != nil {
+		return nil, err
+	}
+
+	// Keys like key = { ... } or key.field = ... before the first table
+	// and the tables of the key are removed and the key is appended as table
+	var lines []string
+	inTable, inKeyTable := false, false
+	for _, line := range strings.SplitAfter(string(bb), "\n") {
+		match := tomlArrayTableRegexp.FindStringSubmatch(line)
+		if match == nil {
+			match = tomlTableRegexp.FindStringSubmatch(line)
+		}
+		if match != nil {
+			inTable, inKeyTable = true, tomlKeyPath(match[1])[0] == key
+		}
+
+		if inKeyTable {
+			continue
+		}
+		if match := tomlKeyRegexp.FindStringSubmatch(line); !inTable && match != nil && tomlKeyPath(match[1])[0] == key {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	content := strings.TrimRight(strings.Join(lines, ""), "\n")
+	if content != "" {
+		content += "\n\n"
+	}
+	return append([]byte(content), encoded.Bytes()...), nil
+}
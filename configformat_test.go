@@ -0,0 +1,205 @@
+package infrared
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestProxyConfig_LoadFromPath(t *testing.T) {
+	tt := []struct {
+		fileName string
+		content  string
+	}{
+		{
+			fileName: "proxy.json",
+			content: `{
+  "domainName": "mc.example.com",
+  "proxyTo": ":25566",
+  "maxConnections": 10,
+  "priorityPlayers": ["Notch"],
+  "maintenance": {"enabled": true}
+}`,
+		},
+		{
+			fileName: "proxy",
+			content:  `{"domainName": "mc.example.com", "proxyTo": ":25566", "maxConnections": 10, "priorityPlayers": ["Notch"], "maintenance": {"enabled": true}}`,
+		},
+		{
+			fileName: "proxy.yml",
+			content: `domainName: mc.example.com
+proxyTo: ":25566"
+maxConnections: 10
+priorityPlayers:
+  - Notch
+maintenance:
+  enabled: true
+`,
+		},
+		{
+			fileName: "proxy.yaml",
+			content:  `{domainName: mc.example.com, proxyTo: ":25566", maxConnections: 10, priorityPlayers: [Notch], maintenance: {enabled: true}}`,
+		},
+		{
+			fileName: "proxy.toml",
+			content: `domainName = "mc.example.com"
+proxyTo = ":25566"
+maxConnections = 10
+priorityPlayers = ["Notch"]
+
+[maintenance]
+enabled = true
+`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.fileName, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tc.fileName)
+			if err := ioutil.WriteFile(path, []byte(tc.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			var cfg ProxyConfig
+			if err := cfg.LoadFromPath(path); err != nil {
+				t.Fatal(err)
+			}
+
+			if cfg.DomainName != "mc.example.com" || cfg.ProxyTo != ":25566" {
+				t.Errorf("got: %s -> %s; want: mc.example.com -> :25566", cfg.DomainName, cfg.ProxyTo)
+			}
+
+			if cfg.MaxConnections != 10 || len(cfg.PriorityPlayers) != 1 || !cfg.Maintenance.Enabled {
+				t.Errorf("got: %d, %v, %v; want the values of the file", cfg.MaxConnections, cfg.PriorityPlayers, cfg.Maintenance.Enabled)
+			}
+
+			// Fields that are not in the file keep their default
+			if cfg.ListenTo != DefaultProxyConfig().ListenTo {
+				t.Errorf("got: %v; want: %v", cfg.ListenTo, DefaultProxyConfig().ListenTo)
+			}
+		})
+	}
+}
+
+func TestMarshalConfig(t *testing.T) {
+	cfg := map[string]interface{}{
+		"domainName": "mc.example.com",
+		"maintenance": map[string]interface{}{
+			"enabled": true,
+			"message": "Back soon",
+		},
+	}
+
+	for _, format := range []string{ConfigFormatJSON, ConfigFormatYAML, ConfigFormatTOML} {
+		t.Run(format, func(t *testing.T) {
+			bb, err := MarshalConfig(format, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			actual, err := UnmarshalConfig(format, bb)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			maintenance, ok := actual["maintenance"].(map[string]interface{})
+			if actual["domainName"] != "mc.example.com" || !ok || maintenance["enabled"] != true || maintenance["message"] != "Back soon" {
+				t.Errorf("got: %v; want: %v", actual, cfg)
+			}
+		})
+	}
+}
+
+func TestSetConfigKey(t *testing.T) {
+	maintenance := map[string]interface{}{
+		"enabled": true,
+		"message": "Back soon",
+	}
+
+	tt := []struct {
+		name     string
+		format   string
+		content  string
+		expected string
+	}{
+		{
+			name:   "JSON",
+			format: ConfigFormatJSON,
+			content: `{
+  "domainName": "mc.example.com",
+  "maintenance": {"enabled": false},
+  "proxyTo": ":25566"
+}
+`,
+			expected: `{
+  "domainName": "mc.example.com",
+  "maintenance": {
+    "enabled": true,
+    "message": "Back soon"
+  },
+  "proxyTo": ":25566"
+}
+`,
+		},
+		{
+			name:     "JSONNewKey",
+			format:   ConfigFormatJSON,
+			content:  `{"domainName": "mc.example.com"}`,
+			expected: `{"domainName": "mc.example.com", "maintenance": {"enabled":true,"message":"Back soon"}}`,
+		},
+		{
+			name:   "YAML",
+			format: ConfigFormatYAML,
+			content: `# Survival
+domainName: mc.example.com # public
+maintenance:
+  enabled: false
+proxyTo: ":25566"
+`,
+			expected: `# Survival
+domainName: mc.example.com # public
+maintenance:
+  enabled: true
+  message: Back soon
+proxyTo: ":25566"
+`,
+		},
+		{
+			name:   "TOML",
+			format: ConfigFormatTOML,
+			content: `# Survival
+domainName = "mc.example.com"
+maintenance.enabled = false
+
+[maintenance.status]
+motd = "Under maintenance"
+
+[onlineStatus]
+motd = "Survival" # public
+`,
+			expected: `# Survival
+domainName = "mc.example.com"
+
+[onlineStatus]
+motd = "Survival" # public
+
+[maintenance]
+  enabled = true
+  message = "Back soon"
+`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			bb, err := SetConfigKey(tc.format, []byte(tc.content), "maintenance", maintenance)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(bb) != tc.expected {
+				t.Errorf("got:\n%s\nwant:\n%s", bb, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package infrared
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+)
+
+const (
+	// DefaultDockerLabelPrefix is the default prefix of the labels that a DockerConfigProvider reads
+	DefaultDockerLabelPrefix = "infrared"
+
+	// dockerRetryInterval is the time between the attempts to reach the Docker daemon
+	dockerRetryInterval = 5 * time.Second
+	// defaultMinecraftPort is the port of containers without a port label
+	defaultMinecraftPort = "25565"
+)
+
+var (
+	// labelAliases are the short label names of config fields
+	labelAliases = map[string]string{
+		"domain":  "domainName",
+		"listen":  "listenTo",
+		"offline": "offlineStatus",
+		"online":  "onlineStatus",
+	}
+
+	// discoveryLabels are the labels that configure the discovery instead of a config field
+	discoveryLabels = map[string]bool{
+		"enable":    true,
+		"host":      true,
+		"port":      true,
+		"autostart": true,
+		"scale":     true,
+	}
+
+	// discoveryFields are the config fields that labels and annotations can set.
+	// Whoever can label a container should not be able to run commands on the host of Infrared
+	// or to read its files, so fields like docker.exec or iconPath cannot be discovered.
+	discoveryFields = map[string]bool{
+		"domainName":                   true,
+		"listenTo":                     true,
+		"timeout":                      true,
+		"disconnectMessage":            true,
+		"docker.timeout":               true,
+		"onlineStatus.versionName":     true,
+		"onlineStatus.protocolNumber":  true,
+		"onlineStatus.maxPlayers":      true,
+		"onlineStatus.playersOnline":   true,
+		"onlineStatus.motd":            true,
+		"offlineStatus.versionName":    true,
+		"offlineStatus.protocolNumber": true,
+		"offlineStatus.maxPlayers":     true,
+		"offlineStatus.playersOnline":  true,
+		"offlineStatus.motd":           true,
+	}
+)
+
+// DockerConfigProvider creates proxy configs from the labels of Docker containers like
+//
+//	infrared.domain=survival.example.com
+//	infrared.port=25565
+//	infrared.autostart=true
+//	infrared.offline.motd=Join to start the server
+//
+// Containers with a domain label are discovered, unless infrared.enable is false.
+// The proxy forwards to the container name and port, which resolves in a shared Docker network,
+// or to the address of infrared.host and port. With autostart the container is started
+// when a player joins and stopped after docker.timeout without players.
+// Labels like infrared.timeout=5000 or infrared.offline.versionName=Sleeping set the field
+// of the proxy config at their path, but only for a few safe fields. Their values are taken
+// literally without interpolation and they cannot extend other configs.
+type DockerConfigProvider struct {
+	// Client defaults to a client that is configured by environment variables like DOCKER_HOST
+	Client *client.Client
+	// LabelPrefix defaults to DefaultDockerLabelPrefix
+	LabelPrefix string
+
+	configs configSet
+}
+
+// Provide sends the configs of all labeled containers and then watches the Docker events
+// for created, renamed and destroyed containers until the context is done.
+// If the Docker daemon cannot be reached, it tries again after a few seconds.
+func (provider *DockerConfigProvider) Provide(ctx context.Context, events chan<- ConfigEvent) error {
+	if provider.Client == nil {
+		cli, err := client.NewClientWithOpts(client.FromEnv)
+		if err != nil {
+			return err
+		}
+		provider.Client = cli
+	}
+
+	send := func(event ConfigEvent) {
+		select {
+		case events <- event:
+		case <-ctx.Done():
+		}
+	}
+
+	for {
+		if err := provider.watch(ctx, send); err != nil && ctx.Err() == nil {
+			log.Println("Failed watching Docker containers; error:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(dockerRetryInterval):
+		}
+	}
+}
+
+// watch syncs the configs with the containers every time a container changed
+func (provider *DockerConfigProvider) watch(ctx context.Context, send func(ConfigEvent)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Subscribing before listing the containers ensures that no change is missed
+	messages, errs := provider.Client.Events(ctx, types.EventsOptions{
+		Filters: filters.NewArgs(filters.Arg("type", "container")),
+	})
+
+	if err := provider.sync(ctx, send); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case msg := <-messages:
+			switch msg.Action {
+			case "create", "destroy", "rename":
+				if err := provider.sync(ctx, send); err != nil {
+					return err
+				}
+			}
+		case err := <-errs:
+			return err
+		}
+	}
+}
+
+// sync loads the configs of all labeled containers and removes the configs of containers that are gone
+func (provider *DockerConfigProvider) sync(ctx context.Context, send func(ConfigEvent)) error {
+	prefix := provider.labelPrefix()
+	containers, err := provider.Client.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", prefix+".domain")),
+	})
+	if err != nil {
+		return err
+	}
+
+	var sources []configSource
+	for _, container := range containers {
+		if len(container.Names) == 0 || container.Labels[prefix+".enable"] == "false" {
+			continue
+		}
+
+		name := strings.TrimPrefix(container.Names[0], "/")
+		raw, err := json.Marshal(dockerLabelsToConfig(name, container.Labels, prefix))
+		if err != nil {
+			return err
+		}
+
+		sources = append(sources, configSource{
+			name:   "docker://" + name,
+			raw:    raw,
+			origin: configOriginDiscovered,
+		})
+	}
+
+	provider.configs.apply(sources, send)
+	return nil
+}
+
+func (provider *DockerConfigProvider) labelPrefix() string {
+	if provider.LabelPrefix == "" {
+		return DefaultDockerLabelPrefix
+	}
+	return provider.LabelPrefix
+}
+
+// dockerLabelsToConfig returns the raw proxy config of a container with the labels
+func dockerLabelsToConfig(name string, labels map[string]string, prefix string) map[string]interface{} {
+	raw := labelsToConfig(labels, prefix)
+
+	if autostart, _ := strconv.ParseBool(labels[prefix+".autostart"]); autostart {
+		setKeyPath(raw, []string{"docker", "containerName"}, name)
+	}
+
+	if _, ok := raw["proxyTo"]; !ok {
+		host, port := name, defaultMinecraftPort
+		if value, ok := labels[prefix+".host"]; ok {
+			host = value
+		}
+		if value, ok := labels[prefix+".port"]; ok {
+			port = value
+		}
+		raw["proxyTo"] = net.JoinHostPort(host, port)
+	}
+	return raw
+}
+
+// labelsToConfig returns the raw proxy config of labels or annotations like infrared.domain or infrared.offline.motd.
+// The settings of the discovery like infrared.host or infrared.autostart are skipped,
+// as well as all labels of fields that are not discoveryFields.
+func labelsToConfig(labels map[string]string, prefix string) map[string]interface{} {
+	raw := map[string]interface{}{}
+	proxyType := reflect.TypeOf(ProxyConfig{})
+
+	for key, value := range labels {
+		if !strings.HasPrefix(key, prefix+".") {
+			continue
+		}
+
+		path := strings.Split(strings.TrimPrefix(key, prefix+"."), ".")
+		if discoveryLabels[path[0]] {
+			continue
+		}
+
+		if alias, ok := labelAliases[path[0]]; ok {
+			path[0] = alias
+		}
+
+		if !discoveryFields[strings.Join(path, ".")] {
+			log.Printf("[w] Skipping label %s; only the domain, listen address, timeouts and status texts can be discovered", key)
+			continue
+		}
+		setKeyPath(raw, path, labelValue(proxyType, path, value))
+	}
+	return raw
+}
+
+// labelValue converts the value of a label to the type of the field at the key path.
+// Values that do not fit the type stay strings, so that validation reports them.
+func labelValue(t reflect.Type, path []string, value string) interface{} {
+	for _, key := range path {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+
+		if t.Kind() != reflect.Struct {
+			return value
+		}
+
+		field, ok := jsonFields(t)[key]
+		if !ok {
+			return value
+		}
+		t = field.Type
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i
+		}
+	case reflect.Float32, reflect.Float64:
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.String {
+			items := []interface{}{}
+			for _, item := range strings.Split(value, ",") {
+				items = append(items, strings.TrimSpace(item))
+			}
+			return items
+		}
+		fallthrough
+	case reflect.Struct, reflect.Map:
+		var v interface{}
+		if err := json.Unmarshal([]byte(value), &v); err == nil {
+			return v
+		}
+	}
+	return value
+}
+
+// setKeyPath sets the value at the key path and creates the objects on the way
+func setKeyPath(raw map[string]interface{}, path []string, value interface{}) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := raw[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			raw[key] = next
+		}
+		raw = next
+	}
+	raw[path[len(path)-1]] = value
+}
@@ -0,0 +1,193 @@
+package infrared
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/client"
+)
+
+// fakeDockerAPI serves the container list and the events of the Docker Engine API
+type fakeDockerAPI struct {
+	mu         sync.Mutex
+	containers []types.Container
+	events     chan events.Message
+}
+
+func (api *fakeDockerAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/containers/json"):
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		json.NewEncoder(w).Encode(api.containers)
+	case strings.HasSuffix(r.URL.Path, "/events"):
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case msg := <-api.events:
+				json.NewEncoder(w).Encode(msg)
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (api *fakeDockerAPI) setContainers(action string, containers ...types.Container) {
+	api.mu.Lock()
+	api.containers = containers
+	api.mu.Unlock()
+	api.events <- events.Message{Type: "container", Action: action}
+}
+
+func TestDockerLabelsToConfig(t *testing.T) {
+	labels := map[string]string{
+		"infrared.domain":                 "survival.example.com",
+		"infrared.port":                   "25566",
+		"infrared.autostart":              "true",
+		"infrared.offline.motd":           "Join to start the server",
+		"infrared.offline.protocolNumber": "757",
+		"infrared.offline.iconPath":       "/etc/passwd",
+		"infrared.docker.timeout":         "600000",
+		"infrared.docker.exec.command":    "sh",
+		"infrared.callbackServer.url":     "http://example.com",
+		"infrared.timeout":                "5000",
+		"traefik.enable":                  "false",
+	}
+
+	expected := map[string]interface{}{
+		"domainName": "survival.example.com",
+		"proxyTo":    "survival:25566",
+		"docker": map[string]interface{}{
+			"containerName": "survival",
+			"timeout":       int64(600000),
+		},
+		"offlineStatus": map[string]interface{}{
+			"motd":           "Join to start the server",
+			"protocolNumber": int64(757),
+		},
+		"timeout": int64(5000),
+	}
+
+	if raw := dockerLabelsToConfig("survival", labels, "infrared"); !reflect.DeepEqual(raw, expected) {
+		t.Errorf("expected %v, got %v", expected, raw)
+	}
+
+	raw := dockerLabelsToConfig("survival", map[string]string{
+		"infrared.domain":    "survival.example.com",
+		"infrared.host":      "10.0.0.2",
+		"infrared.autostart": "false",
+	}, "infrared")
+	if raw["proxyTo"] != "10.0.0.2:25565" || raw["docker"] != nil {
+		t.Errorf("unexpected config %v", raw)
+	}
+}
+
+func TestDockerConfigProvider_Provide(t *testing.T) {
+	api := &fakeDockerAPI{
+		events: make(chan events.Message),
+		containers: []types.Container{
+			{
+				ID:    "1",
+				Names: []string{"/lobby"},
+				Labels: map[string]string{
+					"infrared.domain": "lobby.example.com",
+				},
+			},
+			{
+				ID:    "2",
+				Names: []string{"/disabled"},
+				Labels: map[string]string{
+					"infrared.domain": "disabled.example.com",
+					"infrared.enable": "false",
+				},
+			},
+		},
+	}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://" + server.Listener.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	provider := DockerConfigProvider{Client: cli}
+	events := make(chan ConfigEvent)
+	go provider.Provide(ctx, events)
+
+	receive := func(t *testing.T) ConfigEvent {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(time.Second):
+			t.Fatal("no event was sent")
+			return ConfigEvent{}
+		}
+	}
+
+	event := receive(t)
+	if event.Type != ConfigAdded || event.ID != "docker://lobby" || event.Config.ProxyTo != "lobby:25565" {
+		t.Fatalf("expected lobby to be added, got %s %s", event.ID, event.Type)
+	}
+	lobby := event.Config
+
+	// Containers that are created with other labels are updated
+	survival := types.Container{
+		ID:    "3",
+		Names: []string{"/survival"},
+		Labels: map[string]string{
+			"infrared.domain":       "survival.example.com",
+			"infrared.autostart":    "true",
+			"infrared.offline.motd": "Join to start the server",
+		},
+	}
+	recreatedLobby := types.Container{
+		ID:    "4",
+		Names: []string{"/lobby"},
+		Labels: map[string]string{
+			"infrared.domain": "hub.example.com",
+		},
+	}
+	api.setContainers("create", recreatedLobby, survival)
+
+	for i := 0; i < 2; i++ {
+		event := receive(t)
+		switch event.ID {
+		case "docker://lobby":
+			if event.Type != ConfigUpdated || event.Config != lobby || lobby.DomainName != "hub.example.com" {
+				t.Errorf("lobby was not updated: %s %s", event.Type, lobby.DomainName)
+			}
+		case "docker://survival":
+			if event.Type != ConfigAdded || event.Config.Docker.ContainerName != "survival" ||
+				event.Config.OfflineStatus.MOTD != "Join to start the server" {
+				t.Errorf("unexpected survival config %s %+v", event.Type, event.Config)
+			}
+		default:
+			t.Errorf("unexpected event %s %s", event.ID, event.Type)
+		}
+	}
+
+	api.setContainers("destroy", survival)
+
+	event = receive(t)
+	if event.Type != ConfigRemoved || event.ID != "docker://lobby" {
+		t.Errorf("expected lobby to be removed, got %s %s", event.ID, event.Type)
+	}
+}
@@ -0,0 +1,194 @@
+package infrared
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strconv"
+)
+
+// ConfigExtends are the paths of the base configs that a proxy config extends.
+// In config files it is a single path or a list of paths.
+// Relative paths are relative to the directory of the config.
+type ConfigExtends []string
+
+func (extends *ConfigExtends) UnmarshalJSON(bb []byte) error {
+	if string(bb) == "null" {
+		return nil
+	}
+
+	var path string
+	if err := json.Unmarshal(bb, &path); err == nil {
+		*extends = ConfigExtends{path}
+		return nil
+	}
+
+	var paths []string
+	if err := json.Unmarshal(bb, &paths); err != nil {
+		return errors.New("extends has to be a path or a list of paths")
+	}
+	*extends = paths
+	return nil
+}
+
+// extendsPaths returns the paths of the bases like they are written in a decoded config
+func extendsPaths(value interface{}) ([]string, error) {
+	switch value := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{value}, nil
+	case []interface{}:
+		paths := make([]string, len(value))
+		for i, v := range value {
+			path, ok := v.(string)
+			if !ok {
+				return nil, errors.New("must be a path or a list of paths")
+			}
+			paths[i] = path
+		}
+		return paths, nil
+	default:
+		return nil, errors.New("must be a path or a list of paths")
+	}
+}
+
+// resolveBases loads the bases that the file extends, including the bases of the bases,
+// in the order in which they are merged. The chain holds the files that extend the file.
+func (file *configFile) resolveBases(chain []string) {
+	paths, err := extendsPaths(file.raw["extends"])
+	if err != nil {
+		file.addError([]string{"extends"}, "%s", err)
+		return
+	}
+
+	chain = append(chain, filepath.Clean(file.path))
+	for i, basePath := range paths {
+		keyPath := []string{"extends"}
+		if _, ok := file.raw["extends"].([]interface{}); ok {
+			keyPath = append(keyPath, strconv.Itoa(i))
+		}
+
+		if !filepath.IsAbs(basePath) {
+			basePath = filepath.Join(filepath.Dir(file.path), basePath)
+		}
+		basePath = filepath.Clean(basePath)
+
+		if containsPath(chain, basePath) {
+			file.addError(keyPath, "%s extends itself", basePath)
+			continue
+		}
+
+		bb, err := ioutil.ReadFile(basePath)
+		if err != nil {
+			file.addError(keyPath, "%s", err)
+			continue
+		}
+
+		base := parseConfigFile(basePath, bb)
+		base.strict = file.strict
+		if base.raw != nil {
+			base.checkUnknownKeys(base.raw, reflect.TypeOf(ProxyConfig{}), nil)
+			base.resolveBases(chain)
+			file.bases = append(file.bases, base.bases...)
+			file.bases = append(file.bases, base)
+		}
+		file.errors = append(file.errors, base.errors...)
+		file.warnings = append(file.warnings, base.warnings...)
+	}
+}
+
+func containsPath(paths []string, path string) bool {
+	for _, p := range paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeBases returns the raw config deep merged on top of its bases
+func (file *configFile) mergeBases() map[string]interface{} {
+	merged := map[string]interface{}{}
+	for _, base := range file.bases {
+		raw := make(map[string]interface{}, len(base.raw))
+		for k, v := range base.raw {
+			// Only the bases of the file itself are kept
+			if k == "extends" {
+				continue
+			}
+			raw[k] = v
+		}
+		merged = deepMerge(merged, raw)
+	}
+	return deepMerge(merged, file.raw)
+}
+
+// basePaths returns the paths of all files that the file extends
+func (file *configFile) basePaths() []string {
+	paths := make([]string, len(file.bases))
+	for i, base := range file.bases {
+		paths[i] = base.path
+	}
+	return paths
+}
+
+// source returns the file that the value at the key path comes from.
+// Values of the file override the values of its bases, and later bases override earlier ones.
+func (file *configFile) source(path []string) *configFile {
+	for i := len(path); i > 0; i-- {
+		if hasKeyPath(file.raw, path[:i]) {
+			return file
+		}
+
+		for j := len(file.bases) - 1; j >= 0; j-- {
+			if hasKeyPath(file.bases[j].raw, path[:i]) {
+				return file.bases[j]
+			}
+		}
+	}
+	return file
+}
+
+func hasKeyPath(value interface{}, path []string) bool {
+	for _, key := range path {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if value, ok = v[key]; !ok {
+				return false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return false
+			}
+			value = v[i]
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// deepMerge returns a copy of dst with the values of src.
+// Nested objects are merged and all other values, including lists, are replaced.
+func deepMerge(dst, src map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(dst)+len(src))
+	for k, v := range dst {
+		merged[k] = v
+	}
+
+	for k, v := range src {
+		srcMap, srcIsMap := v.(map[string]interface{})
+		dstMap, dstIsMap := merged[k].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			merged[k] = deepMerge(dstMap, srcMap)
+			continue
+		}
+		merged[k] = v
+	}
+	return merged
+}
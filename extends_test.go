@@ -0,0 +1,228 @@
+package infrared
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDeepMerge(t *testing.T) {
+	dst := map[string]interface{}{
+		"domainName": "base.example.com",
+		"offlineStatus": map[string]interface{}{
+			"versionName": "Infrared",
+			"motd":        "Base",
+		},
+		"priorityPlayers": []interface{}{"Notch"},
+	}
+	src := map[string]interface{}{
+		"offlineStatus": map[string]interface{}{
+			"motd": "Proxy",
+		},
+		"priorityPlayers": []interface{}{"jeb_"},
+	}
+
+	expected := map[string]interface{}{
+		"domainName": "base.example.com",
+		"offlineStatus": map[string]interface{}{
+			"versionName": "Infrared",
+			"motd":        "Proxy",
+		},
+		"priorityPlayers": []interface{}{"jeb_"},
+	}
+
+	if merged := deepMerge(dst, src); !reflect.DeepEqual(merged, expected) {
+		t.Errorf("expected %v, got %v", expected, merged)
+	}
+
+	if dst["offlineStatus"].(map[string]interface{})["motd"] != "Base" {
+		t.Error("dst was modified")
+	}
+}
+
+func TestProxyConfig_LoadFromPath_Extends(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"_base.json": `{
+  "disconnectMessage": "Come back later",
+  "offlineStatus": {
+    "versionName": "Network 1.18",
+    "motd": "Offline"
+  },
+  "docker": {
+    "timeout": 60000
+  }
+}`,
+		"_docker.yml": `extends: _base.json
+docker:
+  dnsServer: 10.0.0.53
+`,
+		"proxy.yml": `extends: [_docker.yml]
+domainName: mc.example.com
+proxyTo: ":25566"
+offlineStatus:
+  motd: Survival is offline
+docker:
+  containerName: survival
+`,
+	})
+
+	var cfg ProxyConfig
+	if err := cfg.LoadFromPath(filepath.Join(dir, "proxy.yml")); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.DisconnectMessage != "Come back later" {
+		t.Errorf("expected disconnect message of the base, got %q", cfg.DisconnectMessage)
+	}
+
+	if cfg.OfflineStatus.VersionName != "Network 1.18" || cfg.OfflineStatus.MOTD != "Survival is offline" {
+		t.Errorf("offline status was not deep merged: %+v", cfg.OfflineStatus)
+	}
+
+	if cfg.Docker.Timeout != 60000 || cfg.Docker.DNSServer != "10.0.0.53" || cfg.Docker.ContainerName != "survival" {
+		t.Errorf("docker config was not deep merged: %+v", cfg.Docker)
+	}
+
+	// Fields that no file sets keep their default
+	if cfg.ServerFullMessage != DefaultProxyConfig().ServerFullMessage {
+		t.Errorf("expected default server full message, got %q", cfg.ServerFullMessage)
+	}
+
+	expectedBases := []string{filepath.Join(dir, "_base.json"), filepath.Join(dir, "_docker.yml")}
+	if !reflect.DeepEqual(cfg.bases, expectedBases) {
+		t.Errorf("expected bases %v, got %v", expectedBases, cfg.bases)
+	}
+}
+
+func TestValidateProxyConfig_Extends(t *testing.T) {
+	tt := []struct {
+		name  string
+		files map[string]string
+		errs  []string
+	}{
+		{
+			name: "MissingBase",
+			files: map[string]string{
+				"proxy.yml": "extends: [_base.yml, _missing.yml]\ndomainName: mc.example.com\nproxyTo: \":25566\"\n",
+				"_base.yml": "timeout: 500\n",
+			},
+			errs: []string{"proxy.yml:1: extends[1]: open "},
+		},
+		{
+			name: "Cycle",
+			files: map[string]string{
+				"proxy.yml": "extends: _a.yml\ndomainName: mc.example.com\nproxyTo: \":25566\"\n",
+				"_a.yml":    "extends: _b.yml\n",
+				"_b.yml":    "timeout: 500\nextends: _a.yml\n",
+			},
+			errs: []string{"_b.yml:2: extends: "},
+		},
+		{
+			name: "UnknownKeyInBase",
+			files: map[string]string{
+				"proxy.yml": "extends: _base.yml\ndomainName: mc.example.com\nproxyTo: \":25566\"\n",
+				"_base.yml": "offlineStatus:\n  MOTD: Offline\n",
+			},
+			errs: []string{`_base.yml:2: offlineStatus.MOTD: unknown field; did you mean "motd"?`},
+		},
+		{
+			name: "InvalidValueInBase",
+			files: map[string]string{
+				"proxy.yml": "extends: _base.yml\ndomainName: mc.example.com\n",
+				"_base.yml": "timeout: 500\nproxyTo: localhost\n",
+			},
+			errs: []string{`_base.yml:2: proxyTo: "localhost" is not an address like host:port`},
+		},
+		{
+			name: "InvalidExtends",
+			files: map[string]string{
+				"proxy.yml": "extends: 42\ndomainName: mc.example.com\nproxyTo: \":25566\"\n",
+			},
+			errs: []string{"proxy.yml:1: extends: must be a path or a list of paths"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tc.files)
+
+			path := filepath.Join(dir, "proxy.yml")
+			err := ValidateProxyConfig(path, []byte(tc.files["proxy.yml"]))
+			var errs ValidationErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("expected validation errors, got: %v", err)
+			}
+
+			if len(errs) != len(tc.errs) {
+				t.Fatalf("expected %d errors, got:\n%s", len(tc.errs), errs)
+			}
+
+			for i, expected := range tc.errs {
+				if !strings.HasPrefix(errs[i].Error(), filepath.Join(dir, expected)) {
+					t.Errorf("expected error %q, got %q", expected, errs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestProxyConfig_Watch_Base(t *testing.T) {
+	dir := t.TempDir()
+	baseDir := t.TempDir()
+	basePath := filepath.Join(baseDir, "base.yml")
+	writeFiles(t, baseDir, map[string]string{
+		"base.yml": "disconnectMessage: Old message\n",
+	})
+	writeFiles(t, dir, map[string]string{
+		"proxy.yml": "extends: " + basePath + "\ndomainName: mc.example.com\nproxyTo: \":25566\"\n",
+	})
+
+	cfg, err := NewProxyConfigFromPath(filepath.Join(dir, "proxy.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cfg.watcher.Close()
+
+	changed := make(chan bool, 10)
+	cfg.Lock()
+	cfg.changeCallback = func() {
+		changed <- true
+	}
+	cfg.Unlock()
+	// Give the watcher time to start
+	time.Sleep(50 * time.Millisecond)
+
+	writeFiles(t, baseDir, map[string]string{
+		"base.yml": "disconnectMessage: New message\n",
+	})
+
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("change of the base was not noticed")
+	}
+
+	select {
+	case <-changed:
+		t.Error("expected a single reload")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if cfg.DisconnectMessage != "New message" {
+		t.Errorf("expected message of the changed base, got %q", cfg.DisconnectMessage)
+	}
+}
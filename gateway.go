@@ -2,9 +2,12 @@ package infrared
 
 import (
 	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/haveachin/infrared/callback"
 	"github.com/haveachin/infrared/protocol/handshaking"
@@ -15,6 +18,8 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+var ErrNoProxy = errors.New("no proxy")
+
 var (
 	proxiesActive = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "infrared_proxies",
@@ -24,10 +29,76 @@ var (
 
 type Gateway struct {
 	ReceiveProxyProtocol bool
-	listeners            sync.Map
-	Proxies              sync.Map
-	closed               chan bool
-	wg                   sync.WaitGroup
+	ReceiveRealIP        bool
+	RealIP               RealIPConfig
+	// Listeners are the settings of single listeners by their listen address like ":25566".
+	// Listeners without settings use the settings of the gateway.
+	Listeners           map[string]ListenerConfig
+	RateLimit           ListenerRateLimitConfig
+	AccessList          *AccessList
+	Timeouts            TimeoutConfig
+	AttackMode          AttackModeConfig
+	GeoIP               *GeoIP
+	PrometheusGeoLabels bool
+	MaxConnections      int
+	DuplicateLogin      DuplicateLoginConfig
+	Players             PlayerRegistry
+	AuditLog            *AuditLog
+	settingsMu          sync.RWMutex
+	listeners           sync.Map
+	limiters            sync.Map
+	attackModes         sync.Map
+	listenerPlayers     connCounter
+	Proxies             sync.Map
+	closed              chan bool
+	wg                  sync.WaitGroup
+}
+
+// ListenerConfig are the settings of a single listener, which replace the settings of the gateway.
+// This way a public listener and a listener that only proxies like TCPShield may connect to can coexist.
+type ListenerConfig struct {
+	ReceiveRealIP bool
+	RealIP        RealIPConfig
+	RateLimit     ListenerRateLimitConfig
+}
+
+// listenerConfig returns the settings of the listener at addr
+func (gateway *Gateway) listenerConfig(addr string) ListenerConfig {
+	if cfg, ok := gateway.Listeners[addr]; ok {
+		return cfg
+	}
+
+	return ListenerConfig{
+		ReceiveRealIP: gateway.ReceiveRealIP,
+		RealIP:        gateway.RealIP,
+		RateLimit:     gateway.RateLimit,
+	}
+}
+
+// GatewaySettings are the settings of a gateway that can change while it is running
+type GatewaySettings struct {
+	Timeouts       TimeoutConfig
+	MaxConnections int
+	DuplicateLogin DuplicateLoginConfig
+}
+
+func (gateway *Gateway) settings() GatewaySettings {
+	gateway.settingsMu.RLock()
+	defer gateway.settingsMu.RUnlock()
+	return GatewaySettings{
+		Timeouts:       gateway.Timeouts,
+		MaxConnections: gateway.MaxConnections,
+		DuplicateLogin: gateway.DuplicateLogin,
+	}
+}
+
+// UpdateSettings changes the settings of the gateway for new connections
+func (gateway *Gateway) UpdateSettings(settings GatewaySettings) {
+	gateway.settingsMu.Lock()
+	defer gateway.settingsMu.Unlock()
+	gateway.Timeouts = settings.Timeouts
+	gateway.MaxConnections = settings.MaxConnections
+	gateway.DuplicateLogin = settings.DuplicateLogin
 }
 
 func (gateway *Gateway) ListenAndServe(proxies []*Proxy) error {
@@ -105,25 +176,17 @@ func (gateway *Gateway) CloseProxy(proxyUID string) {
 	v.(Listener).Close()
 }
 
+// errProxyUIDRegistered is returned for a proxy whose UID is already used by another proxy
+var errProxyUIDRegistered = errors.New("UID is already registered by another proxy")
+
 func (gateway *Gateway) RegisterProxy(proxy *Proxy) error {
 	// Register new Proxy
 	proxyUID := proxy.UID()
 	log.Println("Registering proxy with UID", proxyUID)
-	gateway.Proxies.Store(proxyUID, proxy)
-	proxiesActive.Inc()
-
-	proxy.Config.removeCallback = func() {
-		gateway.CloseProxy(proxyUID)
-	}
-
-	proxy.Config.changeCallback = func() {
-		if proxyUID == proxy.UID() {
-			return
-		}
-		gateway.CloseProxy(proxyUID)
-		if err := gateway.RegisterProxy(proxy); err != nil {
-			log.Println(err)
-		}
+	if v, loaded := gateway.Proxies.LoadOrStore(proxyUID, proxy); !loaded {
+		proxiesActive.Inc()
+	} else if v.(*Proxy) != proxy {
+		return fmt.Errorf("%w: %s", errProxyUIDRegistered, proxyUID)
 	}
 
 	playersConnected.WithLabelValues(proxy.DomainName())
@@ -156,10 +219,11 @@ func (gateway *Gateway) listenAndServe(listener Listener, addr string) error {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			// TODO: Refactor this; it feels hacky
-			if err.Error() == "use of closed network connection" {
+			if errors.Is(err, net.ErrClosed) {
 				log.Println("Closing listener on", addr)
 				gateway.listeners.Delete(addr)
+				gateway.limiters.Delete(addr)
+				gateway.attackModes.Delete(addr)
 				return nil
 			}
 
@@ -169,7 +233,14 @@ func (gateway *Gateway) listenAndServe(listener Listener, addr string) error {
 		go func() {
 			log.Printf("[>] Incoming %s on listener %s", conn.RemoteAddr(), addr)
 			defer conn.Close()
-			if err := gateway.serve(conn, addr); err != nil {
+			entry := &AuditEntry{
+				Start:    time.Now(),
+				Listener: addr,
+				ClientIP: addrString(conn.RemoteAddr()),
+			}
+			err := gateway.serve(conn, addr, entry)
+			gateway.audit(entry, err)
+			if err != nil {
 				log.Printf("[x] %s closed connection with %s; error: %s", conn.RemoteAddr(), addr, err)
 				return
 			}
@@ -178,19 +249,50 @@ func (gateway *Gateway) listenAndServe(listener Listener, addr string) error {
 	}
 }
 
-func (gateway *Gateway) serve(conn Conn, addr string) error {
+// audit writes the entry of a closed connection to the audit log
+func (gateway *Gateway) audit(entry *AuditEntry, err error) {
+	if gateway.AuditLog == nil {
+		return
+	}
+
+	entry.finish(err, time.Now())
+	if err := gateway.AuditLog.Write(*entry); err != nil {
+		log.Printf("[w] Failed writing audit log; error: %s", err)
+	}
+}
+
+func (gateway *Gateway) serve(conn Conn, addr string, entry *AuditEntry) error {
+	mode := gateway.observeAttack(addr)
+	settings := gateway.settings()
+	listenerCfg := gateway.listenerConfig(addr)
+
+	if err := setReadTimeout(conn, settings.Timeouts.Handshake); err != nil {
+		return err
+	}
+
 	connRemoteAddr := conn.RemoteAddr()
 	if gateway.ReceiveProxyProtocol {
 		header, err := proxyproto.Read(conn.Reader())
 		if err != nil {
-			return err
+			return gateway.countTimeout(addr, "", phaseError(err, TimeoutPhaseHandshake))
 		}
 		connRemoteAddr = header.SourceAddr
 	}
 
+	// RealIP handshakes of trusted sources are limited by the client address in them
+	// once the handshake was read, and not by the address of the trusted source
+	limitAfterHandshake := listenerCfg.ReceiveRealIP && listenerCfg.RealIP.isTrustedSource(connRemoteAddr)
+	if !limitAfterHandshake {
+		release, err := gateway.limitConn(addr, connRemoteAddr, mode)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
 	pk, err := conn.PeekPacket()
 	if err != nil {
-		return err
+		return gateway.countTimeout(addr, "", phaseError(err, TimeoutPhaseHandshake))
 	}
 
 	hs, err := handshaking.UnmarshalServerBoundHandshake(pk)
@@ -198,17 +300,76 @@ func (gateway *Gateway) serve(conn Conn, addr string) error {
 		return err
 	}
 
+	entry.ClientIP = addrString(connRemoteAddr)
+	entry.Hostname = hs.ParseServerAddress()
+	entry.ProtocolVersion = int(hs.ProtocolVersion)
+	entry.NextState = nextStateName(hs)
+
+	if listenerCfg.ReceiveRealIP && hs.IsRealIPAddress() {
+		connRemoteAddr, err = listenerCfg.RealIP.Verify(hs, connRemoteAddr, time.Now())
+		if err != nil {
+			return err
+		}
+		entry.ClientIP = addrString(connRemoteAddr)
+	}
+
 	proxyUID := proxyUID(hs.ParseServerAddress(), addr)
 
 	log.Printf("[i] %s requests proxy with UID %s", connRemoteAddr, proxyUID)
-	v, ok := gateway.Proxies.Load(proxyUID)
-	if !ok {
-		// Client send an invalid address/port; we don't have a v for that address
-		return errors.New("no proxy with uid " + proxyUID)
+	var proxy *Proxy
+	if v, ok := gateway.Proxies.Load(proxyUID); ok {
+		proxy = v.(*Proxy)
+	}
+
+	location, err := gateway.GeoIP.Lookup(addrIP(connRemoteAddr))
+	if err != nil {
+		log.Printf("[w] Failed GeoIP lookup of %s; error: %s", connRemoteAddr, err)
+	}
+
+	if err := gateway.checkAccess(conn, connRemoteAddr, hs, proxy, location); err != nil {
+		return err
+	}
+
+	if limitAfterHandshake {
+		release, err := gateway.limitConn(addr, connRemoteAddr, mode)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	if err := gateway.limitHandshake(addr, connRemoteAddr, hs, proxy, mode); err != nil {
+		return err
+	}
+
+	if proxy == nil {
+		// Client send an invalid address/port; we don't have a proxy for that address
+		return fmt.Errorf("%w with uid %s", ErrNoProxy, proxyUID)
+	}
+	entry.ProxyUID = proxyUID
+
+	if mode.isActive() {
+		if hs.IsStatusRequest() {
+			return gateway.countTimeout(addr, proxy.DomainName(), gateway.serveCachedStatus(conn, connRemoteAddr, proxy, mode))
+		}
+
+		if err := gateway.checkPing(conn, connRemoteAddr, proxy, mode); err != nil {
+			return err
+		}
 	}
-	proxy := v.(*Proxy)
 
-	if err := proxy.handleConn(conn, connRemoteAddr); err != nil {
+	opts := connOptions{
+		timeouts:       settings.Timeouts,
+		geoLabels:      gateway.PrometheusGeoLabels,
+		listenerSlots:  listenerSlots{counter: &gateway.listenerPlayers, addr: addr, max: settings.MaxConnections},
+		players:        &gateway.Players,
+		duplicateLogin: settings.DuplicateLogin,
+		audit:          entry,
+	}
+	if err := proxy.handleConn(conn, connRemoteAddr, location, opts); err != nil {
+		if errors.Is(err, ErrTimeout) {
+			return gateway.countTimeout(addr, proxy.DomainName(), err)
+		}
 		proxy.CallbackLogger().LogEvent(callback.ErrorEvent{
 			Error:    err.Error(),
 			ProxyUID: proxyUID,
@@ -217,3 +378,254 @@ func (gateway *Gateway) serve(conn Conn, addr string) error {
 	}
 	return nil
 }
+
+// checkAccess checks the client against the global and the proxy access list
+// and the countries of the proxy. Denied clients that try to log in get
+// the deny message of the list that denied them.
+func (gateway *Gateway) checkAccess(conn Conn, connRemoteAddr net.Addr, hs handshaking.ServerBoundHandshake, proxy *Proxy, location GeoIPLocation) error {
+	ip := addrIP(connRemoteAddr)
+	if ip == nil {
+		return nil
+	}
+
+	deniedBy, err := gateway.deniedBy(ip, proxy, location)
+	if err == nil {
+		return nil
+	}
+
+	if hs.IsLoginRequest() && deniedBy.DenyMessage() != "" {
+		if err := conn.WritePacket(disconnectPacket(deniedBy.DenyMessage())); err != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+// deniedBy returns the access list that denies the client and why.
+// Countries are denied by the access list of the proxy.
+func (gateway *Gateway) deniedBy(ip net.IP, proxy *Proxy, location GeoIPLocation) (*AccessList, error) {
+	if !gateway.AccessList.IsAllowed(ip) {
+		return gateway.AccessList, ErrAccessDenied
+	}
+
+	if proxy == nil {
+		return nil, nil
+	}
+
+	proxyAccessList, err := proxy.AccessList()
+	if err != nil {
+		return nil, err
+	}
+
+	if !proxyAccessList.IsAllowed(ip) {
+		return proxyAccessList, ErrAccessDenied
+	}
+
+	if !proxy.IsCountryAllowed(location.Country) {
+		return proxyAccessList, fmt.Errorf("%w; %q", ErrCountryDenied, location.Country)
+	}
+
+	return nil, nil
+}
+
+// limitConn applies the connection limits of the listener as soon as a connection is accepted,
+// so that connections which never send a handshake are limited and counted too.
+// If the connection is allowed, the returned func must be called after it was closed.
+func (gateway *Gateway) limitConn(addr string, connRemoteAddr net.Addr, mode *attackMode) (func(), error) {
+	ip := addrIP(connRemoteAddr)
+	if ip == nil {
+		return func() {}, nil
+	}
+
+	v, _ := gateway.limiters.LoadOrStore(addr, &listenerLimiter{})
+	release, reason, ok := v.(*listenerLimiter).acquire(ip, gateway.rateLimits(addr, mode), time.Now())
+	if !ok {
+		return nil, gateway.rateLimited(addr, connRemoteAddr, nil, reason)
+	}
+	return release, nil
+}
+
+// limitHandshake applies the handshake rate limits of the listener and the proxy
+func (gateway *Gateway) limitHandshake(addr string, connRemoteAddr net.Addr, hs handshaking.ServerBoundHandshake, proxy *Proxy, mode *attackMode) error {
+	ip := addrIP(connRemoteAddr)
+	if ip == nil {
+		return nil
+	}
+
+	now := time.Now()
+	v, _ := gateway.limiters.LoadOrStore(addr, &listenerLimiter{})
+	reason, ok := v.(*listenerLimiter).allowHandshake(ip, hs.IsLoginRequest(), gateway.rateLimits(addr, mode), now)
+	if ok && proxy != nil {
+		reason, ok = proxy.allowHandshake(ip, hs.IsLoginRequest(), now)
+	}
+
+	if !ok {
+		return gateway.rateLimited(addr, connRemoteAddr, proxy, reason)
+	}
+	return nil
+}
+
+// rateLimits returns the rate limits of the listener at addr, which are tightened in attack mode
+func (gateway *Gateway) rateLimits(addr string, mode *attackMode) ListenerRateLimitConfig {
+	limits := gateway.listenerConfig(addr).RateLimit
+	if mode.isActive() {
+		return gateway.AttackMode.rateLimit(limits)
+	}
+	return limits
+}
+
+// rateLimited reports a connection that was rejected by a rate limit
+func (gateway *Gateway) rateLimited(addr string, connRemoteAddr net.Addr, proxy *Proxy, reason string) error {
+	host := ""
+	if proxy != nil {
+		host = proxy.DomainName()
+		proxy.logEvent(callback.RateLimitedEvent{
+			RemoteAddress: connRemoteAddr.String(),
+			ProxyUID:      proxy.UID(),
+			Reason:        reason,
+		})
+	}
+	rateLimitedConnections.With(prometheus.Labels{
+		"listener": addr,
+		"host":     host,
+		"reason":   reason,
+	}).Inc()
+
+	return fmt.Errorf("%w by %s limit", ErrRateLimited, reason)
+}
+
+// countTimeout increments the timeout metric if the error is a TimeoutError
+func (gateway *Gateway) countTimeout(addr, host string, err error) error {
+	var timeoutErr TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		return err
+	}
+
+	timedOutConnections.With(prometheus.Labels{
+		"listener": addr,
+		"host":     host,
+		"phase":    timeoutErr.Phase,
+	}).Inc()
+	return err
+}
+
+// observeAttack counts the connection towards the attack mode of the listener.
+// It returns nil if the attack mode is disabled.
+func (gateway *Gateway) observeAttack(addr string) *attackMode {
+	if !gateway.AttackMode.IsEnabled() {
+		return nil
+	}
+
+	v, _ := gateway.attackModes.LoadOrStore(addr, &attackMode{})
+	mode := v.(*attackMode)
+	if !mode.observe(gateway.AttackMode, time.Now()) {
+		return mode
+	}
+
+	log.Println("[!] Attack mode on for listener", addr)
+	attackModeActive.WithLabelValues(addr).Set(1)
+	gateway.logListenerEvent(addr, func(proxy *Proxy) callback.Event {
+		return callback.AttackModeOnEvent{ProxyUID: proxy.UID(), Listener: addr}
+	})
+
+	var expire func()
+	expire = func() {
+		if remaining := mode.expire(gateway.AttackMode.cooldown(), time.Now()); remaining > 0 {
+			time.AfterFunc(remaining, expire)
+			return
+		}
+
+		log.Println("[!] Attack mode off for listener", addr)
+		attackModeActive.WithLabelValues(addr).Set(0)
+		gateway.logListenerEvent(addr, func(proxy *Proxy) callback.Event {
+			return callback.AttackModeOffEvent{ProxyUID: proxy.UID(), Listener: addr}
+		})
+	}
+	time.AfterFunc(gateway.AttackMode.cooldown(), expire)
+
+	return mode
+}
+
+// logListenerEvent logs the event with every proxy that listens to addr
+func (gateway *Gateway) logListenerEvent(addr string, event func(proxy *Proxy) callback.Event) {
+	gateway.Proxies.Range(func(k, v interface{}) bool {
+		proxy := v.(*Proxy)
+		if proxy.ListenTo() == addr {
+			go proxy.logEvent(event(proxy))
+		}
+		return true
+	})
+}
+
+// serveCachedStatus answers a status request without connecting the client to the server
+// and remembers the ping of the client for its next login
+func (gateway *Gateway) serveCachedStatus(conn Conn, connRemoteAddr net.Addr, proxy *Proxy, mode *attackMode) error {
+	if _, err := conn.ReadPacket(); err != nil {
+		return err
+	}
+
+	if err := setReadTimeout(conn, gateway.settings().Timeouts.Status); err != nil {
+		return err
+	}
+
+	responsePk, err := proxy.cachedStatusPacket(attackModeStatusCacheTTL)
+	if err != nil {
+		return err
+	}
+
+	if err := exchangeStatus(conn, responsePk); err != nil {
+		return err
+	}
+
+	if ip := addrIP(connRemoteAddr); ip != nil {
+		mode.recordPing(ip.String(), time.Now())
+	}
+	return nil
+}
+
+// checkPing disconnects clients that try to log in without pinging the server first
+func (gateway *Gateway) checkPing(conn Conn, connRemoteAddr net.Addr, proxy *Proxy, mode *attackMode) error {
+	ip := addrIP(connRemoteAddr)
+	if ip == nil || mode.hasPinged(ip.String(), gateway.AttackMode.pingWindow(), time.Now()) {
+		return nil
+	}
+
+	proxy.logEvent(callback.LoginDeniedEvent{
+		RemoteAddress: connRemoteAddr.String(),
+		ProxyUID:      proxy.UID(),
+		Reason:        LoginDeniedReasonAttackMode,
+	})
+
+	if err := conn.WritePacket(disconnectPacket(gateway.AttackMode.message())); err != nil {
+		return err
+	}
+	return ErrPingRequired
+}
+
+// listenerSlots counts the players of a listener. A slot is reserved atomically,
+// so concurrent logins cannot exceed the player limit of the listener.
+type listenerSlots struct {
+	counter *connCounter
+	addr    string
+	max     int
+}
+
+// acquire reserves a slot. Priority players are counted but never rejected.
+func (slots listenerSlots) acquire(priority bool) bool {
+	if slots.counter == nil {
+		return true
+	}
+
+	max := slots.max
+	if priority {
+		max = 0
+	}
+	return slots.counter.acquire(slots.addr, max)
+}
+
+func (slots listenerSlots) release() {
+	if slots.counter != nil {
+		slots.counter.release(slots.addr)
+	}
+}
@@ -3,13 +3,19 @@ package infrared
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/haveachin/infrared/protocol"
 	"github.com/haveachin/infrared/protocol/handshaking"
+	"github.com/haveachin/infrared/protocol/login"
 	"github.com/haveachin/infrared/protocol/status"
 	"github.com/pires/go-proxyproto"
 )
@@ -50,6 +56,9 @@ func routeVersionName(index int) string {
 }
 
 func getIpFromAddr(addr net.Addr) string {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
 	return strings.Split(addr.String(), ":")[0]
 }
 
@@ -116,8 +125,8 @@ func statusPKWithVersion(name string) StatusConfig {
 		MaxPlayers: 20, PlayersOnline: 0, PlayerSamples: samples, MOTD: "Server MOTD"}
 }
 
-func sendProxyProtocolHeader(rconn Conn) *testError {
-	header := createProxyProtocolHeader()
+func sendProxyProtocolHeader(rconn Conn, sourceIP string) *testError {
+	header := createProxyProtocolHeader(sourceIP)
 	if _, err := header.WriteTo(rconn); err != nil {
 		return &testError{err, "Can't write proxy protocol header"}
 	}
@@ -177,6 +186,7 @@ type statusDialConfig struct {
 	gatewayAddr             string
 	dialerPort              int
 	sendProxyProtocolHeader bool
+	proxyProtocolSourceIP   string
 	useProxyProtocol        bool
 }
 
@@ -195,7 +205,7 @@ func statusDial(c statusDialConfig) (string, *testError) {
 	defer conn.Close()
 
 	if c.sendProxyProtocolHeader {
-		if err := sendProxyProtocolHeader(conn); err != nil {
+		if err := sendProxyProtocolHeader(conn, c.proxyProtocolSourceIP); err != nil {
 			return "", err
 		}
 	}
@@ -238,27 +248,45 @@ func createConnWithFakeIP(dialerPort int, gatewayAddr string) (Conn, error) {
 	return wrapConn(netConn), nil
 }
 
-func createProxyProtocolHeader() proxyproto.Header {
+func createProxyProtocolHeader(sourceIP string) proxyproto.Header {
+	if sourceIP == "" {
+		sourceIP = "109.226.143.210"
+	}
+
+	srcAddr := &net.TCPAddr{
+		IP:   net.ParseIP(sourceIP),
+		Port: 0,
+	}
+	dstAddr := &net.TCPAddr{
+		IP:   net.ParseIP("210.223.216.109"),
+		Port: 0,
+	}
+
+	transportProtocol := proxyproto.TCPv4
+	if srcAddr.IP.To4() == nil {
+		transportProtocol = proxyproto.TCPv6
+		dstAddr.IP = net.ParseIP("2001:db8::d8:6d")
+	}
+
 	return proxyproto.Header{
 		Version:           2,
 		Command:           proxyproto.PROXY,
-		TransportProtocol: proxyproto.TCPv4,
-		SourceAddr: &net.TCPAddr{
-			IP:   net.ParseIP("109.226.143.210"),
-			Port: 0,
-		},
-		DestinationAddr: &net.TCPAddr{
-			IP:   net.ParseIP("210.223.216.109"),
-			Port: 0,
-		},
+		TransportProtocol: transportProtocol,
+		SourceAddr:        srcAddr,
+		DestinationAddr:   dstAddr,
 	}
 }
 
-func proxyProtoListen(portEnd int) (string, *testError) {
+type proxyProtoResult struct {
+	ip     string
+	header *proxyproto.Header
+}
+
+func proxyProtoListen(portEnd int) (proxyProtoResult, *testError) {
 	listenAddr := serverAddr(portEnd)
 	listener, err := Listen(listenAddr)
 	if err != nil {
-		return "", &testError{err, fmt.Sprintf("Can't listen to %v", listenAddr)}
+		return proxyProtoResult{}, &testError{err, fmt.Sprintf("Can't listen to %v", listenAddr)}
 	}
 	defer listener.Close()
 
@@ -267,10 +295,14 @@ func proxyProtoListen(portEnd int) (string, *testError) {
 
 	conn, err := proxyListener.Accept()
 	if err != nil {
-		return "", &testError{err, "Can't accept connection on listener"}
+		return proxyProtoResult{}, &testError{err, "Can't accept connection on listener"}
 	}
 	defer conn.Close()
-	return getIpFromAddr(conn.RemoteAddr()), nil
+
+	return proxyProtoResult{
+		ip:     getIpFromAddr(conn.RemoteAddr()),
+		header: conn.(*proxyproto.Conn).ProxyHeader(),
+	}, nil
 }
 
 func TestStatusRequest(t *testing.T) {
@@ -376,10 +408,16 @@ func TestProxyProtocol(t *testing.T) {
 	tt := []struct {
 		name              string
 		proxyproto        bool
+		version           int
+		tlvs              ProxyProtocolTLVConfig
 		receiveProxyproto bool
+		sourceIp          string
 		portEnd           int
 		shouldMatch       bool
 		expectingIp       string
+		expectingVersion  byte
+		expectingProtocol proxyproto.AddressFamilyAndProtocol
+		expectingTLVs     []proxyproto.PP2Type
 	}{
 		{
 			name:              "ProxyProtocolOn",
@@ -388,6 +426,8 @@ func TestProxyProtocol(t *testing.T) {
 			portEnd:           581,
 			shouldMatch:       true,
 			expectingIp:       "127.0.0.1",
+			expectingVersion:  2,
+			expectingProtocol: proxyproto.TCPv4,
 		},
 		{
 			name:              "ProxyProtocolOff",
@@ -404,18 +444,87 @@ func TestProxyProtocol(t *testing.T) {
 			portEnd:           583,
 			shouldMatch:       true,
 			expectingIp:       "109.226.143.210",
+			expectingVersion:  2,
+			expectingProtocol: proxyproto.TCPv4,
+		},
+		{
+			name:              "ProxyProtocol Version 1",
+			proxyproto:        true,
+			version:           1,
+			receiveProxyproto: false,
+			portEnd:           584,
+			shouldMatch:       true,
+			expectingIp:       "127.0.0.1",
+			expectingVersion:  1,
+			expectingProtocol: proxyproto.TCPv4,
+		},
+		{
+			name:              "ProxyProtocol Receive IPv6",
+			proxyproto:        true,
+			version:           2,
+			receiveProxyproto: true,
+			sourceIp:          "2001:db8::1",
+			portEnd:           585,
+			shouldMatch:       true,
+			expectingIp:       "2001:db8::1",
+			expectingVersion:  2,
+			expectingProtocol: proxyproto.TCPv6,
+		},
+		{
+			name:              "ProxyProtocol Version 1 Receive IPv6",
+			proxyproto:        true,
+			version:           1,
+			receiveProxyproto: true,
+			sourceIp:          "2001:db8::1",
+			portEnd:           586,
+			shouldMatch:       true,
+			expectingIp:       "2001:db8::1",
+			expectingVersion:  1,
+			expectingProtocol: proxyproto.TCPv6,
+		},
+		{
+			name:       "ProxyProtocol TLVs",
+			proxyproto: true,
+			version:    2,
+			tlvs: ProxyProtocolTLVConfig{
+				Authority: true,
+				UniqueID:  true,
+			},
+			receiveProxyproto: false,
+			portEnd:           587,
+			shouldMatch:       true,
+			expectingIp:       "127.0.0.1",
+			expectingVersion:  2,
+			expectingProtocol: proxyproto.TCPv4,
+			expectingTLVs:     []proxyproto.PP2Type{proxyproto.PP2_TYPE_AUTHORITY, proxyproto.PP2_TYPE_UNIQUE_ID},
+		},
+		{
+			name:       "ProxyProtocol Version 1 Ignores TLVs",
+			proxyproto: true,
+			version:    1,
+			tlvs: ProxyProtocolTLVConfig{
+				Authority: true,
+			},
+			receiveProxyproto: false,
+			portEnd:           588,
+			shouldMatch:       true,
+			expectingIp:       "127.0.0.1",
+			expectingVersion:  1,
+			expectingProtocol: proxyproto.TCPv4,
 		},
 	}
 
 	for _, tc := range tt {
 		t.Run(tc.name, func(t *testing.T) {
 			errorCh := make(chan *testError)
-			resultCh := make(chan bool)
+			resultCh := make(chan proxyProtoResult)
 			wg := &sync.WaitGroup{}
 
 			wg.Add(1)
 			go func(wg *sync.WaitGroup) {
 				config := createProxyProtocolConfig(tc.portEnd, tc.proxyproto)
+				config.ProxyProtocolVersion = tc.version
+				config.ProxyProtocolTLVs = tc.tlvs
 				gateway := Gateway{
 					ReceiveProxyProtocol: tc.receiveProxyproto,
 				}
@@ -428,12 +537,12 @@ func TestProxyProtocol(t *testing.T) {
 			}(wg)
 
 			go func() {
-				ip, err := proxyProtoListen(tc.portEnd)
+				result, err := proxyProtoListen(tc.portEnd)
 				if err != nil {
 					errorCh <- err
 					return
 				}
-				resultCh <- ip == tc.expectingIp
+				resultCh <- result
 			}()
 			wg.Wait()
 			go func() {
@@ -445,6 +554,7 @@ func TestProxyProtocol(t *testing.T) {
 					dialerPort:              dialerPort(tc.portEnd),
 					useProxyProtocol:        tc.proxyproto,
 					sendProxyProtocolHeader: tc.receiveProxyproto,
+					proxyProtocolSourceIP:   tc.sourceIp,
 				}
 
 				_, err := statusDial(config)
@@ -457,8 +567,46 @@ func TestProxyProtocol(t *testing.T) {
 			case err := <-errorCh:
 				t.Fatalf("Unexpected Error in test: %s\n%v", err.Message, err.Error)
 			case r := <-resultCh:
-				if r != tc.shouldMatch {
-					t.Errorf("got: %v; want: %v", r, tc.shouldMatch)
+				if (r.ip == tc.expectingIp) != tc.shouldMatch {
+					t.Errorf("got: %v; want: %v", r.ip, tc.expectingIp)
+				}
+
+				if !tc.proxyproto {
+					if r.header != nil {
+						t.Errorf("got header: %v; want: none", r.header)
+					}
+					return
+				}
+
+				if r.header == nil {
+					t.Fatal("got no header")
+				}
+
+				if r.header.Version != tc.expectingVersion {
+					t.Errorf("got version: %d; want: %d", r.header.Version, tc.expectingVersion)
+				}
+
+				if r.header.TransportProtocol != tc.expectingProtocol {
+					t.Errorf("got transport protocol: %v; want: %v", r.header.TransportProtocol, tc.expectingProtocol)
+				}
+
+				tlvs, err := r.header.TLVs()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if len(tlvs) != len(tc.expectingTLVs) {
+					t.Fatalf("got %d TLVs; want: %d", len(tlvs), len(tc.expectingTLVs))
+				}
+
+				for i, tlv := range tlvs {
+					if tlv.Type != tc.expectingTLVs[i] {
+						t.Errorf("got TLV type: %v; want: %v", tlv.Type, tc.expectingTLVs[i])
+					}
+
+					if tlv.Type == proxyproto.PP2_TYPE_AUTHORITY && string(tlv.Value) != serverDomain {
+						t.Errorf("got authority: %s; want: %s", tlv.Value, serverDomain)
+					}
 				}
 			}
 
@@ -633,3 +781,880 @@ func TestRouting(t *testing.T) {
 func TestProxyBind(t *testing.T) {
 	// TODO: Figure out a way to test this
 }
+
+func TestAccessList(t *testing.T) {
+	tt := []struct {
+		name            string
+		portEnd         int
+		globalList      AccessListConfig
+		proxyList       AccessListConfig
+		expectedVersion string
+		expectError     bool
+	}{
+		{
+			name:            "Allowed",
+			portEnd:         590,
+			proxyList:       AccessListConfig{Allow: []string{"127.0.0.0/8"}},
+			expectedVersion: onlineStatus.VersionName,
+		},
+		{
+			name:        "DeniedByGateway",
+			portEnd:     591,
+			globalList:  AccessListConfig{Deny: []string{"127.0.0.0/8"}},
+			expectError: true,
+		},
+		{
+			name:        "DeniedByProxy",
+			portEnd:     592,
+			proxyList:   AccessListConfig{Allow: []string{"10.0.0.0/8"}},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			config := proxyConfigWithPortEnd(tc.portEnd)
+			config.OfflineStatus = onlineStatus
+			config.AccessList = tc.proxyList
+
+			accessList, err := NewAccessList(tc.globalList)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gateway := Gateway{AccessList: accessList}
+			if err := gateway.ListenAndServe(configToProxies(config)); err != nil {
+				t.Fatal(err)
+			}
+			defer gateway.Close()
+
+			receivedVersion, testErr := statusDial(statusDialConfig{
+				pk:          statusHandshakePort(tc.portEnd),
+				gatewayAddr: gatewayAddr(tc.portEnd),
+				dialerPort:  dialerPort(tc.portEnd),
+			})
+
+			if tc.expectError {
+				if testErr == nil {
+					t.Errorf("expected denied connection; got version %q", receivedVersion)
+				}
+				return
+			}
+
+			if testErr != nil {
+				t.Fatalf("Unexpected Error in test: %s\n%v", testErr.Message, testErr.Error)
+			}
+
+			if receivedVersion != tc.expectedVersion {
+				t.Errorf("got: %v; want: %v", receivedVersion, tc.expectedVersion)
+			}
+		})
+	}
+}
+
+func loginHandshakePort(portEnd int) protocol.Packet {
+	hs := handshaking.ServerBoundHandshake{
+		ProtocolVersion: 574,
+		ServerAddress:   protocol.String(serverDomain),
+		ServerPort:      protocol.UnsignedShort(gatewayPort(portEnd)),
+		NextState:       2,
+	}
+	return hs.Marshal()
+}
+
+func loginDial(gatewayAddr, username string) (string, *testError) {
+	conn, err := Dialer{}.Dial(gatewayAddr)
+	if err != nil {
+		return "", &testError{err, "Can't make a connection with gateway"}
+	}
+	defer conn.Close()
+
+	pk := loginHandshakePort(0)
+	if err := sendHandshake(conn, pk); err != nil {
+		return "", err
+	}
+
+	loginStartPk := protocol.MarshalPacket(login.ServerBoundLoginStartPacketID, protocol.String(username))
+	if err := conn.WritePacket(loginStartPk); err != nil {
+		return "", &testError{err, "Can't write login start packet"}
+	}
+
+	receivedPk, err := conn.ReadPacket()
+	if err != nil {
+		return "", &testError{err, "Can't read disconnect packet"}
+	}
+
+	var reason protocol.Chat
+	if err := receivedPk.Scan(&reason); err != nil {
+		return "", &testError{err, "Can't unmarshal disconnect packet"}
+	}
+	return string(reason), nil
+}
+
+func TestPlayerLists(t *testing.T) {
+	tt := []struct {
+		name           string
+		portEnd        int
+		playerLists    PlayerListsConfig
+		username       string
+		expectedReason string
+	}{
+		{
+			name:           "Allowed",
+			portEnd:        593,
+			playerLists:    PlayerListsConfig{Whitelist: []PlayerListEntry{{Name: "Steve"}}},
+			username:       "Steve",
+			expectedReason: `{"text":"offline"}`,
+		},
+		{
+			name:           "NotWhitelisted",
+			portEnd:        594,
+			playerLists:    PlayerListsConfig{Whitelist: []PlayerListEntry{{Name: "Alex"}}},
+			username:       "Steve",
+			expectedReason: `{"text":"You are not white-listed on this server!"}`,
+		},
+		{
+			name:    "Banned",
+			portEnd: 595,
+			playerLists: PlayerListsConfig{
+				BannedPlayers: []PlayerListEntry{{Name: "Steve", Reason: "Griefing"}},
+				BanMessage:    "{{username}} is banned: {{reason}} until {{expires}}",
+			},
+			username:       "Steve",
+			expectedReason: `{"text":"Steve is banned: Griefing until forever"}`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			config := proxyConfigWithPortEnd(tc.portEnd)
+			config.DisconnectMessage = "offline"
+			config.PlayerLists = tc.playerLists
+
+			gateway := Gateway{}
+			if err := gateway.ListenAndServe(configToProxies(config)); err != nil {
+				t.Fatal(err)
+			}
+			defer gateway.Close()
+
+			reason, testErr := loginDial(gatewayAddr(tc.portEnd), tc.username)
+			if testErr != nil {
+				t.Fatalf("Unexpected Error in test: %s\n%v", testErr.Message, testErr.Error)
+			}
+
+			if reason != tc.expectedReason {
+				t.Errorf("got: %v; want: %v", reason, tc.expectedReason)
+			}
+		})
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	tt := []struct {
+		name          string
+		portEnd       int
+		sendHandshake bool
+	}{
+		{
+			name:          "Handshake",
+			portEnd:       596,
+			sendHandshake: false,
+		},
+		{
+			name:          "Login",
+			portEnd:       597,
+			sendHandshake: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			gateway := Gateway{
+				Timeouts: TimeoutConfig{
+					Handshake: 100 * time.Millisecond,
+					Login:     100 * time.Millisecond,
+				},
+			}
+			if err := gateway.ListenAndServe(configToProxies(proxyConfigWithPortEnd(tc.portEnd))); err != nil {
+				t.Fatal(err)
+			}
+			defer gateway.Close()
+
+			conn, err := Dialer{}.Dial(gatewayAddr(tc.portEnd))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			if tc.sendHandshake {
+				if err := sendHandshake(conn, loginHandshakePort(0)); err != nil {
+					t.Fatal(err.Error)
+				}
+			}
+
+			if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+				t.Errorf("got: %v; want: %v", err, io.EOF)
+			}
+		})
+	}
+}
+
+func TestRateLimit_IdleConnections(t *testing.T) {
+	portEnd := 606
+	gateway := Gateway{
+		Timeouts:  TimeoutConfig{Handshake: 5 * time.Second},
+		RateLimit: ListenerRateLimitConfig{MaxConcurrentPerIP: 1},
+	}
+	if err := gateway.ListenAndServe(configToProxies(proxyConfigWithPortEnd(portEnd))); err != nil {
+		t.Fatal(err)
+	}
+	defer gateway.Close()
+
+	// The first connection never sends a handshake but still takes the only slot
+	idleConn, err := Dialer{}.Dial(gatewayAddr(portEnd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idleConn.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	conn, err := Dialer{}.Dial(gatewayAddr(portEnd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("got: %v; want: %v", err, io.EOF)
+	}
+}
+
+func pingDial(gatewayAddr string, portEnd int) (string, *testError) {
+	conn, err := Dialer{}.Dial(gatewayAddr)
+	if err != nil {
+		return "", &testError{err, "Can't make a connection with gateway"}
+	}
+	defer conn.Close()
+
+	if err := sendHandshake(conn, serverHandshake(serverDomain, gatewayPort(portEnd))); err != nil {
+		return "", err
+	}
+
+	if err := conn.WritePacket(status.ServerBoundRequest{}.Marshal()); err != nil {
+		return "", &testError{err, "Can't write status request packet"}
+	}
+
+	receivedPk, err := conn.ReadPacket()
+	if err != nil {
+		return "", &testError{err, "Can't read status reponse packet"}
+	}
+
+	response, err := status.UnmarshalClientBoundResponse(receivedPk)
+	if err != nil {
+		return "", &testError{err, "Can't unmarshal status reponse packet"}
+	}
+
+	pingPk := protocol.MarshalPacket(0x01, protocol.Long(42))
+	if err := conn.WritePacket(pingPk); err != nil {
+		return "", &testError{err, "Can't write ping packet"}
+	}
+
+	if _, err := conn.ReadPacket(); err != nil {
+		return "", &testError{err, "Can't read pong packet"}
+	}
+
+	res := &status.ResponseJSON{}
+	json.Unmarshal([]byte(response.JSONResponse), &res)
+	return res.Version.Name, nil
+}
+
+func TestAttackMode(t *testing.T) {
+	portEnd := 598
+	errorCh := make(chan *testError)
+	statusListen(statusListenerConfig{
+		addr:   serverAddr(portEnd),
+		status: statusPKWithVersion(serverVersionName),
+	}, errorCh)
+
+	gateway := Gateway{
+		AttackMode: AttackModeConfig{Threshold: 1},
+	}
+	if err := gateway.ListenAndServe(configToProxies(proxyConfigWithPortEnd(portEnd))); err != nil {
+		t.Fatal(err)
+	}
+	defer gateway.Close()
+
+	// The first connection stays below the threshold
+	conn, err := Dialer{}.Dial(gatewayAddr(portEnd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	reason, testErr := loginDial(gatewayAddr(portEnd), "Steve")
+	if testErr != nil {
+		t.Fatalf("Unexpected Error in test: %s\n%v", testErr.Message, testErr.Error)
+	}
+
+	expectedReason := fmt.Sprintf(`{"text":"%s"}`, DefaultAttackModeMessage)
+	if reason != expectedReason {
+		t.Fatalf("got: %v; want: %v", reason, expectedReason)
+	}
+
+	versionName, testErr := pingDial(gatewayAddr(portEnd), portEnd)
+	if testErr != nil {
+		t.Fatalf("Unexpected Error in test: %s\n%v", testErr.Message, testErr.Error)
+	}
+
+	if versionName != serverVersionName {
+		t.Fatalf("got: %v; want: %v", versionName, serverVersionName)
+	}
+
+	reason, testErr = loginDial(gatewayAddr(portEnd), "Steve")
+	if testErr != nil {
+		t.Fatalf("Unexpected Error in test: %s\n%v", testErr.Message, testErr.Error)
+	}
+
+	if reason == expectedReason {
+		t.Error("login after ping should not be denied")
+	}
+
+	select {
+	case err := <-errorCh:
+		t.Fatalf("Unexpected Error in test: %s\n%v", err.Message, err.Error)
+	default:
+	}
+}
+
+func TestCountries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	writeTestMMDB(t, path, "81.2.69.0/24", map[string]interface{}{
+		"country": map[string]interface{}{"iso_code": "GB"},
+	})
+
+	geoIP, err := LoadGeoIPFromPaths([]string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name           string
+		portEnd        int
+		allowCountries []string
+		denyCountries  []string
+		expectError    bool
+	}{
+		{
+			name:           "Allowed",
+			portEnd:        600,
+			allowCountries: []string{"GB"},
+		},
+		{
+			name:          "Denied",
+			portEnd:       601,
+			denyCountries: []string{"GB"},
+			expectError:   true,
+		},
+		{
+			name:           "NotAllowed",
+			portEnd:        602,
+			allowCountries: []string{"DE"},
+			expectError:    true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			config := proxyConfigWithPortEnd(tc.portEnd)
+			config.OfflineStatus = onlineStatus
+			config.AllowCountries = tc.allowCountries
+			config.DenyCountries = tc.denyCountries
+
+			gateway := Gateway{
+				ReceiveProxyProtocol: true,
+				GeoIP:                geoIP,
+			}
+			if err := gateway.ListenAndServe(configToProxies(config)); err != nil {
+				t.Fatal(err)
+			}
+			defer gateway.Close()
+
+			receivedVersion, testErr := statusDial(statusDialConfig{
+				pk:                      statusHandshakePort(tc.portEnd),
+				gatewayAddr:             gatewayAddr(tc.portEnd),
+				dialerPort:              dialerPort(tc.portEnd),
+				sendProxyProtocolHeader: true,
+				proxyProtocolSourceIP:   "81.2.69.142",
+			})
+
+			if tc.expectError {
+				if testErr == nil {
+					t.Errorf("expected denied connection; got version %q", receivedVersion)
+				}
+				return
+			}
+
+			if testErr != nil {
+				t.Fatalf("Unexpected Error in test: %s\n%v", testErr.Message, testErr.Error)
+			}
+
+			if receivedVersion != onlineStatus.VersionName {
+				t.Errorf("got: %v; want: %v", receivedVersion, onlineStatus.VersionName)
+			}
+		})
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	portEnd := 603
+	config := proxyConfigWithPortEnd(portEnd)
+	config.OfflineStatus = offlineStatus
+	config.DisconnectMessage = "offline"
+	config.Maintenance = MaintenanceConfig{
+		Enabled:         true,
+		Message:         "Sorry {{username}}, we are back at {{end}}",
+		BypassUsernames: []string{"Notch"},
+	}
+
+	gateway := Gateway{}
+	if err := gateway.ListenAndServe(configToProxies(config)); err != nil {
+		t.Fatal(err)
+	}
+	defer gateway.Close()
+
+	versionName, testErr := pingDial(gatewayAddr(portEnd), portEnd)
+	if testErr != nil {
+		t.Fatalf("Unexpected Error in test: %s\n%v", testErr.Message, testErr.Error)
+	}
+
+	if versionName != DefaultMaintenanceVersionName {
+		t.Errorf("got: %v; want: %v", versionName, DefaultMaintenanceVersionName)
+	}
+
+	reason, testErr := loginDial(gatewayAddr(portEnd), "Steve")
+	if testErr != nil {
+		t.Fatalf("Unexpected Error in test: %s\n%v", testErr.Message, testErr.Error)
+	}
+
+	expectedReason := `{"text":"Sorry Steve, we are back at further notice"}`
+	if reason != expectedReason {
+		t.Errorf("got: %v; want: %v", reason, expectedReason)
+	}
+
+	reason, testErr = loginDial(gatewayAddr(portEnd), "Notch")
+	if testErr != nil {
+		t.Fatalf("Unexpected Error in test: %s\n%v", testErr.Message, testErr.Error)
+	}
+
+	if reason != `{"text":"offline"}` {
+		t.Errorf("got: %v; want: %v", reason, `{"text":"offline"}`)
+	}
+}
+
+func TestMaxConnections(t *testing.T) {
+	portEnd := 604
+
+	// The server accepts logins and keeps them open
+	listener, err := Listen(serverAddr(portEnd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+
+	config := proxyConfigWithPortEnd(portEnd)
+	config.OnlineStatus = onlineStatus
+	config.MaxConnections = 1
+	config.ServerFullMessage = "{{players}}/{{maxConnections}}"
+	config.PriorityPlayers = []string{"Notch"}
+	proxies := configToProxies(config)
+
+	gateway := Gateway{}
+	if err := gateway.ListenAndServe(proxies); err != nil {
+		t.Fatal(err)
+	}
+	defer gateway.Close()
+
+	conn, err := Dialer{}.Dial(gatewayAddr(portEnd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := sendHandshake(conn, loginHandshakePort(0)); err != nil {
+		t.Fatal(err.Error)
+	}
+
+	if err := conn.WritePacket(protocol.MarshalPacket(login.ServerBoundLoginStartPacketID, protocol.String("Steve"))); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for proxies[0].PlayerCount() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("player did not connect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	reason, testErr := loginDial(gatewayAddr(portEnd), "Alex")
+	if testErr != nil {
+		t.Fatalf("Unexpected Error in test: %s\n%v", testErr.Message, testErr.Error)
+	}
+
+	if reason != `{"text":"1/1"}` {
+		t.Errorf("got: %v; want: %v", reason, `{"text":"1/1"}`)
+	}
+
+	statusPk, err := proxies[0].OnlineStatusPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := status.UnmarshalClientBoundResponse(statusPk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := status.ResponseJSON{}
+	if err := json.Unmarshal([]byte(response.JSONResponse), &res); err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Players.Online != 1 || res.Players.Max != 1 {
+		t.Errorf("got: %d/%d; want: 1/1", res.Players.Online, res.Players.Max)
+	}
+
+	priorityConn, err := Dialer{}.Dial(gatewayAddr(portEnd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer priorityConn.Close()
+
+	if err := sendHandshake(priorityConn, loginHandshakePort(0)); err != nil {
+		t.Fatal(err.Error)
+	}
+
+	if err := priorityConn.WritePacket(protocol.MarshalPacket(login.ServerBoundLoginStartPacketID, protocol.String("Notch"))); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline = time.Now().Add(time.Second)
+	for proxies[0].PlayerCount() != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("priority player did not connect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestListenerSlots(t *testing.T) {
+	slots := listenerSlots{counter: &connCounter{}, addr: ":25565", max: 1}
+
+	if !slots.acquire(false) {
+		t.Fatal("first player should get a slot")
+	}
+
+	if slots.acquire(false) {
+		t.Error("player above max should not get a slot")
+	}
+
+	if !slots.acquire(true) {
+		t.Error("priority player should get a slot")
+	}
+
+	slots.release()
+	slots.release()
+	if !slots.acquire(false) {
+		t.Error("released slots should be free again")
+	}
+
+	if !(listenerSlots{}).acquire(false) {
+		t.Error("slots without counter should not limit")
+	}
+}
+
+func TestGateway_ListenerConfig(t *testing.T) {
+	_, tcpShield, _ := net.ParseCIDR("192.0.2.0/24")
+	gateway := Gateway{
+		RateLimit: ListenerRateLimitConfig{
+			Handshake: HandshakeRateLimitConfig{Status: RateLimit{Rate: 5, Burst: 5}},
+		},
+		Listeners: map[string]ListenerConfig{
+			":25566": {
+				ReceiveRealIP: true,
+				RealIP:        RealIPConfig{TrustedCIDRs: []*net.IPNet{tcpShield}},
+				RateLimit: ListenerRateLimitConfig{
+					Handshake: HandshakeRateLimitConfig{Status: RateLimit{Rate: 50, Burst: 50}},
+				},
+			},
+		},
+	}
+
+	if cfg := gateway.listenerConfig(":25565"); cfg.ReceiveRealIP {
+		t.Error("the public listener should use the settings of the gateway")
+	}
+
+	if cfg := gateway.listenerConfig(":25566"); !cfg.ReceiveRealIP || len(cfg.RealIP.TrustedCIDRs) != 1 {
+		t.Errorf("got: %+v; want: the settings of the listener", cfg)
+	}
+
+	if limits := gateway.rateLimits(":25565", nil); limits.Handshake.Status.Rate != 5 {
+		t.Errorf("got: %v; want: the status limit of the gateway", limits.Handshake.Status)
+	}
+
+	if limits := gateway.rateLimits(":25566", nil); limits.Handshake.Status.Rate != 50 {
+		t.Errorf("got: %v; want: the status limit of the listener", limits.Handshake.Status)
+	}
+}
+
+func TestProxy_LiveStatusPacket(t *testing.T) {
+	iconPath := filepath.Join(t.TempDir(), "icon.png")
+	if err := ioutil.WriteFile(iconPath, []byte("icon"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := proxyConfigWithPortEnd(0)
+	config.OnlineStatus = onlineStatus
+	config.OnlineStatus.IconPath = iconPath
+	config.MaxConnections = 10
+	proxy := &Proxy{Config: config}
+
+	if _, err := proxy.OnlineStatusPacket(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The icon is only read once, only the player count changes
+	if err := os.Remove(iconPath); err != nil {
+		t.Fatal(err)
+	}
+	proxy.reservePlayer(nil, "Steve", 0)
+
+	statusPk, err := proxy.OnlineStatusPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := status.UnmarshalClientBoundResponse(statusPk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := status.ResponseJSON{}
+	if err := json.Unmarshal([]byte(response.JSONResponse), &res); err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Players.Online != 1 || res.Players.Max != 10 || res.Favicon == "" {
+		t.Errorf("got: %d/%d with favicon %q; want: 1/10 with favicon", res.Players.Online, res.Players.Max, res.Favicon)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	portEnd := 605
+	config := proxyConfigWithPortEnd(portEnd)
+	config.Maintenance = MaintenanceConfig{Enabled: true}
+
+	auditLogPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	auditLog, err := OpenAuditLog(AuditLogConfig{Path: auditLogPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+
+	gateway := Gateway{AuditLog: auditLog}
+	if err := gateway.ListenAndServe(configToProxies(config)); err != nil {
+		t.Fatal(err)
+	}
+	defer gateway.Close()
+
+	if _, testErr := loginDial(gatewayAddr(portEnd), "Steve"); testErr != nil {
+		t.Fatalf("Unexpected Error in test: %s\n%v", testErr.Message, testErr.Error)
+	}
+
+	// The entry is written after the gateway closed the connection
+	var bb []byte
+	for i := 0; i < 50 && len(bb) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		if bb, err = ioutil.ReadFile(auditLogPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var entry AuditEntry
+	if err := json.Unmarshal(bb, &entry); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := AuditEntry{
+		Listener:        gatewayAddr(portEnd),
+		ClientIP:        "127.0.0.1",
+		Hostname:        serverDomain,
+		ProtocolVersion: 574,
+		NextState:       "login",
+		Username:        "Steve",
+		ProxyUID:        proxyUID(serverDomain, gatewayAddr(portEnd)),
+		Outcome:         AuditOutcomeDenied,
+		Error:           ErrMaintenance.Error(),
+	}
+	actual := entry
+	actual.Start, actual.End, actual.DurationMs = time.Time{}, time.Time{}, 0
+	if actual != expected {
+		t.Errorf("got: %+v; want: %+v", actual, expected)
+	}
+}
+
+func TestAuditLog_BytesPiped(t *testing.T) {
+	portEnd := 607
+
+	// The server disconnects every player right after the login start
+	disconnectPk := disconnectPacket("bye")
+	disconnectFrame, _ := disconnectPk.Marshal()
+	listener, err := Listen(serverAddr(portEnd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for i := 0; i < 2; i++ {
+			if _, err := conn.ReadPacket(); err != nil {
+				return
+			}
+		}
+		conn.Write(disconnectFrame)
+	}()
+
+	auditLogPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	auditLog, err := OpenAuditLog(AuditLogConfig{Path: auditLogPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+
+	gateway := Gateway{AuditLog: auditLog}
+	if err := gateway.ListenAndServe(configToProxies(proxyConfigWithPortEnd(portEnd))); err != nil {
+		t.Fatal(err)
+	}
+	defer gateway.Close()
+
+	if _, testErr := loginDial(gatewayAddr(portEnd), "Steve"); testErr != nil {
+		t.Fatalf("Unexpected Error in test: %s\n%v", testErr.Message, testErr.Error)
+	}
+
+	var bb []byte
+	for i := 0; i < 50 && len(bb) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		if bb, err = ioutil.ReadFile(auditLogPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var entry AuditEntry
+	if err := json.Unmarshal(bb, &entry); err != nil {
+		t.Fatal(err)
+	}
+
+	if entry.BytesOut != int64(len(disconnectFrame)) {
+		t.Errorf("got: %d; want: %d", entry.BytesOut, len(disconnectFrame))
+	}
+}
+
+func TestGateway_HandleConfigEvents(t *testing.T) {
+	portEnd := 980
+	gateway := Gateway{}
+	events := make(chan ConfigEvent)
+	done := make(chan bool)
+	go func() {
+		gateway.HandleConfigEvents(events)
+		done <- true
+	}()
+
+	isRegistered := func(uid string) bool {
+		_, ok := gateway.Proxies.Load(uid)
+		return ok
+	}
+
+	config := proxyConfigWithPortEnd(portEnd)
+	oldUID := proxyUID(config.DomainName, config.ListenTo)
+	events <- ConfigEvent{Type: ConfigAdded, ID: "proxy.yml", Config: config}
+
+	// Changes of the domain register the proxy with its new UID
+	config.Lock()
+	config.DomainName = "new.example.com"
+	config.Unlock()
+	newUID := proxyUID(config.DomainName, config.ListenTo)
+	events <- ConfigEvent{Type: ConfigUpdated, ID: "proxy.yml", Config: config}
+	events <- ConfigEvent{Type: ConfigRemoved, ID: "unknown.yml"}
+	close(events)
+	<-done
+
+	if isRegistered(oldUID) || !isRegistered(newUID) {
+		t.Errorf("expected proxy to be registered as %s only", newUID)
+	}
+
+	// A new run does not know the proxies of the previous run
+	gateway.CloseProxy(newUID)
+	events = make(chan ConfigEvent)
+	go func() {
+		gateway.HandleConfigEvents(events)
+		done <- true
+	}()
+	events <- ConfigEvent{Type: ConfigAdded, ID: "proxy.yml", Config: config}
+	events <- ConfigEvent{Type: ConfigRemoved, ID: "proxy.yml", Config: config}
+	close(events)
+	<-done
+
+	if isRegistered(newUID) {
+		t.Errorf("expected proxy %s to be closed", newUID)
+	}
+}
+
+func TestGateway_HandleConfigEvents_DuplicateUID(t *testing.T) {
+	portEnd := 981
+	gateway := Gateway{}
+	events := make(chan ConfigEvent)
+	done := make(chan bool)
+	go func() {
+		gateway.HandleConfigEvents(events)
+		done <- true
+	}()
+
+	config := proxyConfigWithPortEnd(portEnd)
+	duplicate := proxyConfigWithPortEnd(portEnd)
+	uid := proxyUID(config.DomainName, config.ListenTo)
+	events <- ConfigEvent{Type: ConfigAdded, ID: "proxy.yml", Config: config}
+	events <- ConfigEvent{Type: ConfigAdded, ID: "duplicate.yml", Config: duplicate}
+	events <- ConfigEvent{Type: ConfigRemoved, ID: "duplicate.yml", Config: duplicate}
+	close(events)
+	<-done
+
+	v, ok := gateway.Proxies.Load(uid)
+	if !ok || v.(*Proxy).Config != config {
+		t.Errorf("expected proxy %s to stay registered by proxy.yml", uid)
+	}
+	gateway.CloseProxy(uid)
+}
@@ -0,0 +1,221 @@
+package infrared
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	playersConnectedGeo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "infrared_connected_geo",
+		Help: "The total number of connected players by country and ASN",
+	}, []string{"host", "country", "asn"})
+)
+
+var ErrCountryDenied = errors.New("country denied")
+
+// GeoIPLocation is the location of an IP as far as the databases know it
+type GeoIPLocation struct {
+	// Country is the ISO 3166-1 alpha-2 code of the country
+	Country string
+	// ASN is the number of the autonomous system
+	ASN uint
+	// ASOrganization is the organization of the autonomous system
+	ASOrganization string
+}
+
+func (location GeoIPLocation) asnLabel() string {
+	if location.ASN == 0 {
+		return ""
+	}
+	return strconv.FormatUint(uint64(location.ASN), 10)
+}
+
+func geoLabels(host string, location GeoIPLocation) prometheus.Labels {
+	return prometheus.Labels{
+		"host":    host,
+		"country": location.Country,
+		"asn":     location.asnLabel(),
+	}
+}
+
+// geoIPRecord holds the fields of the GeoIP2/GeoLite2 Country, City and ASN databases
+type geoIPRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+	ASN            uint   `maxminddb:"autonomous_system_number"`
+	ASOrganization string `maxminddb:"autonomous_system_organization"`
+}
+
+// GeoIP looks up IPs in one or more MaxMind DB files.
+// The results of all databases are merged; earlier databases take precedence.
+type GeoIP struct {
+	mu      sync.RWMutex
+	paths   []string
+	readers []*maxminddb.Reader
+}
+
+// LoadGeoIPFromPaths loads the databases and then starts watching them for changes.
+// On change the database is reloaded automatically.
+func LoadGeoIPFromPaths(paths []string) (*GeoIP, error) {
+	geoIP := GeoIP{
+		paths:   paths,
+		readers: make([]*maxminddb.Reader, len(paths)),
+	}
+
+	for i, path := range paths {
+		log.Println("Loading GeoIP database", path)
+		if err := geoIP.load(i); err != nil {
+			return nil, err
+		}
+
+		if err := geoIP.watch(i); err != nil {
+			return nil, err
+		}
+	}
+
+	return &geoIP, nil
+}
+
+func (geoIP *GeoIP) load(i int) error {
+	bb, err := ioutil.ReadFile(geoIP.paths[i])
+	if err != nil {
+		return err
+	}
+
+	reader, err := maxminddb.FromBytes(bb)
+	if err != nil {
+		return fmt.Errorf("invalid GeoIP database %s; %s", geoIP.paths[i], err)
+	}
+
+	geoIP.mu.Lock()
+	defer geoIP.mu.Unlock()
+	geoIP.readers[i] = reader
+	return nil
+}
+
+// watch watches the directory of the database, because
+// updates usually replace the file instead of writing to it
+func (geoIP *GeoIP) watch(i int) error {
+	path := filepath.Clean(geoIP.paths[i])
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+		log.Printf("Starting to watch %s", path)
+
+		// The interval protects the watcher from write event spams
+		tick := time.Tick(time.Millisecond * 50)
+		changed := false
+
+		for {
+			select {
+			case <-tick:
+				if !changed {
+					continue
+				}
+				changed = false
+
+				log.Println("Updating GeoIP database", path)
+				if err := geoIP.load(i); err != nil {
+					log.Printf("Failed update on %s; error %s", path, err)
+				}
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != path {
+					continue
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+					changed = true
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Failed watching %s; error %s", path, err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Lookup returns the location of the IP. A nil GeoIP returns an empty location.
+func (geoIP *GeoIP) Lookup(ip net.IP) (GeoIPLocation, error) {
+	var location GeoIPLocation
+	if geoIP == nil || ip == nil {
+		return location, nil
+	}
+
+	geoIP.mu.RLock()
+	defer geoIP.mu.RUnlock()
+
+	for _, reader := range geoIP.readers {
+		var record geoIPRecord
+		if err := reader.Lookup(ip, &record); err != nil {
+			return location, err
+		}
+
+		if location.Country == "" {
+			location.Country = record.Country.ISOCode
+		}
+		if location.Country == "" {
+			location.Country = record.RegisteredCountry.ISOCode
+		}
+		if location.ASN == 0 {
+			location.ASN = record.ASN
+			location.ASOrganization = record.ASOrganization
+		}
+	}
+
+	return location, nil
+}
+
+// isCountryAllowed checks the country against the allow and deny lists.
+// Unknown countries are only denied by an allow list.
+func isCountryAllowed(country string, allow, deny []string) bool {
+	for _, denied := range deny {
+		if country != "" && strings.EqualFold(denied, country) {
+			return false
+		}
+	}
+
+	if len(allow) == 0 {
+		return true
+	}
+
+	for _, allowed := range allow {
+		if strings.EqualFold(allowed, country) {
+			return true
+		}
+	}
+	return false
+}
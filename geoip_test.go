@@ -0,0 +1,246 @@
+package infrared
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// encodeMMDBValue encodes a value in the MaxMind DB data format
+func encodeMMDBValue(v interface{}) []byte {
+	control := func(typ byte, size int) []byte {
+		var extended []byte
+		if size >= 29 {
+			extended = []byte{byte(size - 29)}
+			size = 29
+		}
+
+		if typ <= 7 {
+			return append([]byte{typ<<5 | byte(size)}, extended...)
+		}
+		return append([]byte{byte(size), typ - 7}, extended...)
+	}
+
+	uintBytes := func(n uint64) []byte {
+		bb := make([]byte, 8)
+		binary.BigEndian.PutUint64(bb, n)
+		return bytes.TrimLeft(bb, "\x00")
+	}
+
+	switch v := v.(type) {
+	case string:
+		return append(control(2, len(v)), v...)
+	case uint16:
+		bb := uintBytes(uint64(v))
+		return append(control(5, len(bb)), bb...)
+	case uint32:
+		bb := uintBytes(uint64(v))
+		return append(control(6, len(bb)), bb...)
+	case uint64:
+		bb := uintBytes(v)
+		return append(control(9, len(bb)), bb...)
+	case []interface{}:
+		bb := control(11, len(v))
+		for _, value := range v {
+			bb = append(bb, encodeMMDBValue(value)...)
+		}
+		return bb
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		bb := control(7, len(v))
+		for _, key := range keys {
+			bb = append(bb, encodeMMDBValue(key)...)
+			bb = append(bb, encodeMMDBValue(v[key])...)
+		}
+		return bb
+	}
+	panic("unsupported type")
+}
+
+// writeTestMMDB writes an IPv4 database with a single network pointing to the record
+func writeTestMMDB(t *testing.T, path string, network string, record map[string]interface{}) {
+	_, ipNet, err := net.ParseCIDR(network)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip := ipNet.IP.To4()
+	prefixLen, _ := ipNet.Mask.Size()
+	nodeCount := uint32(prefixLen)
+
+	putRecord := func(bb []byte, value uint32) {
+		bb[0] = byte(value >> 16)
+		bb[1] = byte(value >> 8)
+		bb[2] = byte(value)
+	}
+
+	var db []byte
+	for i := 0; i < prefixLen; i++ {
+		node := make([]byte, 6)
+		putRecord(node[:3], nodeCount)
+		putRecord(node[3:], nodeCount)
+
+		next := uint32(i + 1)
+		if i == prefixLen-1 {
+			// Points to the first record in the data section
+			next = nodeCount + 16
+		}
+
+		bit := ip[i/8] >> (7 - uint(i%8)) & 1
+		putRecord(node[bit*3:bit*3+3], next)
+		db = append(db, node...)
+	}
+
+	db = append(db, make([]byte, 16)...)
+	db = append(db, encodeMMDBValue(record)...)
+	db = append(db, "\xab\xcd\xefMaxMind.com"...)
+	db = append(db, encodeMMDBValue(map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(time.Now().Unix()),
+		"database_type":               "Infrared-Test",
+		"description":                 map[string]interface{}{"en": "Infrared test database"},
+		"ip_version":                  uint16(4),
+		"languages":                   []interface{}{"en"},
+		"node_count":                  nodeCount,
+		"record_size":                 uint16(24),
+	})...)
+
+	if err := ioutil.WriteFile(path, db, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGeoIP_Lookup(t *testing.T) {
+	dir := t.TempDir()
+	countryPath := filepath.Join(dir, "country.mmdb")
+	writeTestMMDB(t, countryPath, "81.2.69.0/24", map[string]interface{}{
+		"country": map[string]interface{}{"iso_code": "GB"},
+	})
+
+	asnPath := filepath.Join(dir, "asn.mmdb")
+	writeTestMMDB(t, asnPath, "81.2.69.0/24", map[string]interface{}{
+		"autonomous_system_number":       uint32(20712),
+		"autonomous_system_organization": "Andrews & Arnold Ltd",
+	})
+
+	geoIP, err := LoadGeoIPFromPaths([]string{countryPath, asnPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	location, err := geoIP.Lookup(net.ParseIP("81.2.69.142"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := GeoIPLocation{Country: "GB", ASN: 20712, ASOrganization: "Andrews & Arnold Ltd"}
+	if location != expected {
+		t.Errorf("got: %+v; want: %+v", location, expected)
+	}
+
+	location, err = geoIP.Lookup(net.ParseIP("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if location != (GeoIPLocation{}) {
+		t.Errorf("got: %+v; want an empty location", location)
+	}
+}
+
+func TestGeoIP_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	writeTestMMDB(t, path, "81.2.69.0/24", map[string]interface{}{
+		"country": map[string]interface{}{"iso_code": "GB"},
+	})
+
+	geoIP, err := LoadGeoIPFromPaths([]string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replace the database like an updater would
+	tmpPath := path + ".tmp"
+	writeTestMMDB(t, tmpPath, "81.2.69.0/24", map[string]interface{}{
+		"country": map[string]interface{}{"iso_code": "DE"},
+	})
+	if err := os.Rename(tmpPath, path); err != nil {
+		t.Fatal(err)
+	}
+
+	ip := net.ParseIP("81.2.69.142")
+	deadline := time.Now().Add(time.Second)
+	for {
+		location, err := geoIP.Lookup(ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if location.Country == "DE" {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("GeoIP database was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestIsCountryAllowed(t *testing.T) {
+	tt := []struct {
+		country string
+		allow   []string
+		deny    []string
+		allowed bool
+	}{
+		{
+			country: "GB",
+			allowed: true,
+		},
+		{
+			country: "GB",
+			deny:    []string{"gb"},
+			allowed: false,
+		},
+		{
+			country: "",
+			deny:    []string{"GB"},
+			allowed: true,
+		},
+		{
+			country: "DE",
+			allow:   []string{"DE", "AT"},
+			allowed: true,
+		},
+		{
+			country: "",
+			allow:   []string{"DE"},
+			allowed: false,
+		},
+		{
+			country: "DE",
+			allow:   []string{"DE"},
+			deny:    []string{"DE"},
+			allowed: false,
+		},
+	}
+
+	for _, tc := range tt {
+		if isCountryAllowed(tc.country, tc.allow, tc.deny) != tc.allowed {
+			t.Errorf("%q allow %v deny %v: got: %v; want: %v", tc.country, tc.allow, tc.deny, !tc.allowed, tc.allowed)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package infrared
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Duration is a time.Duration that is written like "1m30s" in config files
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(bb []byte) error {
+	var s string
+	if err := json.Unmarshal(bb, &s); err != nil {
+		return fmt.Errorf("duration has to be a string like \"1m30s\"; %w", err)
+	}
+
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(duration)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// GlobalConfig is the global config file of Infrared like infrared.yml.
+// Fields that are not set in the file are nil, so that they keep
+// the value of their flag, environment variable or default.
+type GlobalConfig struct {
+	ConfigPath       *string                      `json:"configPath"`
+	ConfigRecursive  *bool                        `json:"configRecursive"`
+	ConfigIgnore     []string                     `json:"configIgnore"`
+	ConfigHTTP       GlobalConfigHTTPConfig       `json:"configHttp"`
+	ConfigDocker     GlobalConfigDockerConfig     `json:"configDocker"`
+	ConfigKubernetes GlobalConfigKubernetesConfig `json:"configKubernetes"`
+	Listener         GlobalListenerConfig         `json:"listener"`
+	// Listeners override the settings of the listener for single listen addresses
+	Listeners  map[string]GlobalListenerAddressConfig `json:"listeners"`
+	API        GlobalAPIConfig                        `json:"api"`
+	Prometheus GlobalPrometheusConfig                 `json:"prometheus"`
+	Logging    GlobalLoggingConfig                    `json:"logging"`
+	// Defaults are the values of proxy config fields that are not set in a proxy config
+	Defaults map[string]interface{} `json:"defaults"`
+}
+
+// GlobalConfigHTTPConfig holds the endpoint that proxy configs are polled from
+type GlobalConfigHTTPConfig struct {
+	URL      *string   `json:"url"`
+	Interval *Duration `json:"interval"`
+	LongPoll *Duration `json:"longPoll"`
+	// Token is sent as bearer token in the Authorization header
+	Token *string `json:"token"`
+}
+
+// GlobalConfigDockerConfig holds the discovery of proxy configs from the labels of Docker containers
+type GlobalConfigDockerConfig struct {
+	Enabled     *bool   `json:"enabled"`
+	LabelPrefix *string `json:"labelPrefix"`
+}
+
+// GlobalConfigKubernetesConfig holds the discovery of proxy configs from the annotations of Kubernetes Services
+type GlobalConfigKubernetesConfig struct {
+	Enabled   *bool   `json:"enabled"`
+	Namespace *string `json:"namespace"`
+	APIServer *string `json:"apiServer"`
+}
+
+// GlobalListenerConfig holds the settings that apply to every listener
+type GlobalListenerConfig struct {
+	ReceiveProxyProtocol *bool                      `json:"receiveProxyProtocol"`
+	ReceiveRealIP        *bool                      `json:"receiveRealIp"`
+	RealIPTrustedCIDRs   []string                   `json:"realIpTrustedCidrs"`
+	RealIPPublicKey      *string                    `json:"realIpPublicKey"`
+	AccessList           *string                    `json:"accessList"`
+	RateLimit            GlobalRateLimitConfig      `json:"rateLimit"`
+	MaxConnections       *int                       `json:"maxConnections"`
+	DuplicateLogin       GlobalDuplicateLoginConfig `json:"duplicateLogin"`
+	Timeouts             GlobalTimeoutConfig        `json:"timeouts"`
+	AttackMode           GlobalAttackModeConfig     `json:"attackMode"`
+	GeoIPDatabases       []string                   `json:"geoipDatabases"`
+}
+
+// GlobalListenerAddressConfig holds the settings of the listener on one address.
+// Fields that are not set keep the value of the settings for every listener.
+type GlobalListenerAddressConfig struct {
+	ReceiveRealIP      *bool                 `json:"receiveRealIp"`
+	RealIPTrustedCIDRs []string              `json:"realIpTrustedCidrs"`
+	RealIPPublicKey    *string               `json:"realIpPublicKey"`
+	RateLimit          GlobalRateLimitConfig `json:"rateLimit"`
+}
+
+// GlobalRateLimitConfig holds the rate limits of the listeners in the format rate[:burst]
+type GlobalRateLimitConfig struct {
+	IP            *string `json:"ip"`
+	Subnet        *string `json:"subnet"`
+	Status        *string `json:"status"`
+	Login         *string `json:"login"`
+	MaxConnsPerIP *int    `json:"maxConnsPerIp"`
+}
+
+type GlobalDuplicateLoginConfig struct {
+	Policy *string `json:"policy"`
+}
+
+type GlobalTimeoutConfig struct {
+	Handshake *Duration `json:"handshake"`
+	Status    *Duration `json:"status"`
+	Login     *Duration `json:"login"`
+	Idle      *Duration `json:"idle"`
+}
+
+type GlobalAttackModeConfig struct {
+	Threshold     *int      `json:"threshold"`
+	Cooldown      *Duration `json:"cooldown"`
+	PingWindow    *Duration `json:"pingWindow"`
+	Message       *string   `json:"message"`
+	RateLimitIP   *string   `json:"rateLimitIp"`
+	MaxConnsPerIP *int      `json:"maxConnsPerIp"`
+}
+
+type GlobalAPIConfig struct {
+	Enabled *bool   `json:"enabled"`
+	Bind    *string `json:"bind"`
+}
+
+type GlobalPrometheusConfig struct {
+	Enabled   *bool   `json:"enabled"`
+	Bind      *string `json:"bind"`
+	GeoLabels *bool   `json:"geoLabels"`
+}
+
+type GlobalLoggingConfig struct {
+	// File is a path that logs are written to in addition to stderr
+	File     *string              `json:"file"`
+	AuditLog GlobalAuditLogConfig `json:"auditLog"`
+}
+
+type GlobalAuditLogConfig struct {
+	Path *string `json:"path"`
+	// MaxSize is the size in megabytes after which the audit log is rotated
+	MaxSize    *int      `json:"maxSize"`
+	MaxAge     *Duration `json:"maxAge"`
+	MaxBackups *int      `json:"maxBackups"`
+	Compress   *bool     `json:"compress"`
+}
+
+// LoadGlobalConfig validates and loads the global config from a JSON, YAML or TOML file depending on its extension
+func LoadGlobalConfig(path string) (GlobalConfig, error) {
+	var cfg GlobalConfig
+	bb, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+
+	file := validateGlobalConfig(path, bb, false)
+	file.logWarnings()
+	if len(file.errors) > 0 {
+		return cfg, file.errors
+	}
+
+	rawCfg, err := UnmarshalConfig(ConfigFormatFromPath(path), bb)
+	if err != nil {
+		return cfg, err
+	}
+
+	bb, err = json.Marshal(rawCfg)
+	if err != nil {
+		return cfg, err
+	}
+
+	if err := json.Unmarshal(bb, &cfg); err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+// WatchGlobalConfig calls onChange every time the file at path was written or replaced.
+// It watches the directory of the file, so that editors that replace the file on save are noticed.
+func WatchGlobalConfig(path string, onChange func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	// The interval protects the watcher from write event spams
+	tick := time.Tick(time.Millisecond * 50)
+	changed := false
+	for {
+		select {
+		case <-tick:
+			if !changed {
+				continue
+			}
+			changed = false
+			onChange()
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(event.Name) != filepath.Clean(path) {
+				continue
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+				changed = true
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("Failed watching %s; error %s", path, err)
+		}
+	}
+}
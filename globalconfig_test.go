@@ -0,0 +1,158 @@
+package infrared
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadGlobalConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "infrared.yml")
+	content := `configPath: /configs
+listener:
+  receiveProxyProtocol: true
+  realIpTrustedCidrs: [192.0.2.0/24]
+  maxConnections: 100
+  timeouts:
+    handshake: 2s
+    idle: 5m
+listeners:
+  ":25566":
+    receiveRealIp: true
+api:
+  enabled: true
+defaults:
+  disconnectMessage: Offline
+`
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadGlobalConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.ConfigPath == nil || *cfg.ConfigPath != "/configs" {
+		t.Errorf("got: %v; want: /configs", cfg.ConfigPath)
+	}
+
+	if cfg.Listener.ReceiveProxyProtocol == nil || !*cfg.Listener.ReceiveProxyProtocol {
+		t.Errorf("got: %v; want: true", cfg.Listener.ReceiveProxyProtocol)
+	}
+
+	if len(cfg.Listener.RealIPTrustedCIDRs) != 1 {
+		t.Errorf("got: %v; want: [192.0.2.0/24]", cfg.Listener.RealIPTrustedCIDRs)
+	}
+
+	if cfg.Listener.MaxConnections == nil || *cfg.Listener.MaxConnections != 100 {
+		t.Errorf("got: %v; want: 100", cfg.Listener.MaxConnections)
+	}
+
+	if cfg.Listener.Timeouts.Handshake == nil || time.Duration(*cfg.Listener.Timeouts.Handshake) != 2*time.Second {
+		t.Errorf("got: %v; want: 2s", cfg.Listener.Timeouts.Handshake)
+	}
+
+	if cfg.Listener.Timeouts.Idle == nil || time.Duration(*cfg.Listener.Timeouts.Idle) != 5*time.Minute {
+		t.Errorf("got: %v; want: 5m", cfg.Listener.Timeouts.Idle)
+	}
+
+	if listener := cfg.Listeners[":25566"]; listener.ReceiveRealIP == nil || !*listener.ReceiveRealIP || listener.RealIPTrustedCIDRs != nil {
+		t.Errorf("got: %+v; want: only receiveRealIp", listener)
+	}
+
+	// Unset fields must not override flags, environment variables or defaults
+	if cfg.Listener.ReceiveRealIP != nil || cfg.Listener.Timeouts.Login != nil || cfg.API.Bind != nil || cfg.Prometheus.Enabled != nil {
+		t.Error("unset fields should be nil")
+	}
+
+	if cfg.Defaults["disconnectMessage"] != "Offline" {
+		t.Errorf("got: %v; want: Offline", cfg.Defaults["disconnectMessage"])
+	}
+}
+
+func TestLoadGlobalConfig_InvalidDuration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "infrared.yml")
+	if err := ioutil.WriteFile(path, []byte("listener:\n  timeouts:\n    login: 10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadGlobalConfig(path); err == nil {
+		t.Error("durations without a unit should be rejected")
+	}
+}
+
+func TestSetProxyDefaults(t *testing.T) {
+	SetProxyDefaults(map[string]interface{}{
+		"disconnectMessage": "Offline",
+		"listenTo":          ":25566",
+		"offlineStatus": map[string]interface{}{
+			"motd": "Default MOTD",
+		},
+	})
+	defer SetProxyDefaults(nil)
+
+	path := filepath.Join(t.TempDir(), "proxy.yml")
+	if err := ioutil.WriteFile(path, []byte("domainName: mc.example.com\nlistenTo: \":25567\"\nproxyTo: \":25566\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg ProxyConfig
+	if err := cfg.LoadFromPath(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.DisconnectMessage != "Offline" {
+		t.Errorf("got: %v; want: Offline", cfg.DisconnectMessage)
+	}
+
+	// The proxy config takes precedence over the defaults
+	if cfg.ListenTo != ":25567" {
+		t.Errorf("got: %v; want: :25567", cfg.ListenTo)
+	}
+
+	// Nested defaults are merged into the DefaultProxyConfig
+	if cfg.OfflineStatus.MOTD != "Default MOTD" || cfg.OfflineStatus.VersionName != DefaultProxyConfig().OfflineStatus.VersionName {
+		t.Errorf("got: %+v; want the default MOTD and version name", cfg.OfflineStatus)
+	}
+
+	// Fields without a default keep the DefaultProxyConfig
+	if cfg.ServerFullMessage != DefaultProxyConfig().ServerFullMessage {
+		t.Errorf("got: %v; want: %v", cfg.ServerFullMessage, DefaultProxyConfig().ServerFullMessage)
+	}
+}
+
+func TestWatchGlobalConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "infrared.yml")
+	if err := ioutil.WriteFile(path, []byte("api: {enabled: false}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	changed := make(chan bool, 1)
+	go WatchGlobalConfig(path, func() {
+		select {
+		case changed <- true:
+		default:
+		}
+	})
+	// Give the watcher time to start
+	time.Sleep(50 * time.Millisecond)
+
+	// Editors often replace the file instead of writing to it
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, []byte("api: {enabled: true}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Error("change was not noticed")
+	}
+}
@@ -0,0 +1,189 @@
+package infrared
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// DefaultHTTPConfigInterval is the default time between two polls of an HTTPConfigProvider
+	DefaultHTTPConfigInterval = 10 * time.Second
+
+	// httpConfigTimeout is the time that a poll may take in addition to the long poll
+	httpConfigTimeout = 10 * time.Second
+	// minLongPollInterval keeps endpoints that do not hold long polls from being polled in a loop
+	minLongPollInterval = time.Second
+)
+
+// HTTPConfigProvider polls an HTTP endpoint that responds with a JSON list of proxy configs like
+//
+//	[{"id": "lobby", "config": {"domainName": "lobby.example.com", "proxyTo": "lobby:25565"}}]
+//
+// The IDs identify the configs across polls. Configs are changed in place
+// if their content changed and removed if their ID is not in the list anymore.
+// The endpoint is trusted like the config files, but its configs cannot extend files.
+//
+// Every poll sends the ETag of the last response as If-None-Match,
+// so that the endpoint can respond with 304 Not Modified if nothing changed.
+type HTTPConfigProvider struct {
+	URL string
+	// Interval is the time between two polls and between retries of failed polls
+	Interval time.Duration
+	// LongPoll is the time that the endpoint may hold a poll until the configs change.
+	// It is sent as the wait query parameter, like wait=30s, together with If-None-Match.
+	// The next poll starts as soon as the last one returns. Zero disables long polling.
+	LongPoll time.Duration
+	// Header is sent with every poll, like an Authorization header
+	Header http.Header
+	Client *http.Client
+
+	etag    string
+	configs configSet
+}
+
+type httpConfigEntry struct {
+	ID     string          `json:"id"`
+	Config json.RawMessage `json:"config"`
+}
+
+// Provide polls the endpoint and sends the configs in its responses as events until the context is done.
+// Failed polls are logged and retried after the interval.
+func (provider *HTTPConfigProvider) Provide(ctx context.Context, events chan<- ConfigEvent) error {
+	if _, err := url.ParseRequestURI(provider.URL); err != nil {
+		return err
+	}
+
+	interval := provider.Interval
+	if interval <= 0 {
+		interval = DefaultHTTPConfigInterval
+	}
+
+	for {
+		start := time.Now()
+		longPoll := provider.longPolls()
+		err := provider.poll(ctx, events)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		wait := interval
+		if err != nil {
+			log.Printf("Failed polling proxy configs from %s; error: %s", provider.URL, err)
+		} else if longPoll || provider.longPolls() {
+			// Without an ETag no long poll is made and the endpoint is polled by the interval
+			wait = minLongPollInterval - time.Since(start)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// longPolls checks if the next poll waits for changes. The endpoint needs
+// to send an ETag first, so that it knows which configs the poll waits for.
+func (provider *HTTPConfigProvider) longPolls() bool {
+	return provider.LongPoll > 0 && provider.etag != ""
+}
+
+func (provider *HTTPConfigProvider) poll(ctx context.Context, events chan<- ConfigEvent) error {
+	u, err := url.Parse(provider.URL)
+	if err != nil {
+		return err
+	}
+
+	timeout := httpConfigTimeout
+	if provider.longPolls() {
+		query := u.Query()
+		query.Set("wait", provider.LongPoll.String())
+		u.RawQuery = query.Encode()
+		timeout += provider.LongPoll
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	for key, values := range provider.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	if provider.etag != "" {
+		req.Header.Set("If-None-Match", provider.etag)
+	}
+
+	client := provider.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil
+	case http.StatusOK:
+	default:
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var entries []httpConfigEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return fmt.Errorf("failed decoding proxy configs; %w", err)
+	}
+
+	provider.apply(entries, events, ctx.Done())
+	provider.etag = resp.Header.Get("ETag")
+	return nil
+}
+
+// apply loads the configs of a response and sends their changes as events
+func (provider *HTTPConfigProvider) apply(entries []httpConfigEntry, events chan<- ConfigEvent, done <-chan struct{}) {
+	ids := map[string]bool{}
+	var sources []configSource
+	for _, entry := range entries {
+		if entry.ID == "" {
+			log.Printf("Skipping proxy config without ID from %s", provider.URL)
+			continue
+		}
+
+		if ids[entry.ID] {
+			log.Printf("Skipping proxy config with duplicate ID %s from %s", entry.ID, provider.URL)
+			continue
+		}
+		ids[entry.ID] = true
+
+		sources = append(sources, configSource{
+			name:   provider.source(entry.ID),
+			raw:    entry.Config,
+			origin: configOriginHTTP,
+		})
+	}
+
+	provider.configs.apply(sources, func(event ConfigEvent) {
+		select {
+		case events <- event:
+		case <-done:
+		}
+	})
+}
+
+// source names a config of the endpoint in logs, validation errors and events
+func (provider *HTTPConfigProvider) source(id string) string {
+	return provider.URL + "#" + id
+}
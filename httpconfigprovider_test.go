@@ -0,0 +1,208 @@
+package infrared
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHTTPConfigProvider_Provide(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		version = 1
+		body    = `[
+  {"id": "lobby", "config": {"domainName": "lobby.example.com", "proxyTo": ":25566"}},
+  {"id": "survival", "config": {"domainName": "survival.example.com", "proxyTo": ":25567"}},
+  {"id": "invalid", "config": {"domainName": "invalid.example.com", "proxyTo": 25566}}
+]`
+		changed = make(chan bool)
+		polls   = make(chan *http.Request, 10)
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		polls <- r
+
+		mu.Lock()
+		etag := fmt.Sprintf(`"v%d"`, version)
+		mu.Unlock()
+
+		if r.Header.Get("If-None-Match") == etag {
+			wait, _ := time.ParseDuration(r.URL.Query().Get("wait"))
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+				return
+			case <-time.After(wait):
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("ETag", fmt.Sprintf(`"v%d"`, version))
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+
+	provider := HTTPConfigProvider{
+		URL:      server.URL,
+		LongPoll: 5 * time.Second,
+		Header:   http.Header{"Authorization": []string{"Bearer token"}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan ConfigEvent)
+	go provider.Provide(ctx, events)
+
+	receive := func(t *testing.T) ConfigEvent {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(time.Second):
+			t.Fatal("no event was sent")
+			return ConfigEvent{}
+		}
+	}
+
+	// Invalid configs are skipped
+	added := map[string]*ProxyConfig{}
+	for i := 0; i < 2; i++ {
+		event := receive(t)
+		if event.Type != ConfigAdded {
+			t.Fatalf("expected added event, got %s", event.Type)
+		}
+		added[event.ID] = event.Config
+	}
+
+	lobby := added[server.URL+"#lobby"]
+	if lobby == nil || lobby.DomainName != "lobby.example.com" {
+		t.Fatalf("lobby was not added: %v", added)
+	}
+
+	if survival := added[server.URL+"#survival"]; survival == nil || survival.ProxyTo != ":25567" {
+		t.Fatalf("survival was not added: %v", added)
+	}
+
+	if r := <-polls; r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("If-None-Match") != "" {
+		t.Errorf("unexpected headers of the first poll: %v", r.Header)
+	}
+
+	// The second poll waits for a change
+	r := <-polls
+	if r.Header.Get("If-None-Match") != `"v1"` || r.URL.Query().Get("wait") != "5s" {
+		t.Errorf("expected long poll with ETag, got %s %v", r.URL, r.Header)
+	}
+
+	mu.Lock()
+	version = 2
+	body = `[{"id": "lobby", "config": {"domainName": "lobby.example.com", "proxyTo": ":25568"}}]`
+	mu.Unlock()
+	changed <- true
+
+	var updated, removed bool
+	for i := 0; i < 2; i++ {
+		event := receive(t)
+		switch {
+		case event.Type == ConfigUpdated && event.ID == server.URL+"#lobby":
+			if event.Config != lobby || lobby.ProxyTo != ":25568" {
+				t.Errorf("lobby was not updated in place: %s", lobby.ProxyTo)
+			}
+			updated = true
+		case event.Type == ConfigRemoved && event.ID == server.URL+"#survival":
+			removed = true
+		default:
+			t.Errorf("unexpected event %s %s", event.ID, event.Type)
+		}
+	}
+
+	if !updated || !removed {
+		t.Errorf("expected lobby to be updated and survival to be removed")
+	}
+}
+
+func TestHTTPConfigProvider_Provide_Retry(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		fails = 2
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fails > 0 {
+			fails--
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `[{"id": "lobby", "config": {"domainName": "lobby.example.com", "proxyTo": ":25566"}}]`)
+	}))
+	defer server.Close()
+
+	provider := HTTPConfigProvider{
+		URL:      server.URL,
+		Interval: 10 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan ConfigEvent)
+	go provider.Provide(ctx, events)
+
+	select {
+	case event := <-events:
+		if event.Type != ConfigAdded {
+			t.Errorf("expected added event, got %s", event.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("failed polls were not retried")
+	}
+
+	// Unchanged configs are not sent again
+	select {
+	case event := <-events:
+		t.Errorf("unexpected event %s %s", event.ID, event.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestHTTPConfigProvider_Provide_LongPollWithoutETag(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		polls int
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		polls++
+		fmt.Fprint(w, `[]`)
+	}))
+	defer server.Close()
+
+	provider := HTTPConfigProvider{
+		URL:      server.URL,
+		Interval: time.Minute,
+		LongPoll: 5 * time.Second,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go provider.Provide(ctx, make(chan ConfigEvent))
+
+	// Without an ETag no long poll is made, so the endpoint is polled by the interval
+	time.Sleep(minLongPollInterval + 200*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if polls != 1 {
+		t.Errorf("got: %d polls; want: 1", polls)
+	}
+}
@@ -0,0 +1,216 @@
+package infrared
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RedactedSecret replaces the values of secrets in logs and API responses
+const RedactedSecret = "[REDACTED]"
+
+var (
+	// referenceRegexp matches ${...} and the escaped form $${...}
+	referenceRegexp = regexp.MustCompile(`\$?\$\{[^}]*\}`)
+	envNameRegexp   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	secretsMu sync.RWMutex
+	secrets   = map[string]bool{}
+)
+
+// interpolate replaces the references in a string:
+//   - ${NAME} with the environment variable NAME
+//   - ${NAME:-default} with the environment variable NAME or default if it is unset or empty
+//   - ${file:/run/secrets/name} with the content of the file without trailing line breaks
+//   - $${ with ${
+//
+// Values of files are secrets.
+func interpolate(s string) (string, error) {
+	var err error
+	s = referenceRegexp.ReplaceAllStringFunc(s, func(reference string) string {
+		if strings.HasPrefix(reference, "$$") {
+			return reference[1:]
+		}
+
+		value, refErr := resolveReference(reference[2 : len(reference)-1])
+		if refErr != nil && err == nil {
+			err = refErr
+		}
+		return value
+	})
+	return s, err
+}
+
+func resolveReference(reference string) (string, error) {
+	if strings.HasPrefix(reference, "file:") {
+		path := strings.TrimPrefix(reference, "file:")
+		bb, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed reading secret file; %w", err)
+		}
+
+		value := strings.TrimRight(string(bb), "\r\n")
+		AddSecret(value)
+		return value, nil
+	}
+
+	name, defaultValue, hasDefault := reference, "", false
+	if i := strings.Index(reference, ":-"); i >= 0 {
+		name, defaultValue, hasDefault = reference[:i], reference[i+2:], true
+	}
+
+	if !envNameRegexp.MatchString(name) {
+		return "", fmt.Errorf("invalid reference %s", strconv.Quote("${"+reference+"}"))
+	}
+
+	value, ok := os.LookupEnv(name)
+	if hasDefault && value == "" {
+		return defaultValue, nil
+	}
+
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// interpolateConfig interpolates all strings of a decoded config.
+// onError is called with the key path of every string that could not be interpolated.
+func interpolateConfig(value interface{}, path []string, onError func(path []string, err error)) interface{} {
+	switch value := value.(type) {
+	case string:
+		s, err := interpolate(value)
+		if err != nil {
+			onError(path, err)
+		}
+		return s
+	case map[string]interface{}:
+		interpolated := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			interpolated[k] = interpolateConfig(v, append(append([]string{}, path...), k), onError)
+		}
+		return interpolated
+	case []interface{}:
+		interpolated := make([]interface{}, len(value))
+		for i, v := range value {
+			interpolated[i] = interpolateConfig(v, append(append([]string{}, path...), strconv.Itoa(i)), onError)
+		}
+		return interpolated
+	default:
+		return value
+	}
+}
+
+// AddSecret registers a value that is redacted by RedactSecrets
+func AddSecret(secret string) {
+	if secret == "" {
+		return
+	}
+
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	secrets[secret] = true
+}
+
+// addConfigSecrets registers the credentials of a decoded config, no matter if they are
+// written into the file, come from an environment variable or from a secret file
+func addConfigSecrets(cfg *ProxyConfig) {
+	AddSecret(cfg.Docker.Portainer.Password)
+	AddSecret(cfg.Docker.Pterodactyl.APIKey)
+	addURLSecrets(cfg.Docker.Portainer.Address)
+	addURLSecrets(cfg.Docker.Pterodactyl.URL)
+	addURLSecrets(cfg.Docker.Kubernetes.APIServer)
+	addURLSecrets(cfg.CallbackServer.URL)
+
+	webhook := cfg.Docker.Webhook
+	for _, call := range []WebhookCallConfig{webhook.Start, webhook.Stop, webhook.Status} {
+		addURLSecrets(call.URL)
+		for name, value := range call.Header {
+			if !isSecretName(name) {
+				continue
+			}
+
+			AddSecret(value)
+			// The credentials of "Bearer <token>" are also redacted on their own
+			if fields := strings.Fields(value); len(fields) == 2 {
+				AddSecret(fields[1])
+			}
+		}
+	}
+}
+
+// addURLSecrets registers the password and the secret query parameters of a URL
+func addURLSecrets(rawURL string) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return
+	}
+
+	if password, ok := u.User.Password(); ok {
+		AddSecret(password)
+	}
+
+	for name, values := range u.Query() {
+		if !isSecretName(name) {
+			continue
+		}
+		for _, value := range values {
+			AddSecret(value)
+		}
+	}
+}
+
+// isSecretName checks if a header or query parameter carries credentials, like Authorization or X-Api-Key
+func isSecretName(name string) bool {
+	name = strings.ToLower(name)
+	for _, part := range []string{"auth", "token", "key", "secret", "password", "cookie", "signature"} {
+		if strings.Contains(name, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactSecrets replaces all secrets in s, like passwords and the values of secret files
+func RedactSecrets(s string) string {
+	secretsMu.RLock()
+	sorted := make([]string, 0, len(secrets))
+	for secret := range secrets {
+		sorted = append(sorted, secret)
+	}
+	secretsMu.RUnlock()
+
+	// Longer secrets first, so that secrets that contain others are redacted completely
+	sort.Slice(sorted, func(i, j int) bool {
+		return len(sorted[i]) > len(sorted[j])
+	})
+
+	for _, secret := range sorted {
+		s = strings.ReplaceAll(s, secret, RedactedSecret)
+	}
+	return s
+}
+
+type redactWriter struct {
+	w io.Writer
+}
+
+// NewRedactWriter returns a writer that redacts secrets before writing to w.
+// It is meant to be the output of a logger.
+func NewRedactWriter(w io.Writer) io.Writer {
+	return redactWriter{w: w}
+}
+
+func (w redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, RedactSecrets(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
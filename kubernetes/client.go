@@ -0,0 +1,270 @@
+// Package kubernetes is a minimal client of the Kubernetes API for the Services
+// and the scale subresource of workloads that Infrared needs.
+package kubernetes
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Client sends requests to the API server at Host
+type Client struct {
+	// Host is the URL of the API server like https://10.96.0.1:443
+	Host string
+	// Token is sent as bearer token if it is set
+	Token string
+	// TokenFile is read before every request and its content is sent instead of Token.
+	// The kubelet rotates the token of a service account in the file before it expires.
+	TokenFile  string
+	HTTPClient *http.Client
+}
+
+// NewClient returns a client for the API server at host.
+// If host is empty, the client uses the service account of the pod that Infrared runs in.
+func NewClient(host string) (*Client, error) {
+	if host != "" {
+		return &Client{Host: host}, nil
+	}
+	return InClusterClient()
+}
+
+// InClusterClient returns a client that uses the service account of the pod that Infrared runs in
+func InClusterClient() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a Kubernetes cluster; KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+
+	tokenFile := serviceAccountDir + "/token"
+	if _, err := ioutil.ReadFile(tokenFile); err != nil {
+		return nil, err
+	}
+
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no certificate in the CA of the service account")
+	}
+
+	return &Client{
+		Host:      "https://" + net.JoinHostPort(host, port),
+		TokenFile: tokenFile,
+		HTTPClient: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		},
+	}, nil
+}
+
+// ObjectMeta is the metadata of an object
+type ObjectMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace"`
+	ResourceVersion string            `json:"resourceVersion"`
+	Labels          map[string]string `json:"labels"`
+	Annotations     map[string]string `json:"annotations"`
+}
+
+// ListMeta is the metadata of a list
+type ListMeta struct {
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+type Service struct {
+	Metadata ObjectMeta  `json:"metadata"`
+	Spec     ServiceSpec `json:"spec"`
+}
+
+type ServiceSpec struct {
+	Ports []ServicePort `json:"ports"`
+}
+
+type ServicePort struct {
+	Name string `json:"name"`
+	Port int    `json:"port"`
+}
+
+type ServiceList struct {
+	Metadata ListMeta  `json:"metadata"`
+	Items    []Service `json:"items"`
+}
+
+// WatchEvent is a change of an object like ADDED, MODIFIED or DELETED
+type WatchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// Scale is the scale subresource of a workload like a Deployment or StatefulSet
+type Scale struct {
+	Spec struct {
+		Replicas int `json:"replicas"`
+	} `json:"spec"`
+	Status struct {
+		Replicas int `json:"replicas"`
+	} `json:"status"`
+}
+
+// StatusError is the error of a request that the API server answered with an error status
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (err StatusError) Error() string {
+	return fmt.Sprintf("kubernetes API responded with %d: %s", err.Code, err.Message)
+}
+
+// ListServices returns the services of the namespace or of all namespaces if it is empty
+func (client *Client) ListServices(ctx context.Context, namespace string) (ServiceList, error) {
+	var list ServiceList
+	err := client.do(ctx, http.MethodGet, servicesPath(namespace), nil, "", &list)
+	return list, err
+}
+
+// WatchServices calls onEvent for every change of the services after the resource version
+// until the context is done or the API server ends the watch
+func (client *Client) WatchServices(ctx context.Context, namespace, resourceVersion string, onEvent func(WatchEvent)) error {
+	query := url.Values{}
+	query.Set("watch", "true")
+	query.Set("resourceVersion", resourceVersion)
+
+	resp, err := client.request(ctx, http.MethodGet, servicesPath(namespace)+"?"+query.Encode(), nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var event WatchEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return err
+		}
+
+		if event.Type == "ERROR" {
+			var status struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			}
+			_ = json.Unmarshal(event.Object, &status)
+			return StatusError{Code: status.Code, Message: status.Message}
+		}
+		onEvent(event)
+	}
+	return scanner.Err()
+}
+
+// GetScale returns the scale of a workload; resource is the plural like deployments or statefulsets
+func (client *Client) GetScale(ctx context.Context, namespace, resource, name string) (Scale, error) {
+	var scale Scale
+	err := client.do(ctx, http.MethodGet, scalePath(namespace, resource, name), nil, "", &scale)
+	return scale, err
+}
+
+// SetScale changes the number of replicas of a workload
+func (client *Client) SetScale(ctx context.Context, namespace, resource, name string, replicas int) error {
+	body := fmt.Sprintf(`{"spec":{"replicas":%d}}`, replicas)
+	return client.do(ctx, http.MethodPatch, scalePath(namespace, resource, name), strings.NewReader(body), "application/merge-patch+json", nil)
+}
+
+func servicesPath(namespace string) string {
+	if namespace == "" {
+		return "/api/v1/services"
+	}
+	return fmt.Sprintf("/api/v1/namespaces/%s/services", url.PathEscape(namespace))
+}
+
+func scalePath(namespace, resource, name string) string {
+	return fmt.Sprintf("/apis/apps/v1/namespaces/%s/%s/%s/scale", url.PathEscape(namespace), resource, url.PathEscape(name))
+}
+
+func (client *Client) do(ctx context.Context, method, path string, body io.Reader, contentType string, v interface{}) error {
+	resp, err := client.request(ctx, method, path, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// request sends a request and returns the response if its status is 2xx
+func (client *Client) request(ctx context.Context, method, path string, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(client.Host, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	token, err := client.token()
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	httpClient := client.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		bb, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		var status struct {
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(bb, &status); err != nil || status.Message == "" {
+			status.Message = string(bytes.TrimSpace(bb))
+		}
+		return nil, StatusError{Code: resp.StatusCode, Message: status.Message}
+	}
+	return resp, nil
+}
+
+// token returns the current bearer token of the client
+func (client *Client) token() (string, error) {
+	if client.TokenFile == "" {
+		return client.Token, nil
+	}
+
+	bb, err := ioutil.ReadFile(client.TokenFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(bb)), nil
+}
@@ -0,0 +1,120 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestClient_Scale(t *testing.T) {
+	replicas := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/apis/apps/v1/namespaces/minecraft/statefulsets/survival/scale" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","message":"statefulsets.apps \"survival\" not found"}`)
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.Method == http.MethodPatch {
+			if r.Header.Get("Content-Type") != "application/merge-patch+json" {
+				t.Errorf("unexpected content type %s", r.Header.Get("Content-Type"))
+			}
+			bb, _ := ioutil.ReadAll(r.Body)
+			if _, err := fmt.Sscanf(string(bb), `{"spec":{"replicas":%d}}`, &replicas); err != nil {
+				t.Errorf("unexpected patch %s", bb)
+			}
+		}
+		fmt.Fprintf(w, `{"spec":{"replicas":%d},"status":{"replicas":%d}}`, replicas, replicas)
+	}))
+	defer server.Close()
+
+	client := &Client{Host: server.URL, Token: "token"}
+	ctx := context.Background()
+
+	if err := client.SetScale(ctx, "minecraft", "statefulsets", "survival", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	scale, err := client.GetScale(ctx, "minecraft", "statefulsets", "survival")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if scale.Spec.Replicas != 1 {
+		t.Errorf("expected one replica, got %d", scale.Spec.Replicas)
+	}
+
+	_, err = client.GetScale(ctx, "minecraft", "deployments", "survival")
+	var statusErr StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusNotFound ||
+		statusErr.Message != `statefulsets.apps "survival" not found` {
+		t.Errorf("expected not found status error, got %v", err)
+	}
+}
+
+func TestClient_TokenFile(t *testing.T) {
+	var tokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens = append(tokens, r.Header.Get("Authorization"))
+		fmt.Fprint(w, `{"spec":{"replicas":0},"status":{"replicas":0}}`)
+	}))
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	client := &Client{Host: server.URL, Token: "static", TokenFile: tokenFile}
+	ctx := context.Background()
+
+	for _, token := range []string{"first\n", "rotated\n"} {
+		if err := ioutil.WriteFile(tokenFile, []byte(token), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := client.GetScale(ctx, "minecraft", "deployments", "survival"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{"Bearer first", "Bearer rotated"}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("expected tokens %v, got %v", expected, tokens)
+	}
+}
+
+func TestClient_WatchServices(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/services" || r.URL.Query().Get("watch") != "true" || r.URL.Query().Get("resourceVersion") != "42" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+
+		fmt.Fprintln(w, `{"type":"ADDED","object":{"metadata":{"name":"lobby"}}}`)
+		fmt.Fprintln(w, `{"type":"DELETED","object":{"metadata":{"name":"lobby"}}}`)
+		fmt.Fprintln(w, `{"type":"ERROR","object":{"kind":"Status","code":410,"message":"too old resource version"}}`)
+	}))
+	defer server.Close()
+
+	client := &Client{Host: server.URL}
+	var types []string
+	err := client.WatchServices(context.Background(), "", "42", func(event WatchEvent) {
+		types = append(types, event.Type)
+	})
+
+	var statusErr StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != 410 {
+		t.Errorf("expected gone status error, got %v", err)
+	}
+
+	if len(types) != 2 || types[0] != "ADDED" || types[1] != "DELETED" {
+		t.Errorf("unexpected events %v", types)
+	}
+}
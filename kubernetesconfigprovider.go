@@ -0,0 +1,251 @@
+package infrared
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/haveachin/infrared/kubernetes"
+)
+
+const (
+	// DefaultKubernetesAnnotationPrefix is the default prefix of the annotations that a KubernetesConfigProvider reads
+	DefaultKubernetesAnnotationPrefix = "infrared"
+
+	// kubernetesRetryInterval is the time between the attempts to reach the API server
+	kubernetesRetryInterval = 5 * time.Second
+)
+
+// KubernetesConfigProvider creates proxy configs from the annotations of Services like
+//
+//	infrared.domain: survival.example.com
+//	infrared.autostart: "true"
+//	infrared.scale: statefulset/survival
+//	infrared.offline.motd: Join to start the server
+//
+// Services with a domain annotation are discovered, unless infrared.enable is false.
+// The proxy forwards to the cluster DNS name of the Service and its first port,
+// or to infrared.host and infrared.port, which is a number or the name of a Service port.
+// With autostart the workload of infrared.scale, which defaults to the Deployment
+// with the name of the Service, is scaled to one replica when a player joins
+// and to zero after docker.timeout without players.
+// Other annotations set the few fields of the proxy config that labels of a DockerConfigProvider can set,
+// and like labels they are taken literally without interpolation.
+type KubernetesConfigProvider struct {
+	// APIServer defaults to the API server of the cluster that Infrared runs in
+	APIServer string
+	// Namespace limits the discovery to one namespace. All namespaces are watched if it is empty.
+	Namespace string
+	// AnnotationPrefix defaults to DefaultKubernetesAnnotationPrefix
+	AnnotationPrefix string
+
+	client  *kubernetes.Client
+	configs configSet
+	// resourceVersion is the version of the last listed or watched Service that the next watch starts at
+	resourceVersion string
+}
+
+// Provide sends the configs of all annotated Services and then watches
+// the Services for changes until the context is done.
+// If the API server cannot be reached, it tries again after a few seconds.
+func (provider *KubernetesConfigProvider) Provide(ctx context.Context, events chan<- ConfigEvent) error {
+	client, err := kubernetes.NewClient(provider.APIServer)
+	if err != nil {
+		return err
+	}
+	provider.client = client
+
+	send := func(event ConfigEvent) {
+		select {
+		case events <- event:
+		case <-ctx.Done():
+		}
+	}
+
+	for {
+		err := provider.watch(ctx, send)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		// Watches end regularly and are started again right away
+		if err == nil {
+			continue
+		}
+
+		log.Println("Failed watching Kubernetes services; error:", err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(kubernetesRetryInterval):
+		}
+	}
+}
+
+// watch applies the changes of the Services to the configs. The Services are only
+// listed at the start and when the resource version of the watch is too old.
+func (provider *KubernetesConfigProvider) watch(ctx context.Context, send func(ConfigEvent)) error {
+	if provider.resourceVersion == "" {
+		resourceVersion, err := provider.sync(ctx, send)
+		if err != nil {
+			return err
+		}
+		provider.resourceVersion = resourceVersion
+	}
+
+	err := provider.client.WatchServices(ctx, provider.Namespace, provider.resourceVersion, func(event kubernetes.WatchEvent) {
+		provider.handleEvent(event, send)
+	})
+
+	var statusErr kubernetes.StatusError
+	// The resource version is too old and the Services are listed again
+	if errors.As(err, &statusErr) && statusErr.Code == 410 {
+		provider.resourceVersion = ""
+		return nil
+	}
+	return err
+}
+
+// handleEvent loads, updates or removes the config of the Service of a watch event
+func (provider *KubernetesConfigProvider) handleEvent(event kubernetes.WatchEvent, send func(ConfigEvent)) {
+	var service kubernetes.Service
+	if err := json.Unmarshal(event.Object, &service); err != nil {
+		log.Printf("Failed reading Kubernetes %s event; error: %s", event.Type, err)
+		return
+	}
+
+	if service.Metadata.ResourceVersion != "" {
+		provider.resourceVersion = service.Metadata.ResourceVersion
+	}
+
+	switch event.Type {
+	case "ADDED", "MODIFIED":
+		source, ok, err := provider.source(service)
+		if err != nil {
+			log.Printf("Failed loading %s; error %s", serviceSourceName(service), err)
+			return
+		}
+
+		// Services whose annotations were removed or disabled are removed like deleted ones
+		if !ok {
+			provider.configs.remove(serviceSourceName(service), send)
+			return
+		}
+		provider.configs.update(source, send)
+	case "DELETED":
+		provider.configs.remove(serviceSourceName(service), send)
+	}
+}
+
+// sync loads the configs of all annotated Services and removes the configs of Services that are gone.
+// It returns the resource version of the Services.
+func (provider *KubernetesConfigProvider) sync(ctx context.Context, send func(ConfigEvent)) (string, error) {
+	list, err := provider.client.ListServices(ctx, provider.Namespace)
+	if err != nil {
+		return "", err
+	}
+
+	var sources []configSource
+	for _, service := range list.Items {
+		source, ok, err := provider.source(service)
+		if err != nil {
+			return "", err
+		}
+
+		if ok {
+			sources = append(sources, source)
+		}
+	}
+
+	provider.configs.apply(sources, send)
+	return list.Metadata.ResourceVersion, nil
+}
+
+// source returns the config source of the Service and false if the Service is not discovered
+func (provider *KubernetesConfigProvider) source(service kubernetes.Service) (configSource, bool, error) {
+	prefix := provider.annotationPrefix()
+	annotations := service.Metadata.Annotations
+	if annotations[prefix+".domain"] == "" || annotations[prefix+".enable"] == "false" {
+		return configSource{}, false, nil
+	}
+
+	raw, err := json.Marshal(provider.annotationsToConfig(service, prefix))
+	if err != nil {
+		return configSource{}, false, err
+	}
+
+	return configSource{
+		name:   serviceSourceName(service),
+		raw:    raw,
+		origin: configOriginDiscovered,
+	}, true, nil
+}
+
+// serviceSourceName names the config of a Service in logs, validation errors and events
+func serviceSourceName(service kubernetes.Service) string {
+	return "kubernetes://" + service.Metadata.Namespace + "/" + service.Metadata.Name
+}
+
+func (provider *KubernetesConfigProvider) annotationPrefix() string {
+	if provider.AnnotationPrefix == "" {
+		return DefaultKubernetesAnnotationPrefix
+	}
+	return provider.AnnotationPrefix
+}
+
+// annotationsToConfig returns the raw proxy config of a Service with its annotations
+func (provider *KubernetesConfigProvider) annotationsToConfig(service kubernetes.Service, prefix string) map[string]interface{} {
+	annotations := service.Metadata.Annotations
+	raw := labelsToConfig(annotations, prefix)
+
+	if autostart, _ := strconv.ParseBool(annotations[prefix+".autostart"]); autostart {
+		kind, name := "deployment", service.Metadata.Name
+		if scale, ok := annotations[prefix+".scale"]; ok {
+			if i := strings.Index(scale, "/"); i >= 0 {
+				kind, name = strings.ToLower(scale[:i]), scale[i+1:]
+			} else {
+				name = scale
+			}
+		}
+
+		field := "deployment"
+		if kind == "statefulset" {
+			field = "statefulSet"
+		}
+
+		setKeyPath(raw, []string{"docker", "kubernetes", "namespace"}, service.Metadata.Namespace)
+		setKeyPath(raw, []string{"docker", "kubernetes", field}, name)
+		if provider.APIServer != "" {
+			setKeyPath(raw, []string{"docker", "kubernetes", "apiServer"}, provider.APIServer)
+		}
+	}
+
+	if _, ok := raw["proxyTo"]; !ok {
+		host := service.Metadata.Name + "." + service.Metadata.Namespace + ".svc"
+		if value, ok := annotations[prefix+".host"]; ok {
+			host = value
+		}
+		raw["proxyTo"] = net.JoinHostPort(host, servicePort(service, annotations[prefix+".port"]))
+	}
+	return raw
+}
+
+// servicePort returns the number of the port with the name or number of the annotation
+// and the first port of the Service if the annotation is empty
+func servicePort(service kubernetes.Service, port string) string {
+	for _, servicePort := range service.Spec.Ports {
+		if port == "" || servicePort.Name == port {
+			return strconv.Itoa(servicePort.Port)
+		}
+	}
+
+	if port == "" {
+		return defaultMinecraftPort
+	}
+	return port
+}
@@ -0,0 +1,192 @@
+package infrared
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/haveachin/infrared/kubernetes"
+)
+
+// fakeKubernetesAPI serves the Services of the Kubernetes API
+type fakeKubernetesAPI struct {
+	mu       sync.Mutex
+	services []kubernetes.Service
+	version  int
+	// lists counts the requests that list the Services
+	lists  int
+	events chan kubernetes.WatchEvent
+}
+
+func (api *fakeKubernetesAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/v1/namespaces/minecraft/services" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.URL.Query().Get("watch") != "true" {
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		api.lists++
+		list := kubernetes.ServiceList{Items: api.services}
+		list.Metadata.ResourceVersion = strconv.Itoa(api.version)
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	for {
+		select {
+		case event := <-api.events:
+			json.NewEncoder(w).Encode(event)
+			w.(http.Flusher).Flush()
+			if event.Type == "ERROR" {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// setServices changes the Services that are listed without sending watch events
+func (api *fakeKubernetesAPI) setServices(services ...kubernetes.Service) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.services = services
+	api.version++
+}
+
+// sendEvent sends a watch event of the Service with a new resource version
+func (api *fakeKubernetesAPI) sendEvent(eventType string, service kubernetes.Service) {
+	api.mu.Lock()
+	api.version++
+	service.Metadata.ResourceVersion = strconv.Itoa(api.version)
+	api.mu.Unlock()
+
+	object, _ := json.Marshal(service)
+	api.events <- kubernetes.WatchEvent{Type: eventType, Object: object}
+}
+
+func (api *fakeKubernetesAPI) listCount() int {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return api.lists
+}
+
+func newService(name string, annotations map[string]string) kubernetes.Service {
+	service := kubernetes.Service{
+		Metadata: kubernetes.ObjectMeta{
+			Name:        name,
+			Namespace:   "minecraft",
+			Annotations: annotations,
+		},
+	}
+	service.Spec.Ports = []kubernetes.ServicePort{{Name: "minecraft", Port: 25565}}
+	return service
+}
+
+func TestKubernetesConfigProvider_Provide(t *testing.T) {
+	setenv(t, "INFRARED_TEST_SECRET", "hunter2")
+	api := &fakeKubernetesAPI{
+		events: make(chan kubernetes.WatchEvent),
+		services: []kubernetes.Service{
+			newService("lobby", map[string]string{
+				"infrared.domain": "lobby.example.com",
+			}),
+			newService("unrelated", nil),
+		},
+	}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	provider := KubernetesConfigProvider{
+		APIServer: server.URL,
+		Namespace: "minecraft",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan ConfigEvent)
+	go provider.Provide(ctx, events)
+
+	receive := func(t *testing.T) ConfigEvent {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(time.Second):
+			t.Fatal("no event was sent")
+			return ConfigEvent{}
+		}
+	}
+
+	event := receive(t)
+	if event.Type != ConfigAdded || event.ID != "kubernetes://minecraft/lobby" ||
+		event.Config.ProxyTo != "lobby.minecraft.svc:25565" {
+		t.Fatalf("expected lobby to be added, got %s %s %s", event.ID, event.Type, event.Config.ProxyTo)
+	}
+	lobby := event.Config
+
+	survival := newService("survival", map[string]string{
+		"infrared.domain":         "survival.example.com",
+		"infrared.port":           "minecraft",
+		"infrared.autostart":      "true",
+		"infrared.scale":          "StatefulSet/survival-server",
+		"infrared.docker.timeout": "600000",
+	})
+	api.sendEvent("MODIFIED", newService("lobby", map[string]string{
+		"infrared.domain":              "lobby.example.com",
+		"infrared.offline.motd":        "Lobby is offline ${env:INFRARED_TEST_SECRET}",
+		"infrared.docker.exec.command": "sh",
+	}))
+
+	event = receive(t)
+	if event.Type != ConfigUpdated || event.ID != "kubernetes://minecraft/lobby" || event.Config != lobby ||
+		lobby.OfflineStatus.MOTD != "Lobby is offline ${env:INFRARED_TEST_SECRET}" {
+		t.Errorf("lobby was not updated: %s %s %s", event.ID, event.Type, lobby.OfflineStatus.MOTD)
+	}
+
+	if len(lobby.Docker.Exec.Command) > 0 {
+		t.Errorf("expected the exec annotation to be skipped, got %v", lobby.Docker.Exec.Command)
+	}
+
+	api.sendEvent("ADDED", survival)
+
+	event = receive(t)
+	k8s := event.Config.Docker.Kubernetes
+	if event.Type != ConfigAdded || event.ID != "kubernetes://minecraft/survival" || k8s.StatefulSet != "survival-server" ||
+		k8s.Namespace != "minecraft" || k8s.APIServer != server.URL || event.Config.Docker.Timeout != 600000 {
+		t.Errorf("unexpected survival config %s %s %+v", event.ID, event.Type, event.Config.Docker)
+	}
+
+	api.sendEvent("DELETED", newService("lobby", nil))
+
+	event = receive(t)
+	if event.Type != ConfigRemoved || event.ID != "kubernetes://minecraft/lobby" {
+		t.Errorf("expected lobby to be removed, got %s %s", event.ID, event.Type)
+	}
+
+	if lists := api.listCount(); lists != 1 {
+		t.Errorf("expected the Services to be listed once, got %d lists", lists)
+	}
+
+	// An expired resource version lists the Services again
+	api.setServices()
+	api.events <- kubernetes.WatchEvent{Type: "ERROR", Object: json.RawMessage(`{"code": 410, "message": "too old resource version"}`)}
+
+	event = receive(t)
+	if event.Type != ConfigRemoved || event.ID != "kubernetes://minecraft/survival" {
+		t.Errorf("expected survival to be removed, got %s %s", event.ID, event.Type)
+	}
+
+	if lists := api.listCount(); lists != 2 {
+		t.Errorf("expected the Services to be listed again, got %d lists", lists)
+	}
+}
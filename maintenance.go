@@ -0,0 +1,93 @@
+package infrared
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultMaintenanceMessage     = "The server is currently under maintenance."
+	DefaultMaintenanceVersionName = "Maintenance"
+	DefaultMaintenanceMOTD        = "Under maintenance"
+
+	// maintenanceProtocolNumber matches no client version,
+	// so clients show the version name in red instead of the player count
+	maintenanceProtocolNumber = -1
+
+	LoginDeniedReasonMaintenance = "maintenance"
+)
+
+var ErrMaintenance = errors.New("under maintenance")
+
+// MaintenanceConfig is a data representation of the maintenance mode of a proxy
+type MaintenanceConfig struct {
+	// Enabled turns the maintenance mode on. If Start or End are set, it is only on between them.
+	Enabled bool       `json:"enabled"`
+	Start   *time.Time `json:"start"`
+	End     *time.Time `json:"end"`
+	// Message is sent to players that try to log in
+	Message string `json:"message"`
+	// Status is the response to status requests. The protocol number
+	// defaults to one that makes clients show the version name in red.
+	Status StatusConfig `json:"status"`
+	// BypassUsernames and BypassIPs are still forwarded to the server
+	BypassUsernames []string `json:"bypassUsernames"`
+	BypassIPs       []string `json:"bypassIps"`
+}
+
+// IsActive checks if the maintenance mode is on at the given time
+func (cfg MaintenanceConfig) IsActive(now time.Time) bool {
+	if !cfg.Enabled {
+		return false
+	}
+
+	if cfg.Start != nil && now.Before(*cfg.Start) {
+		return false
+	}
+
+	return cfg.End == nil || now.Before(*cfg.End)
+}
+
+func (cfg MaintenanceConfig) canBypassIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	ipNets, err := parseCIDRList(cfg.BypassIPs)
+	if err != nil {
+		return false
+	}
+	return containsIP(ipNets, ip)
+}
+
+func (cfg MaintenanceConfig) canBypass(username string, ip net.IP) bool {
+	for _, bypassUsername := range cfg.BypassUsernames {
+		if strings.EqualFold(bypassUsername, username) {
+			return true
+		}
+	}
+	return cfg.canBypassIP(ip)
+}
+
+func (cfg MaintenanceConfig) message() string {
+	if cfg.Message != "" {
+		return cfg.Message
+	}
+	return DefaultMaintenanceMessage
+}
+
+func (cfg MaintenanceConfig) statusConfig() StatusConfig {
+	statusCfg := cfg.Status
+	if statusCfg.VersionName == "" {
+		statusCfg.VersionName = DefaultMaintenanceVersionName
+	}
+	if statusCfg.ProtocolNumber == 0 {
+		statusCfg.ProtocolNumber = maintenanceProtocolNumber
+	}
+	if statusCfg.MOTD == "" {
+		statusCfg.MOTD = DefaultMaintenanceMOTD
+	}
+	return statusCfg
+}
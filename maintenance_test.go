@@ -0,0 +1,132 @@
+package infrared
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceConfig_IsActive(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	before := now.Add(-time.Hour)
+	after := now.Add(time.Hour)
+
+	tt := []struct {
+		name   string
+		cfg    MaintenanceConfig
+		active bool
+	}{
+		{
+			name:   "Disabled",
+			cfg:    MaintenanceConfig{},
+			active: false,
+		},
+		{
+			name:   "Enabled",
+			cfg:    MaintenanceConfig{Enabled: true},
+			active: true,
+		},
+		{
+			name:   "DisabledWithSchedule",
+			cfg:    MaintenanceConfig{Start: &before, End: &after},
+			active: false,
+		},
+		{
+			name:   "Started",
+			cfg:    MaintenanceConfig{Enabled: true, Start: &before, End: &after},
+			active: true,
+		},
+		{
+			name:   "NotStarted",
+			cfg:    MaintenanceConfig{Enabled: true, Start: &after},
+			active: false,
+		},
+		{
+			name:   "Ended",
+			cfg:    MaintenanceConfig{Enabled: true, End: &before},
+			active: false,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.cfg.IsActive(now) != tc.active {
+				t.Errorf("got: %v; want: %v", !tc.active, tc.active)
+			}
+		})
+	}
+}
+
+func TestMaintenanceConfig_CanBypass(t *testing.T) {
+	cfg := MaintenanceConfig{
+		BypassUsernames: []string{"Notch"},
+		BypassIPs:       []string{"10.0.0.0/8"},
+	}
+
+	if !cfg.canBypass("notch", net.ParseIP("127.0.0.1")) {
+		t.Error("username should bypass")
+	}
+
+	if !cfg.canBypass("Steve", net.ParseIP("10.1.2.3")) {
+		t.Error("ip should bypass")
+	}
+
+	if cfg.canBypass("Steve", net.ParseIP("127.0.0.1")) {
+		t.Error("player should not bypass")
+	}
+}
+
+func TestMaintenanceConfig_StatusConfig(t *testing.T) {
+	statusCfg := MaintenanceConfig{Status: StatusConfig{MOTD: "Back soon"}}.statusConfig()
+
+	if statusCfg.ProtocolNumber != maintenanceProtocolNumber {
+		t.Errorf("got: %v; want: %v", statusCfg.ProtocolNumber, maintenanceProtocolNumber)
+	}
+
+	if statusCfg.VersionName != DefaultMaintenanceVersionName {
+		t.Errorf("got: %v; want: %v", statusCfg.VersionName, DefaultMaintenanceVersionName)
+	}
+
+	if statusCfg.MOTD != "Back soon" {
+		t.Errorf("got: %v; want: %v", statusCfg.MOTD, "Back soon")
+	}
+}
+
+func TestProxy_MaintenanceStatusPacket(t *testing.T) {
+	iconPath := filepath.Join(t.TempDir(), "icon.png")
+	if err := ioutil.WriteFile(iconPath, []byte("icon"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := &Proxy{Config: &ProxyConfig{
+		Maintenance: MaintenanceConfig{Status: StatusConfig{IconPath: iconPath}},
+	}}
+
+	pk, err := proxy.MaintenanceStatusPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The icon is only read for the first packet
+	if err := os.Remove(iconPath); err != nil {
+		t.Fatal(err)
+	}
+
+	cachedPk, err := proxy.MaintenanceStatusPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(cachedPk.Data, pk.Data) {
+		t.Error("maintenance status packet should be cached")
+	}
+
+	proxy.Config.resetCaches()
+	if _, err := proxy.MaintenanceStatusPacket(); err == nil {
+		t.Error("reset cache should read the icon again")
+	}
+}
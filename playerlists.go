@@ -0,0 +1,240 @@
+package infrared
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// playerListTimeLayout is the time format of the vanilla ban lists
+	playerListTimeLayout = "2006-01-02 15:04:05 -0700"
+
+	defaultWhitelistMessage = "You are not white-listed on this server!"
+	defaultBanMessage       = "You are banned from this server.\nReason: {{reason}}"
+
+	PlayerDeniedReasonWhitelist = "whitelist"
+	PlayerDeniedReasonBan       = "ban"
+	PlayerDeniedReasonIPBan     = "ipBan"
+)
+
+var ErrPlayerDenied = errors.New("player denied")
+
+// PlayerListEntry is an entry of a whitelist or ban list.
+// It uses the same format as the vanilla whitelist.json,
+// banned-players.json and banned-ips.json files.
+type PlayerListEntry struct {
+	UUID    string `json:"uuid,omitempty"`
+	Name    string `json:"name,omitempty"`
+	IP      string `json:"ip,omitempty"`
+	Created string `json:"created,omitempty"`
+	Source  string `json:"source,omitempty"`
+	Expires string `json:"expires,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// IsExpired checks if a ban is expired. Bans without a valid
+// expiry date, like "forever", never expire.
+func (entry PlayerListEntry) IsExpired(now time.Time) bool {
+	if entry.Expires == "" || entry.Expires == "forever" {
+		return false
+	}
+
+	expires, err := time.Parse(playerListTimeLayout, entry.Expires)
+	if err != nil {
+		expires, err = time.Parse(time.RFC3339, entry.Expires)
+		if err != nil {
+			return false
+		}
+	}
+
+	return now.After(expires)
+}
+
+// matchesPlayer checks the name and the offline mode UUID of the player
+func (entry PlayerListEntry) matchesPlayer(username string) bool {
+	if entry.Name != "" && strings.EqualFold(entry.Name, username) {
+		return true
+	}
+
+	return entry.UUID != "" && strings.EqualFold(entry.UUID, offlinePlayerUUID(username))
+}
+
+func (entry PlayerListEntry) matchesIP(ip net.IP) bool {
+	if entry.IP == "" || ip == nil {
+		return false
+	}
+
+	ipNets, err := ParseCIDRs(entry.IP)
+	if err != nil {
+		return false
+	}
+	return containsIP(ipNets, ip)
+}
+
+// PlayerListsConfig is a data representation of the player lists of a proxy.
+// Entries can be inlined or loaded from files in the vanilla format.
+type PlayerListsConfig struct {
+	Whitelist         []PlayerListEntry `json:"whitelist"`
+	WhitelistFile     string            `json:"whitelistFile"`
+	BannedPlayers     []PlayerListEntry `json:"bannedPlayers"`
+	BannedPlayersFile string            `json:"bannedPlayersFile"`
+	BannedIPs         []PlayerListEntry `json:"bannedIps"`
+	BannedIPsFile     string            `json:"bannedIpsFile"`
+	WhitelistMessage  string            `json:"whitelistMessage"`
+	BanMessage        string            `json:"banMessage"`
+}
+
+func (cfg PlayerListsConfig) hasWhitelist() bool {
+	return len(cfg.Whitelist) > 0 || cfg.WhitelistFile != ""
+}
+
+// playerDenial describes why a player is not allowed to join
+type playerDenial struct {
+	Reason  string
+	Message string
+	Entry   PlayerListEntry
+}
+
+// playerLists checks players against the lists of a config
+// and caches the list files until they change
+type playerLists struct {
+	mu    sync.Mutex
+	files map[string]playerListFile
+}
+
+type playerListFile struct {
+	modTime time.Time
+	size    int64
+	entries []PlayerListEntry
+}
+
+// check returns a denial if the player is banned or not whitelisted
+func (lists *playerLists) check(cfg PlayerListsConfig, username string, ip net.IP, now time.Time) (*playerDenial, error) {
+	banMessage := cfg.BanMessage
+	if banMessage == "" {
+		banMessage = defaultBanMessage
+	}
+
+	bannedIPs, err := lists.entries(cfg.BannedIPs, cfg.BannedIPsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range bannedIPs {
+		if entry.matchesIP(ip) && !entry.IsExpired(now) {
+			return &playerDenial{
+				Reason:  PlayerDeniedReasonIPBan,
+				Message: banMessage,
+				Entry:   entry,
+			}, nil
+		}
+	}
+
+	bannedPlayers, err := lists.entries(cfg.BannedPlayers, cfg.BannedPlayersFile)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range bannedPlayers {
+		if entry.matchesPlayer(username) && !entry.IsExpired(now) {
+			return &playerDenial{
+				Reason:  PlayerDeniedReasonBan,
+				Message: banMessage,
+				Entry:   entry,
+			}, nil
+		}
+	}
+
+	if !cfg.hasWhitelist() {
+		return nil, nil
+	}
+
+	whitelist, err := lists.entries(cfg.Whitelist, cfg.WhitelistFile)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range whitelist {
+		if entry.matchesPlayer(username) {
+			return nil, nil
+		}
+	}
+
+	whitelistMessage := cfg.WhitelistMessage
+	if whitelistMessage == "" {
+		whitelistMessage = defaultWhitelistMessage
+	}
+
+	return &playerDenial{
+		Reason:  PlayerDeniedReasonWhitelist,
+		Message: whitelistMessage,
+	}, nil
+}
+
+// entries merges the inline entries with the entries of the file
+func (lists *playerLists) entries(inline []PlayerListEntry, path string) ([]PlayerListEntry, error) {
+	if path == "" {
+		return inline, nil
+	}
+
+	fileEntries, err := lists.loadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]PlayerListEntry, 0, len(inline)+len(fileEntries))
+	entries = append(entries, inline...)
+	return append(entries, fileEntries...), nil
+}
+
+func (lists *playerLists) loadFile(path string) ([]PlayerListEntry, error) {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lists.mu.Lock()
+	defer lists.mu.Unlock()
+
+	if lists.files == nil {
+		lists.files = map[string]playerListFile{}
+	}
+
+	file, ok := lists.files[path]
+	if ok && file.modTime.Equal(fileInfo.ModTime()) && file.size == fileInfo.Size() {
+		return file.entries, nil
+	}
+
+	bb, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []PlayerListEntry
+	if err := json.Unmarshal(bb, &entries); err != nil {
+		return nil, fmt.Errorf("invalid player list %s; %s", path, err)
+	}
+
+	lists.files[path] = playerListFile{
+		modTime: fileInfo.ModTime(),
+		size:    fileInfo.Size(),
+		entries: entries,
+	}
+	return entries, nil
+}
+
+// offlinePlayerUUID returns the UUID that offline mode servers assign to a username
+func offlinePlayerUUID(username string) string {
+	hash := md5.Sum([]byte("OfflinePlayer:" + username))
+	hash[6] = hash[6]&0x0f | 0x30
+	hash[8] = hash[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", hash[0:4], hash[4:6], hash[6:8], hash[8:10], hash[10:16])
+}
@@ -0,0 +1,207 @@
+package infrared
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOfflinePlayerUUID(t *testing.T) {
+	if uuid := offlinePlayerUUID("Notch"); uuid != "b50ad385-829d-3141-a216-7e7d7539ba7f" {
+		t.Errorf("got: %v; want: %v", uuid, "b50ad385-829d-3141-a216-7e7d7539ba7f")
+	}
+}
+
+func TestPlayerListEntry_IsExpired(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tt := []struct {
+		expires string
+		expired bool
+	}{
+		{
+			expires: "",
+			expired: false,
+		},
+		{
+			expires: "forever",
+			expired: false,
+		},
+		{
+			expires: "2021-05-01 12:00:00 +0000",
+			expired: true,
+		},
+		{
+			expires: "2021-07-01 12:00:00 +0200",
+			expired: false,
+		},
+		{
+			expires: "2021-05-01T12:00:00Z",
+			expired: true,
+		},
+		{
+			expires: "someday",
+			expired: false,
+		},
+	}
+
+	for _, tc := range tt {
+		entry := PlayerListEntry{Expires: tc.expires}
+		if entry.IsExpired(now) != tc.expired {
+			t.Errorf("%q: got: %v; want: %v", tc.expires, !tc.expired, tc.expired)
+		}
+	}
+}
+
+func TestPlayerLists_Check(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+
+	whitelistFile := filepath.Join(dir, "whitelist.json")
+	if err := ioutil.WriteFile(whitelistFile, []byte(`[{"uuid": "b50ad385-829d-3141-a216-7e7d7539ba7f", "name": "Notch"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	bannedPlayersFile := filepath.Join(dir, "banned-players.json")
+	if err := ioutil.WriteFile(bannedPlayersFile, []byte(`[{"name": "Herobrine", "created": "2021-01-01 12:00:00 +0000", "source": "Server", "expires": "forever", "reason": "Haunting"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	bannedIPsFile := filepath.Join(dir, "banned-ips.json")
+	if err := ioutil.WriteFile(bannedIPsFile, []byte(`[{"ip": "109.226.143.210", "expires": "forever", "reason": "Spam"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ip := net.ParseIP("127.0.0.1")
+
+	tt := []struct {
+		name     string
+		cfg      PlayerListsConfig
+		username string
+		ip       net.IP
+		expected string
+	}{
+		{
+			name:     "NoLists",
+			cfg:      PlayerListsConfig{},
+			username: "Steve",
+			ip:       ip,
+			expected: "",
+		},
+		{
+			name: "Whitelisted",
+			cfg: PlayerListsConfig{
+				Whitelist: []PlayerListEntry{{Name: "steve"}},
+			},
+			username: "Steve",
+			ip:       ip,
+			expected: "",
+		},
+		{
+			name: "NotWhitelisted",
+			cfg: PlayerListsConfig{
+				Whitelist: []PlayerListEntry{{Name: "Alex"}},
+			},
+			username: "Steve",
+			ip:       ip,
+			expected: PlayerDeniedReasonWhitelist,
+		},
+		{
+			name: "WhitelistedByUUIDFromFile",
+			cfg: PlayerListsConfig{
+				WhitelistFile: whitelistFile,
+			},
+			username: "Notch",
+			ip:       ip,
+			expected: "",
+		},
+		{
+			name: "BannedFromFile",
+			cfg: PlayerListsConfig{
+				BannedPlayersFile: bannedPlayersFile,
+			},
+			username: "Herobrine",
+			ip:       ip,
+			expected: PlayerDeniedReasonBan,
+		},
+		{
+			name: "BanExpired",
+			cfg: PlayerListsConfig{
+				BannedPlayers: []PlayerListEntry{{Name: "Steve", Expires: "2021-05-01 12:00:00 +0000"}},
+			},
+			username: "Steve",
+			ip:       ip,
+			expected: "",
+		},
+		{
+			name: "BanBeforeWhitelist",
+			cfg: PlayerListsConfig{
+				Whitelist:     []PlayerListEntry{{Name: "Steve"}},
+				BannedPlayers: []PlayerListEntry{{Name: "Steve"}},
+			},
+			username: "Steve",
+			ip:       ip,
+			expected: PlayerDeniedReasonBan,
+		},
+		{
+			name: "IPBannedFromFile",
+			cfg: PlayerListsConfig{
+				BannedIPsFile: bannedIPsFile,
+			},
+			username: "Steve",
+			ip:       net.ParseIP("109.226.143.210"),
+			expected: PlayerDeniedReasonIPBan,
+		},
+		{
+			name: "IPBannedByCIDR",
+			cfg: PlayerListsConfig{
+				BannedIPs: []PlayerListEntry{{IP: "109.226.143.0/24"}},
+			},
+			username: "Steve",
+			ip:       net.ParseIP("109.226.143.210"),
+			expected: PlayerDeniedReasonIPBan,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			lists := playerLists{}
+			denial, err := lists.check(tc.cfg, tc.username, tc.ip, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			reason := ""
+			if denial != nil {
+				reason = denial.Reason
+			}
+
+			if reason != tc.expected {
+				t.Errorf("got: %q; want: %q", reason, tc.expected)
+			}
+		})
+	}
+}
+
+func TestPlayerLists_ReloadsChangedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "banned-players.json")
+	if err := ioutil.WriteFile(path, []byte(`[]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := PlayerListsConfig{BannedPlayersFile: path}
+	lists := playerLists{}
+	if denial, err := lists.check(cfg, "Steve", nil, time.Now()); err != nil || denial != nil {
+		t.Fatalf("got: %v, %v; want no denial", denial, err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`[{"name": "Steve"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if denial, err := lists.check(cfg, "Steve", nil, time.Now()); err != nil || denial == nil {
+		t.Fatalf("got: %v, %v; want a denial", denial, err)
+	}
+}
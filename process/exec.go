@@ -0,0 +1,268 @@
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultExecGracePeriod is the time that a command has to exit after it was asked to stop
+const DefaultExecGracePeriod = 30 * time.Second
+
+var (
+	signals = map[string]os.Signal{
+		"SIGHUP":  syscall.SIGHUP,
+		"SIGINT":  syscall.SIGINT,
+		"SIGQUIT": syscall.SIGQUIT,
+		"SIGKILL": syscall.SIGKILL,
+		"SIGTERM": syscall.SIGTERM,
+	}
+
+	// execProcesses are the supervised commands by their command line and directory,
+	// so that reloaded configs keep supervising the command that they started
+	execProcessesMu sync.Mutex
+	execProcesses   = map[string]*execProcess{}
+)
+
+// ExecConfig is a command that runs on the host of Infrared
+type ExecConfig struct {
+	// Command is the program and its arguments like ["java", "-jar", "server.jar", "nogui"]
+	Command []string
+	Dir     string
+	// Env are variables like KEY=value that are added to the environment of Infrared
+	Env  []string
+	Stop ExecStopConfig
+	// LogPath is the file that stdout and stderr are written to. The output is discarded if it is empty.
+	LogPath string
+	// LogMaxSize is the size in bytes after which the log is rotated. Zero disables rotation.
+	LogMaxSize int64
+	// LogMaxBackups is the number of rotated logs that are kept; at least one
+	LogMaxBackups int
+}
+
+// ExecStopConfig is the way a command is stopped. Only one of Stdin, Signal and Command can be set.
+// If none of them is set, the command gets SIGTERM.
+type ExecStopConfig struct {
+	// Stdin is written to the stdin of the command followed by a line break, like "stop" for Minecraft servers
+	Stdin string
+	// Signal is the name of a signal like SIGTERM or SIGINT
+	Signal string
+	// Command is run to stop the command
+	Command []string
+	// GracePeriod is the time that the command has to exit before it is killed.
+	// It defaults to DefaultExecGracePeriod.
+	GracePeriod time.Duration
+}
+
+// ParseSignal returns the signal with a name like SIGTERM or TERM
+func ParseSignal(name string) (os.Signal, error) {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+
+	signal, ok := signals[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown signal %s", name)
+	}
+	return signal, nil
+}
+
+type execProcess struct {
+	mu  sync.Mutex
+	cfg ExecConfig
+	cmd *exec.Cmd
+	// stdin is the pipe to the stdin of cmd
+	stdin io.WriteCloser
+	// exited is closed once cmd exited
+	exited chan struct{}
+}
+
+// NewExec creates a new process that runs a command and supervises it.
+// Processes with the same command line and directory share the running command.
+func NewExec(cfg ExecConfig) (Process, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	key := strings.Join(cfg.Command, "\x00") + "\x00" + cfg.Dir
+
+	execProcessesMu.Lock()
+	defer execProcessesMu.Unlock()
+
+	proc, ok := execProcesses[key]
+	if !ok {
+		proc = &execProcess{}
+		execProcesses[key] = proc
+	}
+
+	proc.mu.Lock()
+	proc.cfg = cfg
+	proc.mu.Unlock()
+	return proc, nil
+}
+
+// Validate checks that the config has a command and at most one stop strategy with a known signal
+func (cfg ExecConfig) Validate() error {
+	if len(cfg.Command) == 0 {
+		return errors.New("command is empty")
+	}
+
+	stop := cfg.Stop
+	strategies := 0
+	if stop.Stdin != "" {
+		strategies++
+	}
+	if stop.Signal != "" {
+		strategies++
+		if _, err := ParseSignal(stop.Signal); err != nil {
+			return err
+		}
+	}
+	if len(stop.Command) > 0 {
+		strategies++
+	}
+
+	if strategies > 1 {
+		return errors.New("only one of stdin, signal and command can stop the process")
+	}
+	return nil
+}
+
+func (proc *execProcess) Start() error {
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+
+	if proc.cmd != nil {
+		return nil
+	}
+
+	cfg := proc.cfg
+	var output io.WriteCloser = nopWriteCloser{ioutil.Discard}
+	if cfg.LogPath != "" {
+		logFile, err := openRotatingFile(cfg.LogPath, cfg.LogMaxSize, cfg.LogMaxBackups)
+		if err != nil {
+			return err
+		}
+		output = logFile
+	}
+
+	cmd := exec.Command(cfg.Command[0], cfg.Command[1:]...)
+	cmd.Dir = cfg.Dir
+	cmd.Env = append(os.Environ(), cfg.Env...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		output.Close()
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		output.Close()
+		return err
+	}
+
+	exited := make(chan struct{})
+	proc.cmd = cmd
+	proc.stdin = stdin
+	proc.exited = exited
+
+	go func() {
+		err := cmd.Wait()
+		output.Close()
+		if err != nil {
+			log.Printf("[i] Command %s exited; error: %s", cfg.Command[0], err)
+		} else {
+			log.Printf("[i] Command %s exited", cfg.Command[0])
+		}
+
+		proc.mu.Lock()
+		if proc.cmd == cmd {
+			proc.cmd = nil
+			proc.stdin = nil
+		}
+		proc.mu.Unlock()
+		close(exited)
+	}()
+	return nil
+}
+
+// Stop asks the command to exit with the stop strategy and kills it after the grace period
+func (proc *execProcess) Stop() error {
+	proc.mu.Lock()
+	cmd, stdin, exited, stop := proc.cmd, proc.stdin, proc.exited, proc.cfg.Stop
+	dir, env := proc.cfg.Dir, proc.cfg.Env
+	proc.mu.Unlock()
+
+	if cmd == nil {
+		return nil
+	}
+
+	gracePeriod := stop.GracePeriod
+	if gracePeriod <= 0 {
+		gracePeriod = DefaultExecGracePeriod
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+
+	var err error
+	switch {
+	case stop.Stdin != "":
+		_, err = io.WriteString(stdin, stop.Stdin+"\n")
+	case len(stop.Command) > 0:
+		stopCmd := exec.CommandContext(ctx, stop.Command[0], stop.Command[1:]...)
+		stopCmd.Dir = dir
+		stopCmd.Env = append(os.Environ(), env...)
+		err = stopCmd.Run()
+	default:
+		signal := os.Signal(syscall.SIGTERM)
+		if stop.Signal != "" {
+			signal, _ = ParseSignal(stop.Signal)
+		}
+		err = cmd.Process.Signal(signal)
+	}
+
+	if err != nil {
+		log.Printf("[w] Failed stopping command %s; error: %s", cmd.Path, err)
+	}
+
+	select {
+	case <-exited:
+		return nil
+	case <-ctx.Done():
+	}
+
+	log.Printf("[w] Command %s did not exit within %s; killing it", cmd.Path, gracePeriod)
+	if err := cmd.Process.Kill(); err != nil {
+		return err
+	}
+	<-exited
+	return nil
+}
+
+// IsRunning reports whether the command was started and did not exit yet
+func (proc *execProcess) IsRunning() (bool, error) {
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+	return proc.cmd != nil, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
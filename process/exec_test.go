@@ -0,0 +1,202 @@
+package process
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestExec(t *testing.T, cfg ExecConfig) Process {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	proc, err := NewExec(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proc
+}
+
+func assertRunning(t *testing.T, proc Process, expected bool) {
+	running, err := proc.IsRunning()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if running != expected {
+		t.Fatalf("expected running to be %t", expected)
+	}
+}
+
+func TestExec(t *testing.T) {
+	tt := []struct {
+		name string
+		stop ExecStopConfig
+		// script is run by sh
+		script string
+		log    string
+	}{
+		{
+			name:   "Stdin",
+			stop:   ExecStopConfig{Stdin: "stop"},
+			script: `echo started; read line; echo "got $line"`,
+			log:    "started\ngot stop\n",
+		},
+		{
+			name:   "Signal",
+			stop:   ExecStopConfig{Signal: "INT"},
+			script: `trap 'echo interrupted; exit' INT; echo started; while true; do sleep 0.01; done`,
+			log:    "started\ninterrupted\n",
+		},
+		{
+			name:   "Command",
+			stop:   ExecStopConfig{Command: []string{"touch", "stop"}},
+			script: `echo started; while [ ! -f stop ]; do sleep 0.01; done; echo stopped`,
+			log:    "started\nstopped\n",
+		},
+		{
+			name:   "Kill",
+			stop:   ExecStopConfig{GracePeriod: 50 * time.Millisecond},
+			script: `trap '' TERM; echo started; while true; do sleep 0.01; done`,
+			log:    "started\n",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			logPath := filepath.Join(dir, "logs", "server.log")
+
+			proc := newTestExec(t, ExecConfig{
+				Command: []string{"sh", "-c", tc.script},
+				Dir:     dir,
+				Stop:    tc.stop,
+				LogPath: logPath,
+			})
+
+			assertRunning(t, proc, false)
+			if err := proc.Start(); err != nil {
+				t.Fatal(err)
+			}
+			assertRunning(t, proc, true)
+
+			// Wait for the script to set up its traps
+			for i := 0; i < 100; i++ {
+				if bb, _ := ioutil.ReadFile(logPath); len(bb) > 0 {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			if err := proc.Stop(); err != nil {
+				t.Fatal(err)
+			}
+			assertRunning(t, proc, false)
+
+			bb, err := ioutil.ReadFile(logPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(bb) != tc.log {
+				t.Errorf("expected log %q, got %q", tc.log, bb)
+			}
+		})
+	}
+}
+
+func TestExec_Exit(t *testing.T) {
+	proc := newTestExec(t, ExecConfig{
+		Command: []string{"sh", "-c", "exit 1"},
+	})
+
+	if err := proc.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		if running, _ := proc.IsRunning(); !running {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("exited command is still running")
+}
+
+func TestExec_Shared(t *testing.T) {
+	cfg := ExecConfig{Command: []string{"sh", "-c", "exec sleep 10"}}
+	proc := newTestExec(t, cfg)
+	if err := proc.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Stop()
+
+	// A reloaded config supervises the same command
+	reloaded := newTestExec(t, cfg)
+	assertRunning(t, reloaded, true)
+}
+
+func TestExecConfig_Validate(t *testing.T) {
+	tt := []struct {
+		cfg ExecConfig
+		err string
+	}{
+		{
+			cfg: ExecConfig{},
+			err: "command is empty",
+		},
+		{
+			cfg: ExecConfig{Command: []string{"java"}, Stop: ExecStopConfig{Signal: "SIGNOPE"}},
+			err: "unknown signal SIGNOPE",
+		},
+		{
+			cfg: ExecConfig{Command: []string{"java"}, Stop: ExecStopConfig{Stdin: "stop", Signal: "SIGTERM"}},
+			err: "only one of stdin, signal and command can stop the process",
+		},
+		{
+			cfg: ExecConfig{Command: []string{"java"}, Stop: ExecStopConfig{Signal: "term"}},
+		},
+	}
+
+	for _, tc := range tt {
+		err := tc.cfg.Validate()
+		if tc.err == "" && err != nil || tc.err != "" && (err == nil || err.Error() != tc.err) {
+			t.Errorf("expected error %q, got %v", tc.err, err)
+		}
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.log")
+	file, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := file.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := map[string]string{
+		"server.log":   "fourth\n",
+		"server.log.1": "third\n",
+		"server.log.2": "second\n",
+	}
+	files, _ := filepath.Glob(path + "*")
+	if len(files) != len(expected) {
+		t.Errorf("expected %d files, got %v", len(expected), files)
+	}
+
+	for name, content := range expected {
+		bb, _ := ioutil.ReadFile(filepath.Join(filepath.Dir(path), name))
+		if string(bb) != content {
+			t.Errorf("expected %s to be %q, got %q", name, content, bb)
+		}
+	}
+}
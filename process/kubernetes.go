@@ -0,0 +1,53 @@
+package process
+
+import (
+	"context"
+
+	"github.com/haveachin/infrared/kubernetes"
+)
+
+type kubernetesScale struct {
+	client    *kubernetes.Client
+	namespace string
+	resource  string
+	name      string
+}
+
+// NewKubernetes creates a new process that scales a workload between zero and one replica.
+// The resource is the plural of the kind of the workload like deployments or statefulsets.
+func NewKubernetes(client *kubernetes.Client, namespace, resource, name string) Process {
+	return kubernetesScale{
+		client:    client,
+		namespace: namespace,
+		resource:  resource,
+		name:      name,
+	}
+}
+
+func (proc kubernetesScale) Start() error {
+	ctx, cancel := context.WithTimeout(context.Background(), contextTimeout)
+	defer cancel()
+
+	return proc.client.SetScale(ctx, proc.namespace, proc.resource, proc.name, 1)
+}
+
+func (proc kubernetesScale) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), contextTimeout)
+	defer cancel()
+
+	return proc.client.SetScale(ctx, proc.namespace, proc.resource, proc.name, 0)
+}
+
+// IsRunning reports whether the workload should have replicas,
+// so that a workload that is scaled up but not ready yet is not scaled up again
+func (proc kubernetesScale) IsRunning() (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), contextTimeout)
+	defer cancel()
+
+	scale, err := proc.client.GetScale(ctx, proc.namespace, proc.resource, proc.name)
+	if err != nil {
+		return false, err
+	}
+
+	return scale.Spec.Replicas > 0, nil
+}
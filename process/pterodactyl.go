@@ -0,0 +1,178 @@
+package process
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The states of a server in the Pterodactyl panel
+const (
+	PterodactylStarting = "starting"
+	PterodactylRunning  = "running"
+	PterodactylStopping = "stopping"
+	PterodactylOffline  = "offline"
+)
+
+// pterodactylPollInterval is the time between the state checks while a server is stopping
+const pterodactylPollInterval = time.Second
+
+type pterodactyl struct {
+	client       *http.Client
+	url          string
+	serverID     string
+	apiKey       string
+	pollInterval time.Duration
+}
+
+// NewPterodactyl creates a new process that manages a server of a Pterodactyl panel with its client API
+func NewPterodactyl(url, serverID, apiKey string) Process {
+	return pterodactyl{
+		client:       &http.Client{},
+		url:          strings.TrimSuffix(url, "/"),
+		serverID:     serverID,
+		apiKey:       apiKey,
+		pollInterval: pterodactylPollInterval,
+	}
+}
+
+// Start sends the start signal unless the server is already starting or running.
+// A stopping server is started once it is offline.
+func (proc pterodactyl) Start() error {
+	ctx, cancel := context.WithTimeout(context.Background(), contextTimeout)
+	defer cancel()
+
+	for {
+		state, err := proc.state(ctx)
+		if err != nil {
+			return err
+		}
+
+		switch state {
+		case PterodactylStarting, PterodactylRunning:
+			return nil
+		case PterodactylOffline:
+			return proc.power(ctx, "start")
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("server %s is still %s", proc.serverID, state)
+		case <-time.After(proc.pollInterval):
+		}
+	}
+}
+
+// Stop sends the stop signal unless the server is already stopping or offline
+func (proc pterodactyl) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), contextTimeout)
+	defer cancel()
+
+	state, err := proc.state(ctx)
+	if err != nil {
+		return err
+	}
+
+	if state == PterodactylStopping || state == PterodactylOffline {
+		return nil
+	}
+	return proc.power(ctx, "stop")
+}
+
+// IsRunning reports whether the server is starting or running
+func (proc pterodactyl) IsRunning() (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), contextTimeout)
+	defer cancel()
+
+	state, err := proc.state(ctx)
+	if err != nil {
+		return false, err
+	}
+	return state == PterodactylStarting || state == PterodactylRunning, nil
+}
+
+func (proc pterodactyl) state(ctx context.Context) (string, error) {
+	var resources struct {
+		Attributes struct {
+			CurrentState string `json:"current_state"`
+		} `json:"attributes"`
+	}
+
+	if err := proc.do(ctx, http.MethodGet, "resources", nil, &resources); err != nil {
+		return "", err
+	}
+
+	switch state := resources.Attributes.CurrentState; state {
+	case PterodactylStarting, PterodactylRunning, PterodactylStopping, PterodactylOffline:
+		return state, nil
+	default:
+		return "", fmt.Errorf("unknown state %q of server %s", state, proc.serverID)
+	}
+}
+
+func (proc pterodactyl) power(ctx context.Context, signal string) error {
+	return proc.do(ctx, http.MethodPost, "power", map[string]string{"signal": signal}, nil)
+}
+
+// do calls an endpoint of the server with the client API and decodes the response into out
+func (proc pterodactyl) do(ctx context.Context, method, endpoint string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+
+	url := fmt.Sprintf("%s/api/client/servers/%s/%s", proc.url, proc.serverID, endpoint)
+	req, err := http.NewRequestWithContext(ctx, method, url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+proc.apiKey)
+	req.Header.Set("Accept", contentType)
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := proc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	bb, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return pterodactylError(resp, bb)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(bb, out)
+}
+
+// pterodactylError returns the details of the errors in a response of the panel
+func pterodactylError(resp *http.Response, bb []byte) error {
+	var errResp struct {
+		Errors []struct {
+			Detail string `json:"detail"`
+		} `json:"errors"`
+	}
+
+	if err := json.Unmarshal(bb, &errResp); err != nil || len(errResp.Errors) == 0 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	details := make([]string, len(errResp.Errors))
+	for i, e := range errResp.Errors {
+		details[i] = e.Detail
+	}
+	return fmt.Errorf("%s: %s", resp.Status, strings.Join(details, "; "))
+}
@@ -0,0 +1,179 @@
+package process
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePterodactylAPI is the client API of a Pterodactyl panel with one server
+type fakePterodactylAPI struct {
+	mu      sync.Mutex
+	state   string
+	signals []string
+	// next is the state after the current one, like offline after stopping
+	next string
+}
+
+func (api *fakePterodactylAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer ptlc_key" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"errors":[{"code":"AuthenticationException","status":"401","detail":"Unauthenticated."}]}`)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/client/servers/1a7ce997/resources":
+		fmt.Fprintf(w, `{"object":"stats","attributes":{"current_state":%q,"is_suspended":false}}`, api.state)
+		if api.next != "" {
+			api.state, api.next = api.next, ""
+		}
+	case r.Method == http.MethodPost && r.URL.Path == "/api/client/servers/1a7ce997/power":
+		var power struct {
+			Signal string `json:"signal"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&power); err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+
+		api.signals = append(api.signals, power.Signal)
+		switch power.Signal {
+		case "start":
+			api.state = PterodactylStarting
+		case "stop":
+			api.state = PterodactylStopping
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"errors":[{"code":"NotFoundHttpException","status":"404","detail":"The requested resource could not be found on the server."}]}`)
+	}
+}
+
+func (api *fakePterodactylAPI) setState(state, next string) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.state, api.next, api.signals = state, next, nil
+}
+
+func (api *fakePterodactylAPI) sentSignals() []string {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return api.signals
+}
+
+func newTestPterodactyl(url string) pterodactyl {
+	proc := NewPterodactyl(url+"/", "1a7ce997", "ptlc_key").(pterodactyl)
+	proc.pollInterval = time.Millisecond
+	return proc
+}
+
+func TestPterodactyl(t *testing.T) {
+	api := &fakePterodactylAPI{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	proc := newTestPterodactyl(server.URL)
+
+	tt := []struct {
+		state   string
+		next    string
+		running bool
+		start   []string
+		stop    []string
+	}{
+		{
+			state:   PterodactylOffline,
+			running: false,
+			start:   []string{"start"},
+		},
+		{
+			state:   PterodactylStarting,
+			running: true,
+			stop:    []string{"stop"},
+		},
+		{
+			state:   PterodactylRunning,
+			running: true,
+			stop:    []string{"stop"},
+		},
+		{
+			state:   PterodactylStopping,
+			next:    PterodactylOffline,
+			running: false,
+		},
+		{
+			// A stopping server is started once it is offline
+			state: PterodactylStopping,
+			next:  PterodactylOffline,
+			start: []string{"start"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.state, func(t *testing.T) {
+			api.setState(tc.state, tc.next)
+			if tc.next == "" || tc.start == nil {
+				assertRunning(t, proc, tc.running)
+			}
+
+			if tc.start == nil && tc.stop == nil {
+				return
+			}
+
+			action, expected := proc.Stop, tc.stop
+			if tc.start != nil {
+				action, expected = proc.Start, tc.start
+			}
+
+			if err := action(); err != nil {
+				t.Fatal(err)
+			}
+
+			if signals := api.sentSignals(); fmt.Sprint(signals) != fmt.Sprint(expected) {
+				t.Errorf("expected signals %v, got %v", expected, signals)
+			}
+		})
+	}
+
+	actions := map[string]func() error{
+		PterodactylStarting: proc.Start,
+		PterodactylRunning:  proc.Start,
+		PterodactylStopping: proc.Stop,
+		PterodactylOffline:  proc.Stop,
+	}
+	for state, action := range actions {
+		api.setState(state, "")
+		if err := action(); err != nil {
+			t.Fatal(err)
+		}
+
+		if signals := api.sentSignals(); len(signals) > 0 {
+			t.Errorf("%s server got signals %v", state, signals)
+		}
+	}
+}
+
+func TestPterodactyl_Errors(t *testing.T) {
+	api := &fakePterodactylAPI{state: "installing"}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	proc := newTestPterodactyl(server.URL)
+	if _, err := proc.IsRunning(); err == nil || err.Error() != `unknown state "installing" of server 1a7ce997` {
+		t.Errorf("expected unknown state error, got %v", err)
+	}
+
+	proc.apiKey = "wrong"
+	if _, err := proc.IsRunning(); err == nil || err.Error() != "401 Unauthorized: Unauthenticated." {
+		t.Errorf("expected unauthenticated error, got %v", err)
+	}
+}
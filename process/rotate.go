@@ -0,0 +1,109 @@
+package process
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// rotatingFile is a log file that is renamed to path.1 once it would exceed its maximum size.
+// Older logs are shifted to path.2 and so on, and the ones beyond the backups are removed.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	if maxBackups < 1 {
+		maxBackups = 1
+	}
+
+	file := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := file.open(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (file *rotatingFile) open() error {
+	f, err := os.OpenFile(file.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	file.file = f
+	file.size = info.Size()
+	return nil
+}
+
+func (file *rotatingFile) Write(p []byte) (int, error) {
+	file.mu.Lock()
+	defer file.mu.Unlock()
+
+	if file.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if file.maxSize > 0 && file.size > 0 && file.size+int64(len(p)) > file.maxSize {
+		if err := file.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := file.file.Write(p)
+	file.size += int64(n)
+	return n, err
+}
+
+func (file *rotatingFile) rotate() error {
+	if err := file.file.Close(); err != nil {
+		return err
+	}
+	file.file = nil
+
+	for i := file.maxBackups; i > 0; i-- {
+		src := file.path
+		if i > 1 {
+			src = fmt.Sprintf("%s.%d", file.path, i-1)
+		}
+
+		if err := os.Rename(src, fmt.Sprintf("%s.%d", file.path, i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return file.open()
+}
+
+func (file *rotatingFile) Close() error {
+	file.mu.Lock()
+	defer file.mu.Unlock()
+
+	if file.file == nil {
+		return nil
+	}
+
+	err := file.file.Close()
+	file.file = nil
+	return err
+}
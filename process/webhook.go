@@ -0,0 +1,319 @@
+package process
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultWebhookBackoff is the time before the first retry of a webhook call
+const DefaultWebhookBackoff = time.Second
+
+// WebhookConfig are the HTTP calls that start, stop and check a server
+type WebhookConfig struct {
+	Start  WebhookCall
+	Stop   WebhookCall
+	Status WebhookCall
+}
+
+// WebhookCall is an HTTP request to a webhook
+type WebhookCall struct {
+	URL string
+	// Method defaults to POST for start and stop and to GET for status
+	Method string
+	Header map[string]string
+	// Body is a text/template with the Action of the call, like {"signal":"{{.Action}}"}
+	Body string
+	// ExpectedStatus are the status codes of a successful call. They default to 2xx.
+	ExpectedStatus []int
+	// Timeout of one attempt. It defaults to ten seconds.
+	Timeout time.Duration
+	// Retries is the number of attempts after a failed one
+	Retries int
+	// Backoff is the time before the first retry, which doubles for every further retry.
+	// It defaults to DefaultWebhookBackoff.
+	Backoff time.Duration
+
+	// StoppedStatus are the status codes of a status call that mean that the server is not running
+	StoppedStatus []int
+	// Path is a JSONPath-like expression like $.attributes.state that selects a value from
+	// the response of a status call. Without it every expected status means that the server is running.
+	Path string
+	// Running are the values at Path that mean that the server is running.
+	// Without them the value has to be true.
+	Running []string
+}
+
+type webhook struct {
+	client *http.Client
+	start  webhookCall
+	stop   webhookCall
+	status webhookCall
+}
+
+type webhookCall struct {
+	WebhookCall
+	action string
+	body   *template.Template
+	path   []string
+}
+
+// NewWebhook creates a new process that is managed by HTTP calls to webhooks
+func NewWebhook(cfg WebhookConfig) (Process, error) {
+	start, err := newWebhookCall("start", http.MethodPost, cfg.Start)
+	if err != nil {
+		return nil, err
+	}
+
+	stop, err := newWebhookCall("stop", http.MethodPost, cfg.Stop)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := newWebhookCall("status", http.MethodGet, cfg.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	return webhook{
+		client: &http.Client{},
+		start:  start,
+		stop:   stop,
+		status: status,
+	}, nil
+}
+
+// Validate checks that every call has a URL, a valid body template and a valid path
+func (cfg WebhookConfig) Validate() error {
+	_, err := NewWebhook(cfg)
+	return err
+}
+
+func newWebhookCall(action, method string, cfg WebhookCall) (webhookCall, error) {
+	call := webhookCall{
+		WebhookCall: cfg,
+		action:      action,
+	}
+
+	if cfg.URL == "" {
+		return call, fmt.Errorf("%s: url is empty", action)
+	}
+
+	if call.Method == "" {
+		call.Method = method
+	}
+
+	body, err := template.New(action).Parse(cfg.Body)
+	if err != nil {
+		return call, fmt.Errorf("%s: %w", action, err)
+	}
+	call.body = body
+
+	if cfg.Path != "" {
+		call.path, err = parseJSONPath(cfg.Path)
+		if err != nil {
+			return call, fmt.Errorf("%s: %w", action, err)
+		}
+	}
+	return call, nil
+}
+
+func (webhook webhook) Start() error {
+	_, _, err := webhook.do(webhook.start)
+	return err
+}
+
+func (webhook webhook) Stop() error {
+	_, _, err := webhook.do(webhook.stop)
+	return err
+}
+
+func (webhook webhook) IsRunning() (bool, error) {
+	statusCode, bb, err := webhook.do(webhook.status)
+	if err != nil {
+		return false, err
+	}
+
+	if containsStatus(webhook.status.StoppedStatus, statusCode) {
+		return false, nil
+	}
+
+	if webhook.status.Path == "" {
+		return true, nil
+	}
+
+	var response interface{}
+	if err := json.Unmarshal(bb, &response); err != nil {
+		return false, fmt.Errorf("status: %w", err)
+	}
+
+	value, ok := lookupJSONPath(response, webhook.status.path)
+	if !ok {
+		return false, fmt.Errorf("status: %s is not in the response", webhook.status.Path)
+	}
+
+	if len(webhook.status.Running) == 0 {
+		return value == true, nil
+	}
+
+	for _, running := range webhook.status.Running {
+		if fmt.Sprint(value) == running {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// do sends the call until it succeeds or the retries are used up.
+// It returns the status code and body of the successful response.
+func (webhook webhook) do(call webhookCall) (int, []byte, error) {
+	backoff := call.Backoff
+	if backoff <= 0 {
+		backoff = DefaultWebhookBackoff
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		var statusCode int
+		var bb []byte
+		statusCode, bb, err = webhook.send(call)
+		if err == nil {
+			return statusCode, bb, nil
+		}
+
+		if attempt >= call.Retries {
+			break
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	return 0, nil, fmt.Errorf("%s: %w", call.action, err)
+}
+
+func (webhook webhook) send(call webhookCall) (int, []byte, error) {
+	var body bytes.Buffer
+	if err := call.body.Execute(&body, struct{ Action string }{call.action}); err != nil {
+		return 0, nil, err
+	}
+
+	timeout := call.Timeout
+	if timeout <= 0 {
+		timeout = contextTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, call.Method, call.URL, &body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	for key, value := range call.Header {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := webhook.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	bb, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if !call.isExpected(resp.StatusCode) && !containsStatus(call.StoppedStatus, resp.StatusCode) {
+		return 0, nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, bb, nil
+}
+
+// isExpected reports whether the status code is one of the expected ones or 2xx without them
+func (call webhookCall) isExpected(statusCode int) bool {
+	if len(call.ExpectedStatus) == 0 {
+		return statusCode >= 200 && statusCode < 300
+	}
+	return containsStatus(call.ExpectedStatus, statusCode)
+}
+
+func containsStatus(codes []int, statusCode int) bool {
+	for _, code := range codes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// parseJSONPath splits a path like $.data[0].state into the keys data, 0 and state
+func parseJSONPath(path string) ([]string, error) {
+	path = strings.TrimPrefix(path, "$")
+	if path != "" && path[0] != '.' && path[0] != '[' {
+		path = "." + path
+	}
+
+	var keys []string
+	for path != "" {
+		switch path[0] {
+		case '.':
+			path = path[1:]
+			end := strings.IndexAny(path, ".[")
+			if end < 0 {
+				end = len(path)
+			}
+			if end == 0 {
+				return nil, errors.New("empty key in path")
+			}
+			keys = append(keys, path[:end])
+			path = path[end:]
+		case '[':
+			end := strings.IndexByte(path, ']')
+			if end < 0 {
+				return nil, errors.New("unclosed [ in path")
+			}
+			key := strings.Trim(path[1:end], `'"`)
+			if key == "" {
+				return nil, errors.New("empty index in path")
+			}
+			keys = append(keys, key)
+			path = path[end+1:]
+		default:
+			return nil, fmt.Errorf("unexpected %q in path", path[0])
+		}
+	}
+	return keys, nil
+}
+
+// lookupJSONPath returns the value at the keys of a decoded JSON value
+func lookupJSONPath(value interface{}, keys []string) (interface{}, bool) {
+	for _, key := range keys {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			value, ok = v[key]
+			if !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			value = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
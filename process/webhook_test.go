@@ -0,0 +1,162 @@
+package process
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePanel is a server panel that is controlled by webhooks
+type fakePanel struct {
+	mu    sync.Mutex
+	state string
+	// failures is the number of requests that fail before one succeeds
+	failures int
+	requests int
+}
+
+func (panel *fakePanel) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	panel.mu.Lock()
+	defer panel.mu.Unlock()
+
+	panel.requests++
+	if panel.failures > 0 {
+		panel.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/power":
+		bb, _ := ioutil.ReadAll(r.Body)
+		switch string(bb) {
+		case `{"signal":"start"}`:
+			panel.state = "running"
+		case `{"signal":"stop"}`:
+			panel.state = "offline"
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "/server":
+		if panel.state == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"attributes":{"servers":[{"state":%q}]}}`, panel.state)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	panel := &fakePanel{}
+	server := httptest.NewServer(panel)
+	defer server.Close()
+
+	header := map[string]string{"Authorization": "Bearer token"}
+	power := WebhookCall{
+		URL:     server.URL + "/power",
+		Header:  header,
+		Body:    `{"signal":"{{.Action}}"}`,
+		Retries: 2,
+		Backoff: time.Millisecond,
+	}
+	proc, err := NewWebhook(WebhookConfig{
+		Start: power,
+		Stop:  power,
+		Status: WebhookCall{
+			URL:           server.URL + "/server",
+			Header:        header,
+			StoppedStatus: []int{http.StatusNotFound},
+			Path:          "$.attributes.servers[0].state",
+			Running:       []string{"starting", "running"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertRunning(t, proc, false)
+
+	panel.failures = 2
+	if err := proc.Start(); err != nil {
+		t.Fatal(err)
+	}
+	assertRunning(t, proc, true)
+
+	if panel.requests != 5 {
+		t.Errorf("expected two retries, got %d requests", panel.requests)
+	}
+
+	if err := proc.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	assertRunning(t, proc, false)
+
+	panel.failures = 3
+	if err := proc.Start(); err == nil || err.Error() != "start: unexpected status 503 Service Unavailable" {
+		t.Errorf("expected the retries to be used up, got %v", err)
+	}
+}
+
+func TestWebhook_Timeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+
+	call := WebhookCall{URL: server.URL, Timeout: 10 * time.Millisecond}
+	proc, err := NewWebhook(WebhookConfig{Start: call, Stop: call, Status: call})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if _, err := proc.IsRunning(); err == nil {
+		t.Error("expected a timeout")
+	}
+
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("status took %s", time.Since(start))
+	}
+}
+
+func TestParseJSONPath(t *testing.T) {
+	tt := []struct {
+		path string
+		keys []string
+		err  bool
+	}{
+		{path: "$.attributes.state", keys: []string{"attributes", "state"}},
+		{path: "data[0].state", keys: []string{"data", "0", "state"}},
+		{path: `$['current state']`, keys: []string{"current state"}},
+		{path: "$.data..state", err: true},
+		{path: "$.data[0", err: true},
+	}
+
+	for _, tc := range tt {
+		keys, err := parseJSONPath(tc.path)
+		if tc.err != (err != nil) {
+			t.Errorf("%s: unexpected error %v", tc.path, err)
+		}
+
+		if !tc.err && !reflect.DeepEqual(keys, tc.keys) {
+			t.Errorf("%s: expected %v, got %v", tc.path, tc.keys, keys)
+		}
+	}
+}
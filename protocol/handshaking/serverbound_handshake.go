@@ -1,9 +1,12 @@
 package handshaking
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"github.com/haveachin/infrared/protocol"
 	"net"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -18,6 +21,8 @@ const (
 	RealIPSeparator = "///"
 )
 
+var ErrInvalidRealIPAddress = errors.New("invalid RealIP address")
+
 type ServerBoundHandshake struct {
 	ProtocolVersion protocol.VarInt
 	ServerAddress   protocol.String
@@ -81,6 +86,77 @@ func (pk ServerBoundHandshake) ParseServerAddress() string {
 	return addr
 }
 
+// RealIP holds the information that a RealIP handshake embeds
+// into the server address
+type RealIP struct {
+	ServerAddress string
+	ClientAddr    *net.TCPAddr
+	Timestamp     time.Time
+	// Signature is only set by proxies that sign their handshakes like TCPShield
+	Signature []byte
+	// SignedData is the part of the server address that the Signature is for
+	SignedData string
+}
+
+// ParseRealIP parses the RealIP segments of the server address.
+// Supported formats are "host///ip:port///timestamp" and
+// "host///ip:port///timestamp///signature".
+func (pk ServerBoundHandshake) ParseRealIP() (RealIP, error) {
+	addr := string(pk.ServerAddress)
+	addr = strings.Split(addr, ForgeSeparator)[0]
+
+	segments := strings.Split(addr, RealIPSeparator)
+	if len(segments) != 3 && len(segments) != 4 {
+		return RealIP{}, ErrInvalidRealIPAddress
+	}
+
+	clientAddr, err := parseRealIPClientAddr(segments[1])
+	if err != nil {
+		return RealIP{}, err
+	}
+
+	unixTimestamp, err := strconv.ParseInt(segments[2], 10, 64)
+	if err != nil {
+		return RealIP{}, ErrInvalidRealIPAddress
+	}
+
+	realIP := RealIP{
+		ServerAddress: strings.Trim(segments[0], "."),
+		ClientAddr:    clientAddr,
+		Timestamp:     time.Unix(unixTimestamp, 0),
+		SignedData:    strings.Join(segments[:3], RealIPSeparator),
+	}
+
+	if len(segments) == 4 {
+		realIP.Signature, err = base64.StdEncoding.DecodeString(segments[3])
+		if err != nil {
+			return RealIP{}, ErrInvalidRealIPAddress
+		}
+	}
+
+	return realIP, nil
+}
+
+func parseRealIPClientAddr(addr string) (*net.TCPAddr, error) {
+	host, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		// Some proxies only send the IP without a port
+		host, portString = addr, "0"
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, ErrInvalidRealIPAddress
+	}
+
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return nil, ErrInvalidRealIPAddress
+	}
+
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
 func (pk *ServerBoundHandshake) UpgradeToRealIP(clientAddr net.Addr, timestamp time.Time) {
 	if pk.IsRealIPAddress() {
 		return
@@ -346,6 +346,93 @@ func TestServerBoundHandshake_UpgradeToRealIP(t *testing.T) {
 	}
 }
 
+func TestServerBoundHandshake_ParseRealIP(t *testing.T) {
+	tt := []struct {
+		addr          string
+		serverAddress string
+		clientAddr    string
+		timestamp     int64
+		signature     []byte
+		signedData    string
+		expectError   bool
+	}{
+		{
+			addr:          "example.com///127.0.0.1:12345///1620000000",
+			serverAddress: "example.com",
+			clientAddr:    "127.0.0.1:12345",
+			timestamp:     1620000000,
+			signedData:    "example.com///127.0.0.1:12345///1620000000",
+		},
+		{
+			addr:          "example.com///[2001:db8::1]:12345///1620000000\x00FML\x00",
+			serverAddress: "example.com",
+			clientAddr:    "[2001:db8::1]:12345",
+			timestamp:     1620000000,
+			signedData:    "example.com///[2001:db8::1]:12345///1620000000",
+		},
+		{
+			addr:          "example.com.///127.0.0.1///1620000000///AQID",
+			serverAddress: "example.com",
+			clientAddr:    "127.0.0.1:0",
+			timestamp:     1620000000,
+			signature:     []byte{1, 2, 3},
+			signedData:    "example.com.///127.0.0.1///1620000000",
+		},
+		{
+			addr:        "example.com",
+			expectError: true,
+		},
+		{
+			addr:        "example.com///not-an-ip///1620000000",
+			expectError: true,
+		},
+		{
+			addr:        "example.com///127.0.0.1:12345///now",
+			expectError: true,
+		},
+		{
+			addr:        "example.com///127.0.0.1:12345///1620000000///%%%",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		hs := ServerBoundHandshake{ServerAddress: protocol.String(tc.addr)}
+		realIP, err := hs.ParseRealIP()
+		if tc.expectError {
+			if err == nil {
+				t.Errorf("%q: expected an error", tc.addr)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: %v", tc.addr, err)
+			continue
+		}
+
+		if realIP.ServerAddress != tc.serverAddress {
+			t.Errorf("got: %v; want: %v", realIP.ServerAddress, tc.serverAddress)
+		}
+
+		if realIP.ClientAddr.String() != tc.clientAddr {
+			t.Errorf("got: %v; want: %v", realIP.ClientAddr, tc.clientAddr)
+		}
+
+		if realIP.Timestamp.Unix() != tc.timestamp {
+			t.Errorf("got: %v; want: %v", realIP.Timestamp.Unix(), tc.timestamp)
+		}
+
+		if !bytes.Equal(realIP.Signature, tc.signature) {
+			t.Errorf("got: %v; want: %v", realIP.Signature, tc.signature)
+		}
+
+		if realIP.SignedData != tc.signedData {
+			t.Errorf("got: %v; want: %v", realIP.SignedData, tc.signedData)
+		}
+	}
+}
+
 func BenchmarkHandshakingServerBoundHandshake_Marshal(b *testing.B) {
 	isHandshakePk := ServerBoundHandshake{
 		ProtocolVersion: 578,
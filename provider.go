@@ -0,0 +1,190 @@
+package infrared
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+)
+
+// ConfigEventType is the kind of change of a proxy config
+type ConfigEventType int
+
+const (
+	// ConfigAdded is sent for proxy configs that the gateway does not know yet
+	ConfigAdded ConfigEventType = iota
+	// ConfigUpdated is sent after a proxy config was changed in place
+	ConfigUpdated
+	// ConfigRemoved is sent for proxy configs whose proxies have to be closed
+	ConfigRemoved
+)
+
+func (t ConfigEventType) String() string {
+	switch t {
+	case ConfigAdded:
+		return "added"
+	case ConfigUpdated:
+		return "updated"
+	case ConfigRemoved:
+		return "removed"
+	default:
+		return "unknown"
+	}
+}
+
+// ConfigEvent is a change of a proxy config
+type ConfigEvent struct {
+	Type ConfigEventType
+	// ID identifies the config across events, like the path of its file
+	ID     string
+	Config *ProxyConfig
+}
+
+// ConfigProvider is a source of proxy configs like a folder or an HTTP endpoint
+type ConfigProvider interface {
+	// Provide sends an added event for every config of the provider and
+	// then sends their changes until the context is done or the provider fails.
+	Provide(ctx context.Context, events chan<- ConfigEvent) error
+}
+
+// configSet are the configs of a provider that are not loaded from files, like the configs of an HTTP endpoint
+type configSet struct {
+	// configs are the loaded configs by the names of their source
+	configs map[string]*configSetEntry
+}
+
+type configSetEntry struct {
+	raw    []byte
+	config *ProxyConfig
+}
+
+// configOrigin is where the content of a config comes from, which limits the fields that it can set
+type configOrigin int
+
+const (
+	// configOriginFile are config files, which can extend files relative to their directory
+	configOriginFile configOrigin = iota
+	// configOriginHTTP are configs of an endpoint. They are as trusted as files, but cannot extend
+	// files, since there is no directory that their bases are relative to and that is watched.
+	configOriginHTTP
+	// configOriginDiscovered are configs of labels and annotations, see validateDiscoveredConfig
+	configOriginDiscovered
+)
+
+// configSource is the JSON content of a config and the name of its source
+type configSource struct {
+	name   string
+	raw    []byte
+	origin configOrigin
+}
+
+// load loads the content of the source into the config
+func (source configSource) load(cfg *ProxyConfig) error {
+	return cfg.loadConfig(source.name, source.raw, source.origin)
+}
+
+// apply loads the configs of the sources and sends their changes as events.
+// Configs are changed in place if their content changed and removed if their source is missing.
+func (set *configSet) apply(sources []configSource, send func(ConfigEvent)) {
+	names := map[string]bool{}
+	for _, source := range sources {
+		names[source.name] = true
+		set.update(source, send)
+	}
+
+	for name := range set.configs {
+		if !names[name] {
+			set.remove(name, send)
+		}
+	}
+}
+
+// update loads the config of the source and sends an event if it is new or its content changed
+func (set *configSet) update(source configSource, send func(ConfigEvent)) {
+	if set.configs == nil {
+		set.configs = map[string]*configSetEntry{}
+	}
+
+	known, ok := set.configs[source.name]
+	if ok && bytes.Equal(known.raw, source.raw) {
+		return
+	}
+
+	if ok {
+		log.Println("Updating", source.name)
+		// Invalid content is not loaded again until it changes
+		known.raw = source.raw
+		if err := source.load(known.config); err != nil {
+			log.Printf("Failed update on %s; error %s", source.name, err)
+			return
+		}
+		known.config.resetCaches()
+		send(ConfigEvent{Type: ConfigUpdated, ID: source.name, Config: known.config})
+		return
+	}
+
+	log.Println("Loading", source.name)
+	cfg := &ProxyConfig{}
+	if err := source.load(cfg); err != nil {
+		log.Printf("Failed loading %s; error %s", source.name, err)
+		return
+	}
+	set.configs[source.name] = &configSetEntry{raw: source.raw, config: cfg}
+	send(ConfigEvent{Type: ConfigAdded, ID: source.name, Config: cfg})
+}
+
+// remove removes the config of the source with the name and sends a removed event if it was loaded
+func (set *configSet) remove(name string, send func(ConfigEvent)) {
+	known, ok := set.configs[name]
+	if !ok {
+		return
+	}
+
+	log.Println("Removing", name)
+	delete(set.configs, name)
+	send(ConfigEvent{Type: ConfigRemoved, ID: name, Config: known.config})
+}
+
+// HandleConfigEvents registers, re-registers and closes the proxies of the configs in the events.
+// It returns once the events channel is closed.
+func (gateway *Gateway) HandleConfigEvents(events <-chan ConfigEvent) {
+	// The proxies and the UIDs that they were registered with by the IDs of their configs
+	proxies := map[string]*Proxy{}
+	uids := map[string]string{}
+
+	for event := range events {
+		proxy, ok := proxies[event.ID]
+		switch event.Type {
+		case ConfigAdded, ConfigUpdated:
+			if ok && proxy.Config == event.Config && uids[event.ID] == proxy.UID() {
+				continue
+			}
+
+			if ok {
+				gateway.CloseProxy(uids[event.ID])
+			}
+
+			if !ok || proxy.Config != event.Config {
+				proxy = &Proxy{Config: event.Config}
+			}
+			proxies[event.ID] = proxy
+			uids[event.ID] = proxy.UID()
+
+			if err := gateway.RegisterProxy(proxy); err != nil {
+				log.Printf("Failed registering proxy of %s; error: %s", event.ID, err)
+				if errors.Is(err, errProxyUIDRegistered) {
+					// The proxy that owns the UID must not be closed when this config changes or is removed
+					delete(proxies, event.ID)
+					delete(uids, event.ID)
+				}
+			}
+		case ConfigRemoved:
+			if !ok {
+				continue
+			}
+			gateway.CloseProxy(uids[event.ID])
+			delete(proxies, event.ID)
+			delete(uids, event.ID)
+		}
+	}
+}
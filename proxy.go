@@ -1,19 +1,25 @@
 package infrared
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/haveachin/infrared/callback"
+	"github.com/haveachin/infrared/kubernetes"
 	"github.com/haveachin/infrared/process"
 	"github.com/haveachin/infrared/protocol"
 	"github.com/haveachin/infrared/protocol/handshaking"
 	"github.com/haveachin/infrared/protocol/login"
-	"github.com/pires/go-proxyproto"
+	"github.com/haveachin/infrared/protocol/status"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
@@ -25,6 +31,10 @@ var (
 	}, []string{"host"})
 )
 
+const LoginDeniedReasonServerFull = "serverFull"
+
+var ErrServerFull = errors.New("server full")
+
 func proxyUID(domain, addr string) string {
 	return fmt.Sprintf("%s@%s", strings.ToLower(domain), addr)
 }
@@ -34,6 +44,11 @@ type Proxy struct {
 
 	cancelTimeoutFunc func()
 	players           map[Conn]string
+	handshakeLimiter  handshakeLimiter
+	playerLists       playerLists
+	cachedStatus      protocol.Packet
+	cachedStatusAt    time.Time
+	statusMu          sync.Mutex
 	mu                sync.Mutex
 }
 
@@ -60,6 +75,48 @@ func (proxy *Proxy) Process() process.Process {
 		return portainer
 	}
 
+	if proxy.Config.Docker.IsKubernetes() {
+		k8s := proxy.Config.Docker.Kubernetes
+		client, err := kubernetes.NewClient(k8s.APIServer)
+		if err != nil {
+			log.Println("Failed to create a Kubernetes process; error:", err)
+			return nil
+		}
+
+		resource, name := "deployments", k8s.Deployment
+		if k8s.StatefulSet != "" {
+			resource, name = "statefulsets", k8s.StatefulSet
+		}
+		proxy.Config.process = process.NewKubernetes(client, k8s.Namespace, resource, name)
+		return proxy.Config.process
+	}
+
+	if proxy.Config.Docker.IsExec() {
+		exec, err := process.NewExec(proxy.Config.Docker.ExecConfig())
+		if err != nil {
+			log.Println("Failed to create an exec process; error:", err)
+			return nil
+		}
+		proxy.Config.process = exec
+		return exec
+	}
+
+	if proxy.Config.Docker.IsPterodactyl() {
+		pterodactyl := proxy.Config.Docker.Pterodactyl
+		proxy.Config.process = process.NewPterodactyl(pterodactyl.URL, pterodactyl.ServerID, pterodactyl.APIKey)
+		return proxy.Config.process
+	}
+
+	if proxy.Config.Docker.IsWebhook() {
+		webhook, err := process.NewWebhook(proxy.Config.Docker.WebhookConfig())
+		if err != nil {
+			log.Println("Failed to create a webhook process; error:", err)
+			return nil
+		}
+		proxy.Config.process = webhook
+		return webhook
+	}
+
 	if proxy.Config.Docker.IsDocker() {
 		docker, err := process.NewDocker(proxy.Config.Docker.ContainerName)
 		if err != nil {
@@ -112,13 +169,31 @@ func (proxy *Proxy) IsOnlineStatusConfigured() bool {
 func (proxy *Proxy) OnlineStatusPacket() (protocol.Packet, error) {
 	proxy.Config.Lock()
 	defer proxy.Config.Unlock()
-	return proxy.Config.OnlineStatus.StatusResponsePacket()
+	return proxy.liveStatusPacket(&proxy.Config.OnlineStatus)
 }
 
 func (proxy *Proxy) OfflineStatusPacket() (protocol.Packet, error) {
 	proxy.Config.Lock()
 	defer proxy.Config.Unlock()
-	return proxy.Config.OfflineStatus.StatusResponsePacket()
+	return proxy.liveStatusPacket(&proxy.Config.OfflineStatus)
+}
+
+// liveStatusPacket replaces the player count of the status with the connected
+// players and the maximum with maxConnections, if the proxy has a limit.
+// The caller has to hold the config lock.
+func (proxy *Proxy) liveStatusPacket(statusCfg *StatusConfig) (protocol.Packet, error) {
+	if proxy.Config.MaxConnections <= 0 {
+		return statusCfg.StatusResponsePacket()
+	}
+
+	responseJSON, err := statusCfg.responseJSON()
+	if err != nil {
+		return protocol.Packet{}, err
+	}
+
+	responseJSON.Players.Online = proxy.PlayerCount()
+	responseJSON.Players.Max = proxy.Config.MaxConnections
+	return marshalStatusResponse(responseJSON)
 }
 
 func (proxy *Proxy) Timeout() time.Duration {
@@ -145,12 +220,100 @@ func (proxy *Proxy) ProxyProtocol() bool {
 	return proxy.Config.ProxyProtocol
 }
 
+func (proxy *Proxy) ProxyProtocolVersion() int {
+	proxy.Config.RLock()
+	defer proxy.Config.RUnlock()
+	return proxy.Config.ProxyProtocolVersion
+}
+
+func (proxy *Proxy) ProxyProtocolTLVs() ProxyProtocolTLVConfig {
+	proxy.Config.RLock()
+	defer proxy.Config.RUnlock()
+	return proxy.Config.ProxyProtocolTLVs
+}
+
 func (proxy *Proxy) RealIP() bool {
 	proxy.Config.RLock()
 	defer proxy.Config.RUnlock()
 	return proxy.Config.RealIP
 }
 
+func (proxy *Proxy) RateLimit() HandshakeRateLimitConfig {
+	proxy.Config.RLock()
+	defer proxy.Config.RUnlock()
+	return proxy.Config.RateLimit
+}
+
+func (proxy *Proxy) AccessList() (*AccessList, error) {
+	proxy.Config.Lock()
+	defer proxy.Config.Unlock()
+	return proxy.Config.compiledAccessList()
+}
+
+func (proxy *Proxy) IsCountryAllowed(country string) bool {
+	proxy.Config.RLock()
+	defer proxy.Config.RUnlock()
+	return isCountryAllowed(country, proxy.Config.AllowCountries, proxy.Config.DenyCountries)
+}
+
+func (proxy *Proxy) Maintenance() MaintenanceConfig {
+	proxy.Config.RLock()
+	defer proxy.Config.RUnlock()
+	return proxy.Config.Maintenance
+}
+
+// MaintenanceStatusPacket returns the status response of the maintenance mode.
+// It is built once with the maintenance defaults and cached like the other statuses.
+func (proxy *Proxy) MaintenanceStatusPacket() (protocol.Packet, error) {
+	proxy.Config.Lock()
+	defer proxy.Config.Unlock()
+
+	maintenance := &proxy.Config.Maintenance
+	if maintenance.Status.cachedPacket == nil {
+		statusCfg := maintenance.statusConfig()
+		if _, err := statusCfg.StatusResponsePacket(); err != nil {
+			return protocol.Packet{}, err
+		}
+		maintenance.Status.cachedPacket = statusCfg.cachedPacket
+	}
+	return *maintenance.Status.cachedPacket, nil
+}
+
+func (proxy *Proxy) MaxConnections() int {
+	proxy.Config.RLock()
+	defer proxy.Config.RUnlock()
+	return proxy.Config.MaxConnections
+}
+
+func (proxy *Proxy) ServerFullMessage() string {
+	proxy.Config.RLock()
+	defer proxy.Config.RUnlock()
+	return proxy.Config.ServerFullMessage
+}
+
+func (proxy *Proxy) IsPriorityPlayer(username string) bool {
+	proxy.Config.RLock()
+	defer proxy.Config.RUnlock()
+	for _, priorityPlayer := range proxy.Config.PriorityPlayers {
+		if strings.EqualFold(priorityPlayer, username) {
+			return true
+		}
+	}
+	return false
+}
+
+func (proxy *Proxy) DuplicateLogin() DuplicateLoginConfig {
+	proxy.Config.RLock()
+	defer proxy.Config.RUnlock()
+	return proxy.Config.DuplicateLogin
+}
+
+func (proxy *Proxy) PlayerLists() PlayerListsConfig {
+	proxy.Config.RLock()
+	defer proxy.Config.RUnlock()
+	return proxy.Config.PlayerLists
+}
+
 func (proxy *Proxy) CallbackLogger() callback.Logger {
 	proxy.Config.RLock()
 	defer proxy.Config.RUnlock()
@@ -164,13 +327,25 @@ func (proxy *Proxy) UID() string {
 	return proxyUID(proxy.DomainName(), proxy.ListenTo())
 }
 
-func (proxy *Proxy) addPlayer(conn Conn, username string) {
+// reservePlayer adds the player if the proxy has less than max players.
+// A max of zero or less means no limit.
+func (proxy *Proxy) reservePlayer(conn Conn, username string, max int) bool {
 	proxy.mu.Lock()
 	defer proxy.mu.Unlock()
 	if proxy.players == nil {
 		proxy.players = map[Conn]string{}
 	}
+	if max > 0 && len(proxy.players) >= max {
+		return false
+	}
 	proxy.players[conn] = username
+	return true
+}
+
+func (proxy *Proxy) PlayerCount() int {
+	proxy.mu.Lock()
+	defer proxy.mu.Unlock()
+	return len(proxy.players)
 }
 
 func (proxy *Proxy) removePlayer(conn Conn) int {
@@ -184,13 +359,38 @@ func (proxy *Proxy) removePlayer(conn Conn) int {
 	return len(proxy.players)
 }
 
+// allowHandshake checks the handshake against the rate limits of the proxy
+func (proxy *Proxy) allowHandshake(ip net.IP, isLogin bool, now time.Time) (string, bool) {
+	return proxy.handshakeLimiter.allow(ip.String(), isLogin, proxy.RateLimit(), now)
+}
+
 func (proxy *Proxy) logEvent(event callback.Event) {
 	if _, err := proxy.CallbackLogger().LogEvent(event); err != nil {
 		log.Println("[w] Failed callback logging; error:", err)
 	}
 }
 
-func (proxy *Proxy) handleConn(conn Conn, connRemoteAddr net.Addr) error {
+// connOptions are the gateway settings that apply to a connection
+type connOptions struct {
+	timeouts TimeoutConfig
+	// geoLabels enables the metric of connected players by country and ASN
+	geoLabels bool
+	// listenerSlots enforces the player limit of the listener
+	listenerSlots listenerSlots
+	// players is the registry of all players of the gateway
+	players *PlayerRegistry
+	// duplicateLogin is the global policy for players that are already connected
+	duplicateLogin DuplicateLoginConfig
+	// audit is the audit log entry of the connection
+	audit *AuditEntry
+}
+
+func (proxy *Proxy) handleConn(conn Conn, connRemoteAddr net.Addr, location GeoIPLocation, opts connOptions) error {
+	if opts.audit == nil {
+		// Connections that are not audited fill an entry that is thrown away
+		opts.audit = &AuditEntry{}
+	}
+
 	pk, err := conn.ReadPacket()
 	if err != nil {
 		return err
@@ -201,6 +401,60 @@ func (proxy *Proxy) handleConn(conn Conn, connRemoteAddr net.Addr) error {
 		return err
 	}
 
+	var loginPk protocol.Packet
+	var loginStart login.ServerLoginStart
+	var session *clientSession
+	if hs.IsLoginRequest() {
+		if err := setReadTimeout(conn, opts.timeouts.Login); err != nil {
+			return err
+		}
+
+		loginPk, err = conn.ReadPacket()
+		if err != nil {
+			return phaseError(err, TimeoutPhaseLogin)
+		}
+
+		loginStart, err = login.UnmarshalServerBoundLoginStart(loginPk)
+		if err != nil {
+			return err
+		}
+		opts.audit.Username = string(loginStart.Name)
+
+		if err := proxy.checkPlayer(conn, connRemoteAddr, string(loginStart.Name)); err != nil {
+			return err
+		}
+
+		if err := proxy.checkMaintenance(conn, connRemoteAddr, string(loginStart.Name)); err != nil {
+			return err
+		}
+
+		if err := proxy.checkCapacity(conn, connRemoteAddr, string(loginStart.Name), opts.listenerSlots); err != nil {
+			return err
+		}
+		defer opts.listenerSlots.release()
+		defer proxy.removePlayer(conn)
+
+		var unregister func()
+		session, unregister, err = proxy.registerPlayer(conn, int(hs.ProtocolVersion), connRemoteAddr, string(loginStart.Name), opts)
+		if err != nil {
+			return err
+		}
+		defer unregister()
+	} else {
+		if err := setReadTimeout(conn, opts.timeouts.Status); err != nil {
+			return err
+		}
+
+		maintenance := proxy.Maintenance()
+		if maintenance.IsActive(time.Now()) && !maintenance.canBypassIP(addrIP(connRemoteAddr)) {
+			responsePk, err := proxy.MaintenanceStatusPacket()
+			if err != nil {
+				return err
+			}
+			return exchangeStatus(conn, responsePk)
+		}
+	}
+
 	proxyDomain := proxy.DomainName()
 	proxyTo := proxy.ProxyTo()
 	proxyUID := proxy.UID()
@@ -220,7 +474,7 @@ func (proxy *Proxy) handleConn(conn Conn, connRemoteAddr net.Addr) error {
 			return err
 		}
 		proxy.timeoutProcess()
-		return proxy.handleLoginRequest(conn)
+		return proxy.handleLoginRequest(conn, loginStart)
 	}
 	defer rconn.Close()
 
@@ -228,6 +482,7 @@ func (proxy *Proxy) handleConn(conn Conn, connRemoteAddr net.Addr) error {
 		return proxy.handleStatusRequest(conn, true)
 	}
 
+	serverAddress := hs.ParseServerAddress()
 	spoofForcedHost := proxy.SpoofForcedHost()
 	if spoofForcedHost != "" {
 		hs.ServerAddress = protocol.String(spoofForcedHost)
@@ -235,12 +490,16 @@ func (proxy *Proxy) handleConn(conn Conn, connRemoteAddr net.Addr) error {
 	}
 
 	if proxy.ProxyProtocol() {
-		header := &proxyproto.Header{
-			Version:           2,
-			Command:           proxyproto.PROXY,
-			TransportProtocol: proxyproto.TCPv4,
-			SourceAddr:        connRemoteAddr,
-			DestinationAddr:   rconn.RemoteAddr(),
+		header := newProxyProtocolHeader(proxy.ProxyProtocolVersion(), connRemoteAddr, rconn.RemoteAddr())
+		if header.Version == 2 {
+			tlvs, err := proxyProtocolTLVs(proxy.ProxyProtocolTLVs(), serverAddress)
+			if err != nil {
+				return err
+			}
+
+			if err := header.SetTLVs(tlvs); err != nil {
+				return err
+			}
 		}
 
 		if _, err = header.WriteTo(rconn); err != nil {
@@ -256,28 +515,60 @@ func (proxy *Proxy) handleConn(conn Conn, connRemoteAddr net.Addr) error {
 	if err := rconn.WritePacket(pk); err != nil {
 		return err
 	}
+	opts.audit.Backend = proxyTo
 
+	// Status exchanges keep their deadline while login sessions
+	// switch to the idle timeout which is renewed on every read
+	var idleTimeout time.Duration
+	timeoutPhase := TimeoutPhaseStatus
 	var username string
 	connected := false
 	if hs.IsLoginRequest() {
+		if err := setReadTimeout(conn, 0); err != nil {
+			return err
+		}
+		idleTimeout = opts.timeouts.Idle
+		timeoutPhase = TimeoutPhaseIdle
+
 		proxy.cancelProcessTimeout()
-		username, err = proxy.sniffUsername(conn, rconn, connRemoteAddr)
-		if err != nil {
+		if err := rconn.WritePacket(loginPk); err != nil {
 			return err
 		}
-		proxy.addPlayer(conn, username)
+		username = string(loginStart.Name)
+		log.Printf("[i] %s with username %s connects through %s", connRemoteAddr, username, proxyUID)
 		proxy.logEvent(callback.PlayerJoinEvent{
 			Username:      username,
 			RemoteAddress: connRemoteAddr.String(),
 			TargetAddress: proxyTo,
 			ProxyUID:      proxyUID,
+			Country:       location.Country,
+			ASN:           location.ASN,
 		})
 		playersConnected.With(prometheus.Labels{"host": proxyDomain}).Inc()
+		if opts.geoLabels {
+			playersConnectedGeo.With(geoLabels(proxyDomain, location)).Inc()
+		}
 		connected = true
 	}
 
-	go pipe(rconn, conn)
-	pipe(conn, rconn)
+	// The counters are local variables, which are 64-bit aligned for atomic access on 32-bit platforms
+	var bytesIn, bytesOut int64
+	pipeDone := make(chan struct{})
+	go func() {
+		defer close(pipeDone)
+		if session != nil {
+			session.pipeFrom(rconn, idleTimeout, &bytesOut)
+		} else {
+			pipe(rconn, conn, idleTimeout, &bytesOut)
+		}
+	}()
+	err = pipe(conn, rconn, idleTimeout, &bytesIn)
+
+	// Closing the server connection ends the other pipe, so the entry has the final byte counts
+	rconn.Close()
+	<-pipeDone
+	opts.audit.BytesIn = bytesIn
+	opts.audit.BytesOut = bytesOut
 
 	if connected {
 		proxy.logEvent(callback.PlayerLeaveEvent{
@@ -287,29 +578,45 @@ func (proxy *Proxy) handleConn(conn Conn, connRemoteAddr net.Addr) error {
 			ProxyUID:      proxyUID,
 		})
 		playersConnected.With(prometheus.Labels{"host": proxyDomain}).Dec()
+		if opts.geoLabels {
+			playersConnectedGeo.With(geoLabels(proxyDomain, location)).Dec()
+		}
 	}
 
 	remainingPlayers := proxy.removePlayer(conn)
 	if remainingPlayers <= 0 {
 		proxy.timeoutProcess()
 	}
+
+	if isTimeout(err) {
+		return TimeoutError{Phase: timeoutPhase}
+	}
 	return nil
 }
 
-func pipe(src, dst Conn) {
+// pipe copies from src to dst until one of them fails and adds the copied bytes to written.
+// If idleTimeout is set, src has to send data within that time.
+func pipe(src Conn, dst io.Writer, idleTimeout time.Duration, written *int64) error {
 	buffer := make([]byte, 0xffff)
 
 	for {
+		if idleTimeout > 0 {
+			if err := setReadTimeout(src, idleTimeout); err != nil {
+				return err
+			}
+		}
+
 		n, err := src.Read(buffer)
 		if err != nil {
-			return
+			return err
 		}
 
 		data := buffer[:n]
 
-		_, err = dst.Write(data)
+		n, err = dst.Write(data)
+		atomic.AddInt64(written, int64(n))
 		if err != nil {
-			return
+			return err
 		}
 	}
 }
@@ -369,35 +676,155 @@ func (proxy *Proxy) cancelProcessTimeout() {
 	proxy.cancelTimeoutFunc = nil
 }
 
-func (proxy *Proxy) sniffUsername(conn, rconn Conn, connRemoteAddr net.Addr) (string, error) {
-	pk, err := conn.ReadPacket()
+func (proxy *Proxy) handleLoginRequest(conn Conn, loginStart login.ServerLoginStart) error {
+	message := proxy.DisconnectMessage()
+	templates := proxy.messageTemplates(conn, string(loginStart.Name))
+	return conn.WritePacket(disconnectPacket(applyTemplates(message, templates)))
+}
+
+// checkPlayer disconnects the player if they are banned or not whitelisted
+func (proxy *Proxy) checkPlayer(conn Conn, connRemoteAddr net.Addr, username string) error {
+	denial, err := proxy.playerLists.check(proxy.PlayerLists(), username, addrIP(connRemoteAddr), time.Now())
 	if err != nil {
-		return "", err
+		return err
 	}
-	rconn.WritePacket(pk)
 
-	ls, err := login.UnmarshalServerBoundLoginStart(pk)
-	if err != nil {
-		return "", err
+	if denial == nil {
+		return nil
 	}
-	log.Printf("[i] %s with username %s connects through %s", connRemoteAddr, ls.Name, proxy.UID())
-	return string(ls.Name), nil
+
+	log.Printf("[i] %s with username %s was denied by %s; reason: %s", connRemoteAddr, username, proxy.UID(), denial.Reason)
+	proxy.logEvent(callback.LoginDeniedEvent{
+		Username:      username,
+		RemoteAddress: connRemoteAddr.String(),
+		ProxyUID:      proxy.UID(),
+		Reason:        denial.Reason,
+	})
+
+	expires := denial.Entry.Expires
+	if expires == "" {
+		expires = "forever"
+	}
+
+	templates := proxy.messageTemplates(conn, username)
+	templates["reason"] = denial.Entry.Reason
+	templates["expires"] = expires
+	if err := conn.WritePacket(disconnectPacket(applyTemplates(denial.Message, templates))); err != nil {
+		return err
+	}
+
+	return ErrPlayerDenied
 }
 
-func (proxy *Proxy) handleLoginRequest(conn Conn) error {
-	packet, err := conn.ReadPacket()
-	if err != nil {
+// checkMaintenance disconnects the player if the proxy is under maintenance and they cannot bypass it
+func (proxy *Proxy) checkMaintenance(conn Conn, connRemoteAddr net.Addr, username string) error {
+	maintenance := proxy.Maintenance()
+	if !maintenance.IsActive(time.Now()) || maintenance.canBypass(username, addrIP(connRemoteAddr)) {
+		return nil
+	}
+
+	log.Printf("[i] %s with username %s was denied by %s; reason: %s", connRemoteAddr, username, proxy.UID(), LoginDeniedReasonMaintenance)
+	proxy.logEvent(callback.LoginDeniedEvent{
+		Username:      username,
+		RemoteAddress: connRemoteAddr.String(),
+		ProxyUID:      proxy.UID(),
+		Reason:        LoginDeniedReasonMaintenance,
+	})
+
+	end := "further notice"
+	if maintenance.End != nil {
+		end = maintenance.End.Format(time.RFC822)
+	}
+
+	templates := proxy.messageTemplates(conn, username)
+	templates["end"] = end
+	if err := conn.WritePacket(disconnectPacket(applyTemplates(maintenance.message(), templates))); err != nil {
 		return err
 	}
 
-	loginStart, err := login.UnmarshalServerBoundLoginStart(packet)
-	if err != nil {
+	return ErrMaintenance
+}
+
+// checkCapacity reserves a slot for the player or disconnects them if the proxy or the
+// listener is full. Priority players can always join.
+func (proxy *Proxy) checkCapacity(conn Conn, connRemoteAddr net.Addr, username string, slots listenerSlots) error {
+	max := proxy.MaxConnections()
+	if proxy.IsPriorityPlayer(username) {
+		slots.acquire(true)
+		proxy.reservePlayer(conn, username, 0)
+		return nil
+	}
+
+	if slots.acquire(false) {
+		if proxy.reservePlayer(conn, username, max) {
+			return nil
+		}
+		slots.release()
+	}
+
+	log.Printf("[i] %s with username %s was denied by %s; reason: %s", connRemoteAddr, username, proxy.UID(), LoginDeniedReasonServerFull)
+	proxy.logEvent(callback.LoginDeniedEvent{
+		Username:      username,
+		RemoteAddress: connRemoteAddr.String(),
+		ProxyUID:      proxy.UID(),
+		Reason:        LoginDeniedReasonServerFull,
+	})
+
+	templates := proxy.messageTemplates(conn, username)
+	templates["players"] = strconv.Itoa(proxy.PlayerCount())
+	templates["maxConnections"] = strconv.Itoa(max)
+	if err := conn.WritePacket(disconnectPacket(applyTemplates(proxy.ServerFullMessage(), templates))); err != nil {
 		return err
 	}
 
-	message := proxy.DisconnectMessage()
-	templates := map[string]string{
-		"username":      string(loginStart.Name),
+	return ErrServerFull
+}
+
+// registerPlayer adds the player to the registry of the gateway and
+// disconnects them if they are already connected and the policy rejects them.
+// If the policy kicks players, it returns the session that their kick is sent through.
+func (proxy *Proxy) registerPlayer(conn Conn, protocolVersion int, connRemoteAddr net.Addr, username string, opts connOptions) (*clientSession, func(), error) {
+	if opts.players == nil {
+		return nil, func() {}, nil
+	}
+
+	cfg := proxy.DuplicateLogin().merge(opts.duplicateLogin)
+	// Only kicked players need a session, since following their packets costs time
+	var session *clientSession
+	if cfg.Policy == DuplicateLoginPolicyKick {
+		session = newClientSession(conn, protocolVersion)
+	}
+
+	unregister, err := opts.players.register(cfg, conn, session, PlayerInfo{
+		Username:      username,
+		UUID:          offlinePlayerUUID(username),
+		ProxyUID:      proxy.UID(),
+		RemoteAddress: connRemoteAddr.String(),
+		ConnectedAt:   time.Now(),
+	})
+	if err == nil {
+		return session, unregister, nil
+	}
+
+	log.Printf("[i] %s with username %s was denied by %s; reason: %s", connRemoteAddr, username, proxy.UID(), LoginDeniedReasonDuplicateLogin)
+	proxy.logEvent(callback.LoginDeniedEvent{
+		Username:      username,
+		RemoteAddress: connRemoteAddr.String(),
+		ProxyUID:      proxy.UID(),
+		Reason:        LoginDeniedReasonDuplicateLogin,
+	})
+
+	message := applyTemplates(cfg.message(), proxy.messageTemplates(conn, username))
+	if err := conn.WritePacket(disconnectPacket(message)); err != nil {
+		return nil, nil, err
+	}
+	return nil, nil, err
+}
+
+// messageTemplates returns the values of the placeholders that are available in messages
+func (proxy *Proxy) messageTemplates(conn Conn, username string) map[string]string {
+	return map[string]string{
+		"username":      username,
 		"now":           time.Now().Format(time.RFC822),
 		"remoteAddress": conn.LocalAddr().String(),
 		"localAddress":  conn.LocalAddr().String(),
@@ -405,24 +832,31 @@ func (proxy *Proxy) handleLoginRequest(conn Conn) error {
 		"proxyTo":       proxy.ProxyTo(),
 		"listenTo":      proxy.ListenTo(),
 	}
+}
 
+func applyTemplates(message string, templates map[string]string) string {
 	for key, value := range templates {
 		message = strings.Replace(message, fmt.Sprintf("{{%s}}", key), value, -1)
 	}
+	return message
+}
+
+// disconnectPacket creates a login disconnect packet with the message as plain chat text
+func disconnectPacket(message string) protocol.Packet {
+	reason, _ := json.Marshal(struct {
+		Text string `json:"text"`
+	}{
+		Text: message,
+	})
 
-	return conn.WritePacket(login.ClientBoundDisconnect{
-		Reason: protocol.Chat(fmt.Sprintf("{\"text\":\"%s\"}", message)),
-	}.Marshal())
+	return login.ClientBoundDisconnect{
+		Reason: protocol.Chat(reason),
+	}.Marshal()
 }
 
 func (proxy *Proxy) handleStatusRequest(conn Conn, online bool) error {
-	// Read the request packet and send status response back
-	_, err := conn.ReadPacket()
-	if err != nil {
-		return err
-	}
-
 	var responsePk protocol.Packet
+	var err error
 	if online {
 		responsePk, err = proxy.OnlineStatusPacket()
 		if err != nil {
@@ -435,14 +869,108 @@ func (proxy *Proxy) handleStatusRequest(conn Conn, online bool) error {
 		}
 	}
 
+	return exchangeStatus(conn, responsePk)
+}
+
+// exchangeStatus reads the request packet, sends the status response back and answers the ping
+func exchangeStatus(conn Conn, responsePk protocol.Packet) error {
+	_, err := conn.ReadPacket()
+	if err != nil {
+		return phaseError(err, TimeoutPhaseStatus)
+	}
+
 	if err := conn.WritePacket(responsePk); err != nil {
 		return err
 	}
 
 	pingPk, err := conn.ReadPacket()
 	if err != nil {
-		return err
+		return phaseError(err, TimeoutPhaseStatus)
 	}
 
 	return conn.WritePacket(pingPk)
 }
+
+// cachedStatusPacket returns the status response of the server without asking it more
+// than once per ttl. The maintenance and configured online status take precedence
+// and the offline status is used if the server does not respond.
+func (proxy *Proxy) cachedStatusPacket(ttl time.Duration) (protocol.Packet, error) {
+	if maintenance := proxy.Maintenance(); maintenance.IsActive(time.Now()) {
+		return proxy.MaintenanceStatusPacket()
+	}
+
+	if proxy.IsOnlineStatusConfigured() {
+		return proxy.OnlineStatusPacket()
+	}
+
+	proxy.statusMu.Lock()
+	defer proxy.statusMu.Unlock()
+
+	if proxy.cachedStatus.Data != nil && time.Since(proxy.cachedStatusAt) < ttl {
+		return proxy.cachedStatus, nil
+	}
+
+	responsePk, err := proxy.fetchStatusPacket()
+	if err != nil {
+		log.Printf("[i] %s did not respond to status request; error: %s", proxy.ProxyTo(), err)
+		responsePk, err = proxy.OfflineStatusPacket()
+		if err != nil {
+			return responsePk, err
+		}
+	}
+
+	proxy.cachedStatus = responsePk
+	proxy.cachedStatusAt = time.Now()
+	return responsePk, nil
+}
+
+// fetchStatusPacket requests the status response from the server
+func (proxy *Proxy) fetchStatusPacket() (protocol.Packet, error) {
+	dialer, err := proxy.Dialer()
+	if err != nil {
+		return protocol.Packet{}, err
+	}
+
+	rconn, err := dialer.Dial(proxy.ProxyTo())
+	if err != nil {
+		return protocol.Packet{}, err
+	}
+	defer rconn.Close()
+
+	if err := setReadTimeout(rconn, proxy.Timeout()); err != nil {
+		return protocol.Packet{}, err
+	}
+
+	if proxy.ProxyProtocol() {
+		header := newProxyProtocolHeader(proxy.ProxyProtocolVersion(), rconn.LocalAddr(), rconn.RemoteAddr())
+		if _, err = header.WriteTo(rconn); err != nil {
+			return protocol.Packet{}, err
+		}
+	}
+
+	serverAddress := proxy.SpoofForcedHost()
+	if serverAddress == "" {
+		serverAddress = proxy.DomainName()
+	}
+
+	var serverPort protocol.UnsignedShort
+	if tcpAddr, ok := rconn.RemoteAddr().(*net.TCPAddr); ok {
+		serverPort = protocol.UnsignedShort(tcpAddr.Port)
+	}
+
+	hs := handshaking.ServerBoundHandshake{
+		ProtocolVersion: protocol.VarInt(-1),
+		ServerAddress:   protocol.String(serverAddress),
+		ServerPort:      serverPort,
+		NextState:       handshaking.ServerBoundHandshakeStatusState,
+	}
+	if err := rconn.WritePacket(hs.Marshal()); err != nil {
+		return protocol.Packet{}, err
+	}
+
+	if err := rconn.WritePacket(status.ServerBoundRequest{}.Marshal()); err != nil {
+		return protocol.Packet{}, err
+	}
+
+	return rconn.ReadPacket()
+}
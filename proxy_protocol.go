@@ -0,0 +1,81 @@
+package infrared
+
+import (
+	"net"
+
+	"github.com/gofrs/uuid"
+	"github.com/pires/go-proxyproto"
+)
+
+// newProxyProtocolHeader creates a PROXY protocol header for the given client and server address.
+// The transport protocol is picked by the address family of the client. If the server address does
+// not share the client's family, it is replaced by the unspecified address of the client's family,
+// because neither v1 nor v2 headers can carry mixed address families.
+func newProxyProtocolHeader(version int, clientAddr, serverAddr net.Addr) *proxyproto.Header {
+	if version != 1 {
+		version = 2
+	}
+
+	srcAddr, ok := clientAddr.(*net.TCPAddr)
+	if !ok {
+		return &proxyproto.Header{
+			Version:           byte(version),
+			Command:           proxyproto.LOCAL,
+			TransportProtocol: proxyproto.UNSPEC,
+		}
+	}
+
+	dstAddr, ok := serverAddr.(*net.TCPAddr)
+	if !ok {
+		dstAddr = &net.TCPAddr{}
+	}
+
+	transportProtocol := proxyproto.TCPv4
+	unspecifiedIP := net.IPv4zero
+	if srcAddr.IP.To4() == nil {
+		transportProtocol = proxyproto.TCPv6
+		unspecifiedIP = net.IPv6unspecified
+	}
+
+	if (srcAddr.IP.To4() == nil) != (dstAddr.IP.To4() == nil) {
+		dstAddr = &net.TCPAddr{
+			IP:   unspecifiedIP,
+			Port: dstAddr.Port,
+		}
+	}
+
+	return &proxyproto.Header{
+		Version:           byte(version),
+		Command:           proxyproto.PROXY,
+		TransportProtocol: transportProtocol,
+		SourceAddr:        srcAddr,
+		DestinationAddr:   dstAddr,
+	}
+}
+
+// proxyProtocolTLVs creates the TLVs that are enabled in the config.
+// The authority is the hostname that the client used to connect.
+func proxyProtocolTLVs(cfg ProxyProtocolTLVConfig, authority string) ([]proxyproto.TLV, error) {
+	var tlvs []proxyproto.TLV
+
+	if cfg.Authority && authority != "" {
+		tlvs = append(tlvs, proxyproto.TLV{
+			Type:  proxyproto.PP2_TYPE_AUTHORITY,
+			Value: []byte(authority),
+		})
+	}
+
+	if cfg.UniqueID {
+		id, err := uuid.NewV4()
+		if err != nil {
+			return nil, err
+		}
+
+		tlvs = append(tlvs, proxyproto.TLV{
+			Type:  proxyproto.PP2_TYPE_UNIQUE_ID,
+			Value: id.Bytes(),
+		})
+	}
+
+	return tlvs, nil
+}
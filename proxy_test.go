@@ -0,0 +1,46 @@
+package infrared
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxy_TimeoutProcess_Pterodactyl(t *testing.T) {
+	stopped := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/client/servers/1a7ce997/resources":
+			fmt.Fprint(w, `{"object":"stats","attributes":{"current_state":"running"}}`)
+		case "/api/client/servers/1a7ce997/power":
+			bb, _ := ioutil.ReadAll(r.Body)
+			if strings.TrimSpace(string(bb)) != `{"signal":"stop"}` {
+				t.Errorf("unexpected power action %s", bb)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			close(stopped)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &ProxyConfig{}
+	cfg.Docker.Timeout = 10
+	cfg.Docker.Pterodactyl.URL = server.URL
+	cfg.Docker.Pterodactyl.ServerID = "1a7ce997"
+	cfg.Docker.Pterodactyl.APIKey = "ptlc_key"
+	proxy := &Proxy{Config: cfg}
+
+	proxy.timeoutProcess()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("server was not stopped after the timeout")
+	}
+}
@@ -0,0 +1,252 @@
+package infrared
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+)
+
+var (
+	rateLimitedConnections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "infrared_rate_limited",
+		Help: "The total number of connections rejected by rate limits",
+	}, []string{"listener", "host", "reason"})
+)
+
+const (
+	RateLimitReasonIP         = "ip"
+	RateLimitReasonSubnet     = "subnet"
+	RateLimitReasonConcurrent = "concurrent"
+	RateLimitReasonStatus     = "status"
+	RateLimitReasonLogin      = "login"
+)
+
+// rateLimiterIdleTimeout is the time after which unused limiters are removed
+const rateLimiterIdleTimeout = 5 * time.Minute
+
+var ErrRateLimited = errors.New("rate limited")
+
+// RateLimit is a token bucket that refills with Rate tokens per second
+// and holds up to Burst tokens. A Rate of zero disables the limit.
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// ParseRateLimit parses a rate limit in the form "rate" or "rate:burst"
+func ParseRateLimit(s string) (RateLimit, error) {
+	if s == "" {
+		return RateLimit{}, nil
+	}
+
+	segments := strings.SplitN(s, ":", 2)
+	r, err := strconv.ParseFloat(segments[0], 64)
+	if err != nil {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q", s)
+	}
+
+	limit := RateLimit{Rate: r}
+	if len(segments) > 1 {
+		limit.Burst, err = strconv.Atoi(segments[1])
+		if err != nil {
+			return RateLimit{}, fmt.Errorf("invalid rate limit %q", s)
+		}
+	}
+
+	return limit, nil
+}
+
+func (limit RateLimit) IsEnabled() bool {
+	return limit.Rate > 0
+}
+
+func (limit RateLimit) burst() int {
+	if limit.Burst > 0 {
+		return limit.Burst
+	}
+	return int(math.Max(1, math.Ceil(limit.Rate)))
+}
+
+// HandshakeRateLimitConfig limits the handshakes of a single IP by their next state
+type HandshakeRateLimitConfig struct {
+	Status RateLimit `json:"status"`
+	Login  RateLimit `json:"login"`
+}
+
+// ListenerRateLimitConfig limits the connections that a single listener accepts
+type ListenerRateLimitConfig struct {
+	// PerIP limits new connections per source IP
+	PerIP RateLimit
+	// PerSubnet limits new connections per /24 IPv4 or /64 IPv6 network
+	PerSubnet RateLimit
+	// MaxConcurrentPerIP limits open connections per source IP. Zero disables the limit.
+	MaxConcurrentPerIP int
+	Handshake          HandshakeRateLimitConfig
+}
+
+type rateLimiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter keeps a token bucket for every key
+type rateLimiter struct {
+	mu        sync.Mutex
+	limiters  map[string]*rateLimiterEntry
+	lastSweep time.Time
+}
+
+func (rl *rateLimiter) allow(key string, limit RateLimit, now time.Time) bool {
+	if !limit.IsEnabled() {
+		return true
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.limiters == nil {
+		rl.limiters = map[string]*rateLimiterEntry{}
+	}
+	rl.sweep(now)
+
+	entry, ok := rl.limiters[key]
+	if !ok {
+		entry = &rateLimiterEntry{
+			limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.burst()),
+		}
+		rl.limiters[key] = entry
+	}
+	entry.lastSeen = now
+
+	// The limit may have changed due to a config reload
+	if entry.limiter.Limit() != rate.Limit(limit.Rate) {
+		entry.limiter.SetLimitAt(now, rate.Limit(limit.Rate))
+	}
+	if entry.limiter.Burst() != limit.burst() {
+		entry.limiter.SetBurstAt(now, limit.burst())
+	}
+
+	return entry.limiter.AllowN(now, 1)
+}
+
+func (rl *rateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < rateLimiterIdleTimeout {
+		return
+	}
+	rl.lastSweep = now
+
+	for key, entry := range rl.limiters {
+		if now.Sub(entry.lastSeen) > rateLimiterIdleTimeout {
+			delete(rl.limiters, key)
+		}
+	}
+}
+
+// connCounter counts the open connections per key
+type connCounter struct {
+	mu    sync.Mutex
+	conns map[string]int
+}
+
+func (cc *connCounter) acquire(key string, max int) bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if cc.conns == nil {
+		cc.conns = map[string]int{}
+	}
+
+	if max > 0 && cc.conns[key] >= max {
+		return false
+	}
+
+	cc.conns[key]++
+	return true
+}
+
+func (cc *connCounter) release(key string) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	cc.conns[key]--
+	if cc.conns[key] <= 0 {
+		delete(cc.conns, key)
+	}
+}
+
+// handshakeLimiter keeps separate budgets for status and login handshakes
+type handshakeLimiter struct {
+	status rateLimiter
+	login  rateLimiter
+}
+
+// allow returns the reason if the handshake exceeds its budget
+func (hl *handshakeLimiter) allow(ip string, isLogin bool, cfg HandshakeRateLimitConfig, now time.Time) (string, bool) {
+	if isLogin {
+		return RateLimitReasonLogin, hl.login.allow(ip, cfg.Login, now)
+	}
+	return RateLimitReasonStatus, hl.status.allow(ip, cfg.Status, now)
+}
+
+// listenerLimiter holds the rate limit state of a single listener
+type listenerLimiter struct {
+	perIP     rateLimiter
+	perSubnet rateLimiter
+	handshake handshakeLimiter
+	conns     connCounter
+}
+
+// acquire checks the connection limits of the listener before anything was read from the connection.
+// If the connection is allowed the returned release func must be called after the connection was closed.
+func (ll *listenerLimiter) acquire(ip net.IP, cfg ListenerRateLimitConfig, now time.Time) (func(), string, bool) {
+	key := ip.String()
+	if !ll.perIP.allow(key, cfg.PerIP, now) {
+		return nil, RateLimitReasonIP, false
+	}
+
+	if !ll.perSubnet.allow(subnetKey(ip), cfg.PerSubnet, now) {
+		return nil, RateLimitReasonSubnet, false
+	}
+
+	if !ll.conns.acquire(key, cfg.MaxConcurrentPerIP) {
+		return nil, RateLimitReasonConcurrent, false
+	}
+
+	return func() {
+		ll.conns.release(key)
+	}, "", true
+}
+
+// allowHandshake checks the handshake against the status and login budgets of the listener
+func (ll *listenerLimiter) allowHandshake(ip net.IP, isLogin bool, cfg ListenerRateLimitConfig, now time.Time) (string, bool) {
+	return ll.handshake.allow(ip.String(), isLogin, cfg.Handshake, now)
+}
+
+// subnetKey returns the /24 network of IPv4 and the /64 network of IPv6 addresses
+func subnetKey(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+func addrIP(addr net.Addr) net.IP {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,230 @@
+package infrared
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tt := []struct {
+		limit       string
+		expected    RateLimit
+		expectError bool
+	}{
+		{
+			limit:    "",
+			expected: RateLimit{},
+		},
+		{
+			limit:    "5",
+			expected: RateLimit{Rate: 5},
+		},
+		{
+			limit:    "0.5:10",
+			expected: RateLimit{Rate: 0.5, Burst: 10},
+		},
+		{
+			limit:       "five",
+			expectError: true,
+		},
+		{
+			limit:       "5:ten",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		limit, err := ParseRateLimit(tc.limit)
+		if tc.expectError {
+			if err == nil {
+				t.Errorf("%q: expected an error", tc.limit)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: %v", tc.limit, err)
+			continue
+		}
+
+		if limit != tc.expected {
+			t.Errorf("got: %v; want: %v", limit, tc.expected)
+		}
+	}
+}
+
+func TestRateLimiter_Allow(t *testing.T) {
+	now := time.Now()
+	limit := RateLimit{Rate: 1, Burst: 2}
+	rl := rateLimiter{}
+
+	if !rl.allow("a", RateLimit{}, now) {
+		t.Error("disabled limit should allow")
+	}
+
+	for i := 0; i < limit.Burst; i++ {
+		if !rl.allow("a", limit, now) {
+			t.Fatalf("connection %d should be allowed", i)
+		}
+	}
+
+	if rl.allow("a", limit, now) {
+		t.Error("connection after burst should be limited")
+	}
+
+	if !rl.allow("b", limit, now) {
+		t.Error("other keys should have their own budget")
+	}
+
+	if !rl.allow("a", limit, now.Add(time.Second)) {
+		t.Error("bucket should refill")
+	}
+
+	if !rl.allow("a", RateLimit{Rate: 100, Burst: 100}, now.Add(2*time.Second)) {
+		t.Error("changed limit should be applied")
+	}
+
+	rl.allow("c", limit, now.Add(2*rateLimiterIdleTimeout))
+	if _, ok := rl.limiters["a"]; ok {
+		t.Error("idle limiters should be removed")
+	}
+}
+
+func TestConnCounter(t *testing.T) {
+	cc := connCounter{}
+
+	if !cc.acquire("a", 2) || !cc.acquire("a", 2) {
+		t.Fatal("connections below max should be allowed")
+	}
+
+	if cc.acquire("a", 2) {
+		t.Error("connection above max should be limited")
+	}
+
+	cc.release("a")
+	if !cc.acquire("a", 2) {
+		t.Error("released connection should free a slot")
+	}
+
+	if !cc.acquire("b", 0) {
+		t.Error("zero max should not limit")
+	}
+}
+
+func TestSubnetKey(t *testing.T) {
+	tt := []struct {
+		ip       string
+		expected string
+	}{
+		{
+			ip:       "109.226.143.210",
+			expected: "109.226.143.0/24",
+		},
+		{
+			ip:       "2001:db8:1:2:3:4:5:6",
+			expected: "2001:db8:1:2::/64",
+		},
+	}
+
+	for _, tc := range tt {
+		if key := subnetKey(net.ParseIP(tc.ip)); key != tc.expected {
+			t.Errorf("got: %v; want: %v", key, tc.expected)
+		}
+	}
+}
+
+func TestListenerLimiter_Acquire(t *testing.T) {
+	now := time.Now()
+	ip := net.ParseIP("109.226.143.210")
+	neighbourIP := net.ParseIP("109.226.143.211")
+
+	tt := []struct {
+		name     string
+		cfg      ListenerRateLimitConfig
+		ips      []net.IP
+		isLogin  bool
+		expected string
+	}{
+		{
+			name: "PerIP",
+			cfg: ListenerRateLimitConfig{
+				PerIP: RateLimit{Rate: 1},
+			},
+			ips:      []net.IP{ip, ip},
+			expected: RateLimitReasonIP,
+		},
+		{
+			name: "PerSubnet",
+			cfg: ListenerRateLimitConfig{
+				PerSubnet: RateLimit{Rate: 1},
+			},
+			ips:      []net.IP{ip, neighbourIP},
+			expected: RateLimitReasonSubnet,
+		},
+		{
+			name: "Status",
+			cfg: ListenerRateLimitConfig{
+				Handshake: HandshakeRateLimitConfig{
+					Status: RateLimit{Rate: 1},
+				},
+			},
+			ips:      []net.IP{ip, ip},
+			expected: RateLimitReasonStatus,
+		},
+		{
+			name: "LoginNotLimitedByStatus",
+			cfg: ListenerRateLimitConfig{
+				Handshake: HandshakeRateLimitConfig{
+					Status: RateLimit{Rate: 1},
+				},
+			},
+			ips:      []net.IP{ip, ip},
+			isLogin:  true,
+			expected: "",
+		},
+		{
+			name: "Login",
+			cfg: ListenerRateLimitConfig{
+				Handshake: HandshakeRateLimitConfig{
+					Login: RateLimit{Rate: 1},
+				},
+			},
+			ips:      []net.IP{ip, ip},
+			isLogin:  true,
+			expected: RateLimitReasonLogin,
+		},
+		{
+			name: "Concurrent",
+			cfg: ListenerRateLimitConfig{
+				MaxConcurrentPerIP: 1,
+			},
+			ips:      []net.IP{ip, ip},
+			expected: RateLimitReasonConcurrent,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ll := listenerLimiter{}
+			reason := ""
+			for _, ip := range tc.ips {
+				var ok bool
+				_, reason, ok = ll.acquire(ip, tc.cfg, now)
+				if !ok {
+					break
+				}
+
+				reason, ok = ll.allowHandshake(ip, tc.isLogin, tc.cfg, now)
+				if !ok {
+					break
+				}
+				reason = ""
+			}
+
+			if reason != tc.expected {
+				t.Errorf("got: %q; want: %q", reason, tc.expected)
+			}
+		})
+	}
+}
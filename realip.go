@@ -0,0 +1,159 @@
+package infrared
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/haveachin/infrared/protocol/handshaking"
+)
+
+// DefaultRealIPMaxAge is the maximum age of a RealIP handshake
+// if RealIPConfig.MaxAge is not set
+const DefaultRealIPMaxAge = 5 * time.Second
+
+var (
+	ErrRealIPUntrustedSource    = errors.New("RealIP handshake from untrusted source")
+	ErrRealIPExpired            = errors.New("RealIP handshake expired")
+	ErrRealIPMissingSignature   = errors.New("RealIP handshake is not signed")
+	ErrRealIPInvalidSignature   = errors.New("RealIP handshake has an invalid signature")
+	ErrRealIPUnsupportedKeyType = errors.New("RealIP public key is not an ECDSA key")
+)
+
+// RealIPConfig configures which RealIP handshakes a Gateway trusts
+type RealIPConfig struct {
+	// TrustedCIDRs are the networks that are allowed to send RealIP handshakes.
+	// If empty, no source is trusted.
+	TrustedCIDRs []*net.IPNet
+	// PublicKey is used to verify the signature of RealIP handshakes.
+	// If nil, signatures are not checked.
+	PublicKey *ecdsa.PublicKey
+	// MaxAge is the maximum age of the handshake timestamp
+	MaxAge time.Duration
+}
+
+// ParseCIDRs parses a comma separated list of CIDRs.
+// Plain IPs are interpreted as a single host network.
+func ParseCIDRs(cidrs string) ([]*net.IPNet, error) {
+	var ipNets []*net.IPNet
+	for _, cidr := range strings.Split(cidrs, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %q", cidr)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			ipNets = append(ipNets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		ipNets = append(ipNets, ipNet)
+	}
+
+	return ipNets, nil
+}
+
+// LoadRealIPPublicKey loads a PEM encoded ECDSA public key from a file
+func LoadRealIPPublicKey(path string) (*ecdsa.PublicKey, error) {
+	bb, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseRealIPPublicKey(bb)
+}
+
+// ParseRealIPPublicKey parses a PEM or base64 DER encoded ECDSA public key
+func ParseRealIPPublicKey(bb []byte) (*ecdsa.PublicKey, error) {
+	der := bb
+	if block, _ := pem.Decode(bb); block != nil {
+		der = block.Bytes
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	ecdsaKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, ErrRealIPUnsupportedKeyType
+	}
+
+	return ecdsaKey, nil
+}
+
+func (cfg RealIPConfig) isTrustedSource(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	for _, ipNet := range cfg.TrustedCIDRs {
+		if ipNet.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Verify checks if the RealIP handshake comes from a trusted source, is not expired
+// and is correctly signed. It returns the client address embedded in the handshake.
+func (cfg RealIPConfig) Verify(hs handshaking.ServerBoundHandshake, sourceAddr net.Addr, now time.Time) (net.Addr, error) {
+	if !cfg.isTrustedSource(sourceAddr) {
+		return nil, ErrRealIPUntrustedSource
+	}
+
+	realIP, err := hs.ParseRealIP()
+	if err != nil {
+		return nil, err
+	}
+
+	maxAge := cfg.MaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultRealIPMaxAge
+	}
+
+	// The timestamp only has a precision of seconds
+	age := now.Sub(realIP.Timestamp)
+	if age > maxAge+time.Second || age < -maxAge {
+		return nil, ErrRealIPExpired
+	}
+
+	if cfg.PublicKey == nil {
+		return realIP.ClientAddr, nil
+	}
+
+	if len(realIP.Signature) == 0 {
+		return nil, ErrRealIPMissingSignature
+	}
+
+	digest := sha512.Sum512([]byte(realIP.SignedData))
+	if !ecdsa.VerifyASN1(cfg.PublicKey, digest[:], realIP.Signature) {
+		return nil, ErrRealIPInvalidSignature
+	}
+
+	return realIP.ClientAddr, nil
+}
@@ -0,0 +1,218 @@
+package infrared
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/haveachin/infrared/protocol"
+	"github.com/haveachin/infrared/protocol/handshaking"
+)
+
+func realIPHandshake(signedData string, signature []byte) handshaking.ServerBoundHandshake {
+	addr := signedData
+	if signature != nil {
+		addr = fmt.Sprintf("%s///%s", addr, base64.StdEncoding.EncodeToString(signature))
+	}
+	return handshaking.ServerBoundHandshake{ServerAddress: protocol.String(addr)}
+}
+
+func signRealIP(t *testing.T, key *ecdsa.PrivateKey, data string) []byte {
+	digest := sha512.Sum512([]byte(data))
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signature
+}
+
+func TestParseCIDRs(t *testing.T) {
+	tt := []struct {
+		cidrs       string
+		expected    []string
+		expectError bool
+	}{
+		{
+			cidrs:    "",
+			expected: nil,
+		},
+		{
+			cidrs:    "10.0.0.0/8, 192.168.1.1",
+			expected: []string{"10.0.0.0/8", "192.168.1.1/32"},
+		},
+		{
+			cidrs:    "2001:db8::/32,::1",
+			expected: []string{"2001:db8::/32", "::1/128"},
+		},
+		{
+			cidrs:       "10.0.0.0/33",
+			expectError: true,
+		},
+		{
+			cidrs:       "example.com",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tt {
+		ipNets, err := ParseCIDRs(tc.cidrs)
+		if tc.expectError {
+			if err == nil {
+				t.Errorf("%q: expected an error", tc.cidrs)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: %v", tc.cidrs, err)
+			continue
+		}
+
+		if len(ipNets) != len(tc.expected) {
+			t.Errorf("got: %v; want: %v", ipNets, tc.expected)
+			continue
+		}
+
+		for i, ipNet := range ipNets {
+			if ipNet.String() != tc.expected[i] {
+				t.Errorf("got: %v; want: %v", ipNet, tc.expected[i])
+			}
+		}
+	}
+}
+
+func TestParseRealIPPublicKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	publicKey, err := ParseRealIPPublicKey(pemKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !publicKey.Equal(&key.PublicKey) {
+		t.Error("parsed key does not match")
+	}
+
+	if _, err := ParseRealIPPublicKey([]byte("not a key")); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestRealIPConfig_Verify(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trustedCIDRs, err := ParseCIDRs("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1620000000, 0)
+	signedData := fmt.Sprintf("example.com///109.226.143.210:25565///%d", now.Unix())
+	expiredData := fmt.Sprintf("example.com///109.226.143.210:25565///%d", now.Add(-time.Minute).Unix())
+	trustedAddr := &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 1234}
+	untrustedAddr := &net.TCPAddr{IP: net.ParseIP("11.1.2.3"), Port: 1234}
+
+	tt := []struct {
+		name        string
+		cfg         RealIPConfig
+		hs          handshaking.ServerBoundHandshake
+		sourceAddr  net.Addr
+		expectedErr error
+	}{
+		{
+			name:        "NoTrustedSources",
+			cfg:         RealIPConfig{},
+			hs:          realIPHandshake(signedData, nil),
+			sourceAddr:  trustedAddr,
+			expectedErr: ErrRealIPUntrustedSource,
+		},
+		{
+			name:       "TrustedSource",
+			cfg:        RealIPConfig{TrustedCIDRs: trustedCIDRs},
+			hs:         realIPHandshake(signedData, nil),
+			sourceAddr: trustedAddr,
+		},
+		{
+			name:        "UntrustedSource",
+			cfg:         RealIPConfig{TrustedCIDRs: trustedCIDRs},
+			hs:          realIPHandshake(signedData, nil),
+			sourceAddr:  untrustedAddr,
+			expectedErr: ErrRealIPUntrustedSource,
+		},
+		{
+			name:        "Expired",
+			cfg:         RealIPConfig{TrustedCIDRs: trustedCIDRs},
+			hs:          realIPHandshake(expiredData, nil),
+			sourceAddr:  trustedAddr,
+			expectedErr: ErrRealIPExpired,
+		},
+		{
+			name:       "CustomMaxAge",
+			cfg:        RealIPConfig{TrustedCIDRs: trustedCIDRs, MaxAge: 2 * time.Minute},
+			hs:         realIPHandshake(expiredData, nil),
+			sourceAddr: trustedAddr,
+		},
+		{
+			name:       "ValidSignature",
+			cfg:        RealIPConfig{TrustedCIDRs: trustedCIDRs, PublicKey: &key.PublicKey},
+			hs:         realIPHandshake(signedData, signRealIP(t, key, signedData)),
+			sourceAddr: trustedAddr,
+		},
+		{
+			name:        "InvalidSignature",
+			cfg:         RealIPConfig{TrustedCIDRs: trustedCIDRs, PublicKey: &key.PublicKey},
+			hs:          realIPHandshake(signedData, signRealIP(t, otherKey, signedData)),
+			sourceAddr:  trustedAddr,
+			expectedErr: ErrRealIPInvalidSignature,
+		},
+		{
+			name:        "MissingSignature",
+			cfg:         RealIPConfig{TrustedCIDRs: trustedCIDRs, PublicKey: &key.PublicKey},
+			hs:          realIPHandshake(signedData, nil),
+			sourceAddr:  trustedAddr,
+			expectedErr: ErrRealIPMissingSignature,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			clientAddr, err := tc.cfg.Verify(tc.hs, tc.sourceAddr, now)
+			if err != tc.expectedErr {
+				t.Fatalf("got: %v; want: %v", err, tc.expectedErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if clientAddr.String() != "109.226.143.210:25565" {
+				t.Errorf("got: %v; want: %v", clientAddr, "109.226.143.210:25565")
+			}
+		})
+	}
+}
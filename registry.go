@@ -0,0 +1,176 @@
+package infrared
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DuplicateLoginPolicyAllow lets players join multiple times
+	DuplicateLoginPolicyAllow = "allow"
+	// DuplicateLoginPolicyReject disconnects the new connection of a player that is already connected
+	DuplicateLoginPolicyReject = "reject"
+	// DuplicateLoginPolicyKick disconnects the old connection of a player in favor of the new one
+	DuplicateLoginPolicyKick = "kick"
+
+	// DuplicateLoginKickMessage is sent to the old connection of a kicked player
+	DuplicateLoginKickMessage = "You logged in from another location"
+
+	DefaultDuplicateLoginMessage = "You are already connected to this server!"
+
+	LoginDeniedReasonDuplicateLogin = "duplicateLogin"
+)
+
+var ErrDuplicateLogin = errors.New("duplicate login")
+
+// DuplicateLoginConfig decides what happens if a player connects while already being connected
+type DuplicateLoginConfig struct {
+	// Policy is one of "allow", "reject" or "kick"
+	Policy string `json:"policy"`
+	// Message is sent to rejected players
+	Message string `json:"message"`
+}
+
+// merge returns the config with the unset fields taken from the other config
+func (cfg DuplicateLoginConfig) merge(other DuplicateLoginConfig) DuplicateLoginConfig {
+	if cfg.Policy == "" {
+		cfg.Policy = other.Policy
+	}
+	if cfg.Message == "" {
+		cfg.Message = other.Message
+	}
+	return cfg
+}
+
+// key matches players case-insensitively by name only. Without the authentication of the
+// server Infrared has no real UUID, so UUIDs would only be derived from the name.
+func (cfg DuplicateLoginConfig) key(username string) string {
+	return strings.ToLower(username)
+}
+
+func (cfg DuplicateLoginConfig) message() string {
+	if cfg.Message != "" {
+		return cfg.Message
+	}
+	return DefaultDuplicateLoginMessage
+}
+
+// PlayerInfo describes a player that is connected through the gateway
+type PlayerInfo struct {
+	Username      string    `json:"username"`
+	UUID          string    `json:"uuid"`
+	ProxyUID      string    `json:"proxyUid"`
+	RemoteAddress string    `json:"remoteAddress"`
+	ConnectedAt   time.Time `json:"connectedAt"`
+}
+
+type registeredPlayer struct {
+	PlayerInfo
+	conn Conn
+	// session is only set if the player can be disconnected with a reason
+	session *clientSession
+}
+
+// kick disconnects the player with the message if the session of the player
+// is known and closes the connection
+func (player registeredPlayer) kick(message string) {
+	if player.session == nil {
+		player.conn.Close()
+		return
+	}
+	player.session.kick(message)
+}
+
+// PlayerRegistry keeps track of the players of all proxies of a gateway
+type PlayerRegistry struct {
+	mu      sync.Mutex
+	players map[string]registeredPlayer
+}
+
+// register adds the player under the key of the config. If the player is already
+// connected, the policy decides if the new player is rejected or the old one is kicked.
+// The returned func removes the player again.
+func (registry *PlayerRegistry) register(cfg DuplicateLoginConfig, conn Conn, session *clientSession, info PlayerInfo) (func(), error) {
+	key := cfg.key(info.Username)
+
+	registry.mu.Lock()
+	if registry.players == nil {
+		registry.players = map[string]registeredPlayer{}
+	}
+
+	old, connected := registry.players[key]
+	if connected {
+		switch cfg.Policy {
+		case DuplicateLoginPolicyReject:
+			registry.mu.Unlock()
+			return nil, ErrDuplicateLogin
+		case DuplicateLoginPolicyKick:
+		default:
+			// Allowed duplicates are not tracked to not lose the first connection
+			registry.mu.Unlock()
+			return func() {}, nil
+		}
+	}
+
+	registry.players[key] = registeredPlayer{
+		PlayerInfo: info,
+		conn:       conn,
+		session:    session,
+	}
+	registry.mu.Unlock()
+
+	// The old player is kicked without holding the lock, since sending the disconnect can take a while
+	if connected {
+		log.Printf("[i] %s logged in from another location; disconnecting %s", info.Username, old.RemoteAddress)
+		old.kick(DuplicateLoginKickMessage)
+	}
+
+	return func() {
+		registry.mu.Lock()
+		defer registry.mu.Unlock()
+		if player, ok := registry.players[key]; ok && player.conn == conn {
+			delete(registry.players, key)
+		}
+	}, nil
+}
+
+// Players returns all connected players sorted by username
+func (registry *PlayerRegistry) Players() []PlayerInfo {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	players := make([]PlayerInfo, 0, len(registry.players))
+	for _, player := range registry.players {
+		players = append(players, player.PlayerInfo)
+	}
+
+	sort.Slice(players, func(i, j int) bool {
+		return strings.ToLower(players[i].Username) < strings.ToLower(players[j].Username)
+	})
+	return players
+}
+
+// Player looks up a connected player by username or offline mode UUID.
+// Exact matches win over case-insensitive ones, which are resolved by username.
+func (registry *PlayerRegistry) Player(nameOrUUID string) (PlayerInfo, bool) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	var match PlayerInfo
+	found, matchExact := false, false
+	for _, player := range registry.players {
+		exact := player.Username == nameOrUUID || player.UUID == nameOrUUID
+		if !exact && !strings.EqualFold(player.Username, nameOrUUID) && !strings.EqualFold(player.UUID, nameOrUUID) {
+			continue
+		}
+
+		if !found || exact && !matchExact || exact == matchExact && player.Username < match.Username {
+			match, found, matchExact = player.PlayerInfo, true, exact
+		}
+	}
+	return match, found
+}
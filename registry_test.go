@@ -0,0 +1,188 @@
+package infrared
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/haveachin/infrared/protocol"
+)
+
+func TestPlayerRegistry_Register(t *testing.T) {
+	tt := []struct {
+		name             string
+		cfg              DuplicateLoginConfig
+		protocolVersion  int
+		secondName       string
+		expectErr        bool
+		expectClosed     bool
+		expectDisconnect bool
+		expectedProxy    string
+	}{
+		{
+			name:          "Allow",
+			cfg:           DuplicateLoginConfig{Policy: DuplicateLoginPolicyAllow},
+			secondName:    "steve",
+			expectedProxy: "first",
+		},
+		{
+			name:          "Reject",
+			cfg:           DuplicateLoginConfig{Policy: DuplicateLoginPolicyReject},
+			secondName:    "steve",
+			expectErr:     true,
+			expectedProxy: "first",
+		},
+		{
+			name:             "Kick",
+			cfg:              DuplicateLoginConfig{Policy: DuplicateLoginPolicyKick},
+			protocolVersion:  763,
+			secondName:       "steve",
+			expectClosed:     true,
+			expectDisconnect: true,
+			expectedProxy:    "second",
+		},
+		{
+			name:            "KickUnknownVersion",
+			cfg:             DuplicateLoginConfig{Policy: DuplicateLoginPolicyKick},
+			protocolVersion: 5,
+			secondName:      "steve",
+			expectClosed:    true,
+			expectedProxy:   "second",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			registry := PlayerRegistry{}
+
+			firstConn, firstPeer := net.Pipe()
+			defer firstPeer.Close()
+			secondConn, secondPeer := net.Pipe()
+			defer secondPeer.Close()
+
+			// A kicked player receives a disconnect packet before the connection is closed
+			received := make(chan protocol.Packet, 1)
+			go func() {
+				pk, err := wrapConn(firstPeer).ReadPacket()
+				if err == nil {
+					received <- pk
+				}
+			}()
+
+			first := wrapConn(firstConn)
+			if _, err := registry.register(tc.cfg, first, newClientSession(first, tc.protocolVersion), PlayerInfo{Username: "Steve", ProxyUID: "first"}); err != nil {
+				t.Fatal(err)
+			}
+
+			second := wrapConn(secondConn)
+			unregister, err := registry.register(tc.cfg, second, newClientSession(second, tc.protocolVersion), PlayerInfo{Username: tc.secondName, ProxyUID: "second"})
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("got: %v; want error: %v", err, tc.expectErr)
+			}
+
+			if tc.expectDisconnect {
+				select {
+				case pk := <-received:
+					disconnect := disconnectPacket(DuplicateLoginKickMessage)
+					if pk.ID != disconnect.ID || !bytes.Equal(pk.Data, disconnect.Data) {
+						t.Errorf("got: %v; want the kick message", pk)
+					}
+				case <-time.After(time.Second):
+					t.Error("kicked player did not receive a disconnect packet")
+				}
+			}
+
+			// Writes to an open pipe block without a reader, so only a closed pipe fails fast
+			firstPeer.SetWriteDeadline(time.Now().Add(10 * time.Millisecond))
+			_, err = firstPeer.Write([]byte{0})
+			if closed := err == io.ErrClosedPipe; closed != tc.expectClosed {
+				t.Errorf("got: %v; want closed: %v", err, tc.expectClosed)
+			}
+
+			player, ok := registry.Player("Steve")
+			if !ok {
+				t.Fatal("player should be registered")
+			}
+
+			if player.ProxyUID != tc.expectedProxy {
+				t.Errorf("got: %v; want: %v", player.ProxyUID, tc.expectedProxy)
+			}
+
+			if unregister != nil {
+				unregister()
+			}
+		})
+	}
+}
+
+func TestPlayerRegistry_Unregister(t *testing.T) {
+	registry := PlayerRegistry{}
+	cfg := DuplicateLoginConfig{Policy: DuplicateLoginPolicyKick}
+
+	firstConn, firstPeer := net.Pipe()
+	defer firstPeer.Close()
+	secondConn, secondPeer := net.Pipe()
+	defer secondPeer.Close()
+	go io.Copy(io.Discard, firstPeer)
+
+	first := wrapConn(firstConn)
+	unregisterFirst, err := registry.register(cfg, first, newClientSession(first, 763), PlayerInfo{Username: "Steve", UUID: offlinePlayerUUID("Steve")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second := wrapConn(secondConn)
+	unregisterSecond, err := registry.register(cfg, second, newClientSession(second, 763), PlayerInfo{Username: "Steve", UUID: offlinePlayerUUID("Steve")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The kicked connection must not remove the new one
+	unregisterFirst()
+	if _, ok := registry.Player(offlinePlayerUUID("Steve")); !ok {
+		t.Fatal("player should still be registered")
+	}
+
+	unregisterSecond()
+	if players := registry.Players(); len(players) != 0 {
+		t.Errorf("got: %v; want no players", players)
+	}
+}
+
+func TestPlayerRegistry_Player(t *testing.T) {
+	registry := PlayerRegistry{}
+	cfg := DuplicateLoginConfig{Policy: DuplicateLoginPolicyReject}
+
+	for _, name := range []string{"Steve", "Alex"} {
+		conn, peer := net.Pipe()
+		defer peer.Close()
+		if _, err := registry.register(cfg, wrapConn(conn), nil, PlayerInfo{Username: name, UUID: offlinePlayerUUID(name), ProxyUID: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tt := []struct {
+		lookup   string
+		expected string
+	}{
+		{lookup: "Steve", expected: "Steve"},
+		{lookup: "sTeVe", expected: "Steve"},
+		{lookup: "alex", expected: "Alex"},
+		{lookup: offlinePlayerUUID("Steve"), expected: "Steve"},
+		{lookup: strings.ToUpper(offlinePlayerUUID("Alex")), expected: "Alex"},
+	}
+
+	for _, tc := range tt {
+		player, ok := registry.Player(tc.lookup)
+		if !ok || player.ProxyUID != tc.expected {
+			t.Errorf("%s: got: %v; want: %v", tc.lookup, player.ProxyUID, tc.expected)
+		}
+	}
+
+	if _, ok := registry.Player("Notch"); ok {
+		t.Error("unknown player should not be found")
+	}
+}
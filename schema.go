@@ -0,0 +1,91 @@
+package infrared
+
+import (
+	"reflect"
+	"time"
+)
+
+const jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// ProxyConfigSchema generates a JSON Schema of proxy config files from ProxyConfig.
+// Editors use it for autocompletion and validation of YAML and JSON files.
+func ProxyConfigSchema() map[string]interface{} {
+	defaultCfg := DefaultProxyConfig()
+	schema := typeSchema(reflect.TypeOf(&defaultCfg).Elem(), reflect.ValueOf(&defaultCfg).Elem())
+	schema["$schema"] = jsonSchemaDraft
+	schema["title"] = "Infrared proxy config"
+
+	// Bases can be a single path or a list of paths. Required fields like proxyTo
+	// are not required by the schema, because they can be set by a base.
+	properties := schema["properties"].(map[string]interface{})
+	properties["extends"] = map[string]interface{}{
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": "string"},
+			map[string]interface{}{
+				"type":  "array",
+				"items": map[string]interface{}{"type": "string"},
+			},
+		},
+	}
+	return schema
+}
+
+// typeSchema returns the schema of a type with the non-zero parts of def as defaults
+func typeSchema(t reflect.Type, def reflect.Value) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		if def.IsValid() {
+			def = def.Elem()
+		}
+	}
+
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{
+			"type":   "string",
+			"format": "date-time",
+		}
+	}
+
+	schema := map[string]interface{}{}
+	switch t.Kind() {
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := jsonFieldName(field)
+			if name == "" {
+				continue
+			}
+
+			var fieldDef reflect.Value
+			if def.IsValid() {
+				fieldDef = def.Field(i)
+			}
+			properties[name] = typeSchema(field.Type, fieldDef)
+		}
+		schema["type"] = "object"
+		schema["properties"] = properties
+		schema["additionalProperties"] = false
+		return schema
+	case reflect.Slice, reflect.Array:
+		schema["type"] = "array"
+		schema["items"] = typeSchema(t.Elem(), reflect.Value{})
+	case reflect.Map:
+		schema["type"] = "object"
+		schema["additionalProperties"] = typeSchema(t.Elem(), reflect.Value{})
+	case reflect.String:
+		schema["type"] = "string"
+	case reflect.Bool:
+		schema["type"] = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema["type"] = "integer"
+	case reflect.Float32, reflect.Float64:
+		schema["type"] = "number"
+	}
+
+	if def.IsValid() && !def.IsZero() {
+		schema["default"] = def.Interface()
+	}
+	return schema
+}
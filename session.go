@@ -0,0 +1,474 @@
+package infrared
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/haveachin/infrared/protocol"
+)
+
+const (
+	loginEncryptionRequestPacketID byte = 0x01
+	loginSuccessPacketID           byte = 0x02
+	loginSetCompressionPacketID    byte = 0x03
+
+	// kickTimeout is the time a kicked client has to receive the disconnect packet
+	kickTimeout = time.Second
+
+	// maxFrameLength is the largest packet length that fits into the three bytes of the protocol
+	maxFrameLength = 1<<21 - 1
+
+	// Since 1.20.2 the client is configured between the login and the play state
+	configurationProtocolVersion = 764
+	// Since 1.20.3 chat components are sent as NBT instead of JSON
+	nbtChatProtocolVersion = 765
+)
+
+type sessionState int
+
+const (
+	sessionStateLogin sessionState = iota
+	sessionStateConfiguration
+	sessionStatePlay
+)
+
+// errSessionKicked ends the pipe of a session after the disconnect packet of a kick was sent
+var errSessionKicked = errors.New("session was kicked")
+
+// clientSession follows the packets that the server sends to the client until the player
+// is in the play state, so that Infrared knows the state of the connection and can disconnect
+// the player with a reason at any time. In the play state the packets are copied in chunks and only
+// their lengths are followed to find the gaps between two packets. Servers in online mode
+// encrypt the connection; those players can only be disconnected by closing the connection.
+type clientSession struct {
+	mu              sync.Mutex
+	conn            Conn
+	w               *bufio.Writer
+	protocolVersion int
+	state           sessionState
+	compressed      bool
+	// encrypted is set after the server requested encryption and the packets cannot be read anymore
+	encrypted bool
+	// frames follows the packets that are copied in chunks in the play state
+	frames frameTracker
+	// pendingKick is the disconnect packet of a kick that waits for the end of the packet that is being copied
+	pendingKick []byte
+	kickSent    chan struct{}
+}
+
+// newClientSession returns a session for the client if the packets of its protocol version are known
+func newClientSession(conn Conn, protocolVersion int) *clientSession {
+	if _, ok := playDisconnectPacketID(protocolVersion); !ok {
+		return nil
+	}
+
+	return &clientSession{
+		conn:            conn,
+		w:               bufio.NewWriter(conn),
+		protocolVersion: protocolVersion,
+	}
+}
+
+// pipeFrom copies the packets from the server to the client until one of them fails
+// and adds the copied bytes to written. If idleTimeout is set, src has to send data within that time.
+func (session *clientSession) pipeFrom(src Conn, idleTimeout time.Duration, written *int64) error {
+	r := src.Reader()
+	for {
+		session.mu.Lock()
+		framed := session.state != sessionStatePlay && !session.encrypted
+		session.mu.Unlock()
+		if !framed {
+			break
+		}
+
+		if idleTimeout > 0 {
+			if err := setReadTimeout(src, idleTimeout); err != nil {
+				return err
+			}
+		}
+
+		frame, data, err := readFrame(r)
+		if err != nil {
+			return err
+		}
+
+		session.mu.Lock()
+		session.track(data)
+		n, err := session.w.Write(frame)
+		// Packets that already arrived are sent together
+		if err == nil && !frameBuffered(r) {
+			err = session.w.Flush()
+		}
+		session.mu.Unlock()
+
+		atomic.AddInt64(written, int64(n))
+		if err != nil {
+			return err
+		}
+	}
+
+	session.mu.Lock()
+	err := session.w.Flush()
+	encrypted := session.encrypted
+	session.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if encrypted {
+		return pipe(src, session.conn, idleTimeout, written)
+	}
+
+	err = pipe(src, (*sessionWriter)(session), idleTimeout, written)
+	if errors.Is(err, errSessionKicked) {
+		return nil
+	}
+	return err
+}
+
+// sessionWriter writes the chunks of the play state to the client and follows their packets
+type sessionWriter clientSession
+
+func (w *sessionWriter) Write(p []byte) (int, error) {
+	session := (*clientSession)(w)
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if session.pendingKick == nil {
+		n, err := session.conn.Write(p)
+		for i := 0; i < n; {
+			i += session.frames.next(p[i:n])
+		}
+		return n, err
+	}
+
+	// The disconnect packet of the kick is sent right after the current packet
+	n := 0
+	if !session.frames.atBoundary() {
+		n = session.frames.next(p)
+		if _, err := session.conn.Write(p[:n]); err != nil {
+			return 0, err
+		}
+		if !session.frames.atBoundary() {
+			return n, nil
+		}
+	}
+
+	_, err := session.conn.Write(session.pendingKick)
+	session.pendingKick = nil
+	close(session.kickSent)
+	if err != nil {
+		return n, err
+	}
+	return n, errSessionKicked
+}
+
+// frameTracker follows the length prefixes of packets that are copied in chunks
+type frameTracker struct {
+	// remaining are the bytes of the current packet that did not pass yet
+	remaining int
+	// length is the part of the length prefix that passed so far
+	length int
+	shift  uint
+}
+
+func (t *frameTracker) atBoundary() bool {
+	return t.remaining == 0 && t.shift == 0
+}
+
+// next follows the bytes of p until the end of the current packet and returns
+// how many bytes it followed, which are all bytes of p if the packet does not end in p
+func (t *frameTracker) next(p []byte) int {
+	i := 0
+	for i < len(p) {
+		if t.remaining > 0 {
+			n := t.remaining
+			if n > len(p)-i {
+				n = len(p) - i
+			}
+			t.remaining -= n
+			i += n
+			if t.remaining == 0 {
+				return i
+			}
+			continue
+		}
+
+		b := p[i]
+		i++
+		t.length |= int(b&0x7f) << t.shift
+		if b&0x80 != 0 {
+			t.shift += 7
+			continue
+		}
+
+		t.remaining, t.length, t.shift = t.length, 0, 0
+		if t.remaining == 0 {
+			return i
+		}
+	}
+	return i
+}
+
+// readFrame reads the next packet with its length prefix and returns
+// the whole frame and the data after the length
+func readFrame(r *bufio.Reader) ([]byte, []byte, error) {
+	var length protocol.VarInt
+	if err := length.Decode(r); err != nil {
+		return nil, nil, err
+	}
+
+	if length < 1 || length > maxFrameLength {
+		return nil, nil, fmt.Errorf("invalid packet length %d", length)
+	}
+
+	prefix := length.Encode()
+	frame := make([]byte, len(prefix)+int(length))
+	copy(frame, prefix)
+	if _, err := io.ReadFull(r, frame[len(prefix):]); err != nil {
+		return nil, nil, err
+	}
+	return frame, frame[len(prefix):], nil
+}
+
+// frameBuffered checks if the next packet was already received completely
+func frameBuffered(r *bufio.Reader) bool {
+	buffered := r.Buffered()
+	if buffered == 0 {
+		return false
+	}
+
+	bb, _ := r.Peek(buffered)
+	var length protocol.VarInt
+	if err := length.Decode(bytes.NewReader(bb)); err != nil {
+		return false
+	}
+	return len(length.Encode())+int(length) <= buffered
+}
+
+// track updates the state of the session with a packet from the server.
+// The caller has to hold the lock of the session.
+func (session *clientSession) track(data []byte) {
+	if session.state == sessionStatePlay || session.encrypted {
+		return
+	}
+
+	r, err := session.packetReader(data)
+	if err != nil {
+		return
+	}
+
+	var packetID protocol.VarInt
+	if err := packetID.Decode(r); err != nil {
+		return
+	}
+
+	if session.state == sessionStateConfiguration {
+		if id, ok := finishConfigurationPacketID(session.protocolVersion); ok && byte(packetID) == id {
+			session.state = sessionStatePlay
+		}
+		return
+	}
+
+	switch byte(packetID) {
+	case loginEncryptionRequestPacketID:
+		session.encrypted = true
+	case loginSetCompressionPacketID:
+		var threshold protocol.VarInt
+		if err := threshold.Decode(r); err == nil {
+			session.compressed = threshold >= 0
+		}
+	case loginSuccessPacketID:
+		if session.protocolVersion >= configurationProtocolVersion {
+			session.state = sessionStateConfiguration
+		} else {
+			session.state = sessionStatePlay
+		}
+	}
+}
+
+// packetReader returns a reader of the packet ID and fields that decompresses them if needed
+func (session *clientSession) packetReader(data []byte) (protocol.DecodeReader, error) {
+	r := bytes.NewReader(data)
+	if !session.compressed {
+		return r, nil
+	}
+
+	var dataLength protocol.VarInt
+	if err := dataLength.Decode(r); err != nil {
+		return nil, err
+	}
+
+	if dataLength == 0 {
+		return r, nil
+	}
+
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	// Only the packet ID and the first fields are read, so the rest is not inflated
+	return bufio.NewReaderSize(zr, 16), nil
+}
+
+// disconnect sends a disconnect packet with the message to the client
+// if the state of the session is known. In the play state the packet is sent
+// once the packet that is currently copied to the client has ended.
+func (session *clientSession) disconnect(message string) error {
+	session.mu.Lock()
+	frame, err := session.disconnectFrame(message)
+	if err != nil {
+		session.mu.Unlock()
+		return err
+	}
+
+	if session.state != sessionStatePlay || session.frames.atBoundary() {
+		defer session.mu.Unlock()
+		if _, err := session.w.Write(frame); err != nil {
+			return err
+		}
+		return session.w.Flush()
+	}
+
+	session.pendingKick = frame
+	session.kickSent = make(chan struct{})
+	kickSent := session.kickSent
+	session.mu.Unlock()
+
+	select {
+	case <-kickSent:
+		return nil
+	case <-time.After(kickTimeout):
+		return errors.New("the current packet did not end in time")
+	}
+}
+
+// disconnectFrame returns the disconnect packet with its length prefix.
+// The caller has to hold the lock of the session.
+func (session *clientSession) disconnectFrame(message string) ([]byte, error) {
+	pk, err := session.disconnectPacket(message)
+	if err != nil {
+		return nil, err
+	}
+
+	data := append([]byte{pk.ID}, pk.Data...)
+	if session.compressed {
+		// Packets below the compression threshold are sent uncompressed with a data length of 0
+		data = append(protocol.VarInt(0).Encode(), data...)
+	}
+	return append(protocol.VarInt(len(data)).Encode(), data...), nil
+}
+
+// kick disconnects the client with the message and closes the connection
+func (session *clientSession) kick(message string) {
+	session.conn.SetWriteDeadline(time.Now().Add(kickTimeout))
+	if err := session.disconnect(message); err != nil {
+		log.Printf("[w] Failed to send disconnect packet; error: %s", err)
+	}
+	session.conn.Close()
+}
+
+func (session *clientSession) disconnectPacket(message string) (protocol.Packet, error) {
+	if session.encrypted {
+		return protocol.Packet{}, errors.New("session is encrypted")
+	}
+
+	var packetID byte
+	var ok bool
+	switch session.state {
+	case sessionStateLogin:
+		// The login disconnect keeps its ID and JSON reason in all versions
+		return disconnectPacket(message), nil
+	case sessionStateConfiguration:
+		packetID, ok = configurationDisconnectPacketID(session.protocolVersion)
+	default:
+		packetID, ok = playDisconnectPacketID(session.protocolVersion)
+	}
+
+	if !ok {
+		return protocol.Packet{}, fmt.Errorf("unknown disconnect packet of protocol version %d", session.protocolVersion)
+	}
+
+	if session.protocolVersion >= nbtChatProtocolVersion {
+		return protocol.Packet{ID: packetID, Data: nbtString(message)}, nil
+	}
+
+	reason, _ := json.Marshal(struct {
+		Text string `json:"text"`
+	}{
+		Text: message,
+	})
+	return protocol.MarshalPacket(packetID, protocol.Chat(reason)), nil
+}
+
+// nbtString encodes the text as a nameless NBT string tag, which is a valid chat component
+func nbtString(text string) []byte {
+	bb := make([]byte, 3, 3+len(text))
+	bb[0] = 0x08
+	binary.BigEndian.PutUint16(bb[1:], uint16(len(text)))
+	return append(bb, text...)
+}
+
+func playDisconnectPacketID(protocolVersion int) (byte, bool) {
+	switch {
+	case protocolVersion == 47:
+		return 0x40, true
+	case protocolVersion >= 107 && protocolVersion <= 340:
+		return 0x1A, true
+	case protocolVersion >= 393 && protocolVersion <= 404:
+		return 0x1B, true
+	case protocolVersion >= 477 && protocolVersion <= 498:
+		return 0x1A, true
+	case protocolVersion >= 573 && protocolVersion <= 578:
+		return 0x1B, true
+	case protocolVersion >= 735 && protocolVersion <= 736:
+		return 0x1A, true
+	case protocolVersion >= 751 && protocolVersion <= 754:
+		return 0x19, true
+	case protocolVersion >= 755 && protocolVersion <= 758:
+		return 0x1A, true
+	case protocolVersion == 759, protocolVersion == 761:
+		return 0x17, true
+	case protocolVersion == 760:
+		return 0x19, true
+	case protocolVersion >= 762 && protocolVersion <= 763:
+		return 0x1A, true
+	case protocolVersion >= 764 && protocolVersion <= 765:
+		return 0x1B, true
+	case protocolVersion >= 766 && protocolVersion <= 769:
+		return 0x1D, true
+	case protocolVersion >= 770 && protocolVersion <= 772:
+		return 0x1C, true
+	}
+	return 0, false
+}
+
+func configurationDisconnectPacketID(protocolVersion int) (byte, bool) {
+	switch {
+	case protocolVersion >= 764 && protocolVersion <= 765:
+		return 0x01, true
+	case protocolVersion >= 766 && protocolVersion <= 772:
+		return 0x02, true
+	}
+	return 0, false
+}
+
+func finishConfigurationPacketID(protocolVersion int) (byte, bool) {
+	switch {
+	case protocolVersion >= 764 && protocolVersion <= 765:
+		return 0x02, true
+	case protocolVersion >= 766 && protocolVersion <= 772:
+		return 0x03, true
+	}
+	return 0, false
+}
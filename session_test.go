@@ -0,0 +1,252 @@
+package infrared
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/haveachin/infrared/protocol"
+)
+
+func testFrame(data ...[]byte) []byte {
+	payload := bytes.Join(data, nil)
+	return append(protocol.VarInt(len(payload)).Encode(), payload...)
+}
+
+func zlibData(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestClientSession_Disconnect(t *testing.T) {
+	setCompression := testFrame([]byte{loginSetCompressionPacketID}, protocol.VarInt(256).Encode())
+	loginSuccess := []byte{loginSuccessPacketID, 0x00}
+	jsonReason := protocol.String(`{"text":"bye"}`).Encode()
+	nbtReason := append([]byte{0x08, 0x00, 0x03}, "bye"...)
+
+	tt := []struct {
+		name            string
+		protocolVersion int
+		frames          [][]byte
+		expected        []byte
+		expectErr       bool
+	}{
+		{
+			name:            "Login",
+			protocolVersion: 763,
+			expected:        testFrame([]byte{0x00}, jsonReason),
+		},
+		{
+			name:            "Play",
+			protocolVersion: 340,
+			frames:          [][]byte{testFrame(loginSuccess)},
+			expected:        testFrame([]byte{0x1A}, jsonReason),
+		},
+		{
+			name:            "PlayCompressed",
+			protocolVersion: 763,
+			frames: [][]byte{
+				setCompression,
+				testFrame(protocol.VarInt(0).Encode(), loginSuccess),
+			},
+			expected: testFrame(protocol.VarInt(0).Encode(), []byte{0x1A}, jsonReason),
+		},
+		{
+			name:            "PlayZlibCompressed",
+			protocolVersion: 763,
+			frames: [][]byte{
+				setCompression,
+				testFrame(protocol.VarInt(len(loginSuccess)).Encode(), zlibData(t, loginSuccess)),
+			},
+			expected: testFrame(protocol.VarInt(0).Encode(), []byte{0x1A}, jsonReason),
+		},
+		{
+			name:            "Configuration",
+			protocolVersion: 766,
+			frames:          [][]byte{testFrame(loginSuccess)},
+			expected:        testFrame([]byte{0x02}, nbtReason),
+		},
+		{
+			name:            "FinishedConfiguration",
+			protocolVersion: 766,
+			frames:          [][]byte{testFrame(loginSuccess), testFrame([]byte{0x03})},
+			expected:        testFrame([]byte{0x1D}, nbtReason),
+		},
+		{
+			name:            "Encrypted",
+			protocolVersion: 763,
+			frames:          [][]byte{testFrame([]byte{loginEncryptionRequestPacketID, 0x00})},
+			expectErr:       true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			server, serverPeer := net.Pipe()
+			defer serverPeer.Close()
+			client, clientPeer := net.Pipe()
+			defer clientPeer.Close()
+
+			session := newClientSession(wrapConn(client), tc.protocolVersion)
+			var written int64
+			go session.pipeFrom(wrapConn(server), 0, &written)
+
+			clientReader := wrapConn(clientPeer).Reader()
+			for _, frame := range tc.frames {
+				if _, err := serverPeer.Write(frame); err != nil {
+					t.Fatal(err)
+				}
+
+				forwarded, _, err := readFrame(clientReader)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !bytes.Equal(forwarded, frame) {
+					t.Fatalf("got: %x; want: %x", forwarded, frame)
+				}
+			}
+
+			errc := make(chan error, 1)
+			go func() {
+				errc <- session.disconnect("bye")
+			}()
+
+			if tc.expectErr {
+				if err := <-errc; err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+
+			disconnect, _, err := readFrame(clientReader)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(disconnect, tc.expected) {
+				t.Errorf("got: %x; want: %x", disconnect, tc.expected)
+			}
+
+			if err := <-errc; err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestNewClientSession_UnknownVersion(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+
+	if session := newClientSession(wrapConn(conn), 5); session != nil {
+		t.Error("clients with unknown packets should have no session")
+	}
+}
+
+func TestClientSession_DisconnectBetweenPackets(t *testing.T) {
+	server, serverPeer := net.Pipe()
+	defer serverPeer.Close()
+	client, clientPeer := net.Pipe()
+	defer clientPeer.Close()
+
+	session := newClientSession(wrapConn(client), 763)
+	var written int64
+	pipeErr := make(chan error, 1)
+	go func() {
+		pipeErr <- session.pipeFrom(wrapConn(server), 0, &written)
+	}()
+
+	clientReader := wrapConn(clientPeer).Reader()
+	loginSuccess := testFrame([]byte{loginSuccessPacketID, 0x00})
+	chat := testFrame([]byte{0x64}, []byte("0123456789"))
+	for _, chunk := range [][]byte{loginSuccess, chat[:5]} {
+		if _, err := serverPeer.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+
+		forwarded := make([]byte, len(chunk))
+		if _, err := io.ReadFull(clientReader, forwarded); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- session.disconnect("bye")
+	}()
+
+	// The disconnect packet has to wait for the rest of the chat packet
+	for {
+		session.mu.Lock()
+		pending := session.pendingKick != nil
+		session.mu.Unlock()
+		if pending {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	go serverPeer.Write(append(chat[5:], chat...))
+
+	rest := make([]byte, len(chat)-5)
+	if _, err := io.ReadFull(clientReader, rest); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(rest, chat[5:]) {
+		t.Errorf("got: %x; want: %x", rest, chat[5:])
+	}
+
+	disconnect, _, err := readFrame(clientReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := testFrame([]byte{0x1A}, protocol.String(`{"text":"bye"}`).Encode())
+	if !bytes.Equal(disconnect, expected) {
+		t.Errorf("got: %x; want: %x", disconnect, expected)
+	}
+
+	if err := <-errc; err != nil {
+		t.Error(err)
+	}
+
+	if err := <-pipeErr; err != nil {
+		t.Errorf("the pipe should end after the kick, got: %v", err)
+	}
+}
+
+func TestFrameTracker(t *testing.T) {
+	long := testFrame(bytes.Repeat([]byte{0x01}, 300))
+	stream := append(append(testFrame([]byte{0x01, 0x02}), long...), 0x03)
+
+	var tracker frameTracker
+	var boundaries []int
+	for i := 0; i < len(stream); {
+		// Chunks of one byte split the length prefixes too
+		i += tracker.next(stream[i : i+1])
+		if tracker.atBoundary() {
+			boundaries = append(boundaries, i)
+		}
+	}
+
+	expected := []int{3, 3 + len(long)}
+	if len(boundaries) != len(expected) || boundaries[0] != expected[0] || boundaries[1] != expected[1] {
+		t.Errorf("got: %v; want: %v", boundaries, expected)
+	}
+
+	if tracker.atBoundary() {
+		t.Error("the last packet has not ended")
+	}
+}
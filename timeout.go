@@ -0,0 +1,79 @@
+package infrared
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	timedOutConnections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "infrared_timeouts",
+		Help: "The total number of connections closed by read deadlines",
+	}, []string{"listener", "host", "phase"})
+)
+
+const (
+	TimeoutPhaseHandshake = "handshake"
+	TimeoutPhaseStatus    = "status"
+	TimeoutPhaseLogin     = "login"
+	TimeoutPhaseIdle      = "idle"
+)
+
+var ErrTimeout = errors.New("timeout")
+
+// TimeoutConfig holds the read deadlines of a connection. A zero duration disables the deadline.
+type TimeoutConfig struct {
+	// Handshake is the deadline for the Proxy Protocol header and the handshake
+	Handshake time.Duration
+	// Status is the deadline for the status request and ping after the handshake
+	Status time.Duration
+	// Login is the deadline for the login start after the handshake
+	Login time.Duration
+	// Idle is the time a piped session can go without receiving data
+	Idle time.Duration
+}
+
+// TimeoutError is returned when a connection missed the read deadline of a phase
+type TimeoutError struct {
+	Phase string
+}
+
+func (err TimeoutError) Error() string {
+	return fmt.Sprintf("%s %s", err.Phase, ErrTimeout)
+}
+
+func (err TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// setReadTimeout sets the read deadline of the conn to now plus the timeout.
+// A timeout of zero removes the deadline.
+func setReadTimeout(conn net.Conn, timeout time.Duration) error {
+	if timeout <= 0 {
+		return conn.SetReadDeadline(time.Time{})
+	}
+	return conn.SetReadDeadline(time.Now().Add(timeout))
+}
+
+// phaseError converts deadline errors into a TimeoutError of the phase
+func phaseError(err error, phase string) error {
+	if err == nil || !isTimeout(err) {
+		return err
+	}
+	return TimeoutError{Phase: phase}
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
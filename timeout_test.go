@@ -0,0 +1,33 @@
+package infrared
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPhaseError(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	if err := setReadTimeout(server, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := server.Read(make([]byte, 1))
+	err = phaseError(err, TimeoutPhaseLogin)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got: %v; want: %v", err, ErrTimeout)
+	}
+
+	if err.Error() != "login timeout" {
+		t.Errorf("got: %v; want: %v", err, "login timeout")
+	}
+
+	otherErr := errors.New("other")
+	if phaseError(otherErr, TimeoutPhaseLogin) != otherErr {
+		t.Error("non-timeout errors should not be converted")
+	}
+}
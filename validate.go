@@ -0,0 +1,672 @@
+package infrared
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ValidationError is a problem with a field of a config file
+type ValidationError struct {
+	File string
+	// Line is the line of the field in the file. It is zero if it is unknown.
+	Line int
+	// Field is the path of the field like "onlineStatus.iconPath"
+	Field   string
+	Message string
+}
+
+// Error formats the error like "file:line: field: message" with secrets redacted
+func (err ValidationError) Error() string {
+	location := err.File
+	if err.Line > 0 {
+		location = fmt.Sprintf("%s:%d", location, err.Line)
+	}
+
+	if err.Field == "" {
+		return RedactSecrets(fmt.Sprintf("%s: %s", location, err.Message))
+	}
+	return RedactSecrets(fmt.Sprintf("%s: %s: %s", location, err.Field, err.Message))
+}
+
+// ValidationErrors are all problems of one or more config files
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// configFile is a parsed config file that knows the lines of its keys
+type configFile struct {
+	path   string
+	raw    map[string]interface{}
+	lines  map[string]int
+	errors ValidationErrors
+	// strict turns the checks that configs did not have to pass before they were validated into errors.
+	// Otherwise those problems are warnings, so that existing configs still load.
+	strict   bool
+	warnings ValidationErrors
+	// bases are the files that the file extends in the order in which they are merged
+	bases []*configFile
+	// merged is the raw config on top of its bases and the defaults with all references interpolated
+	merged map[string]interface{}
+}
+
+// addError adds an error at the line of the key path in the file or in the base that the value comes from
+func (file *configFile) addError(path []string, format string, a ...interface{}) {
+	source := file.source(path)
+	file.errors = append(file.errors, ValidationError{
+		File:    source.path,
+		Line:    source.line(path),
+		Field:   fieldPath(path),
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+// addStrictError adds an error if the file is validated strictly and a warning otherwise
+func (file *configFile) addStrictError(path []string, format string, a ...interface{}) {
+	if file.strict {
+		file.addError(path, format, a...)
+		return
+	}
+
+	source := file.source(path)
+	file.warnings = append(file.warnings, ValidationError{
+		File:    source.path,
+		Line:    source.line(path),
+		Field:   fieldPath(path),
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+// line returns the line of the key at path or of its closest parent
+func (file *configFile) line(path []string) int {
+	for i := len(path); i > 0; i-- {
+		if line, ok := file.lines[strings.Join(path[:i], ".")]; ok {
+			return line
+		}
+	}
+	return 0
+}
+
+// parseConfigFile decodes the file and finds the lines of its keys.
+// Syntax errors are returned as a single validation error.
+func parseConfigFile(path string, bb []byte) *configFile {
+	file := &configFile{path: path}
+	format := ConfigFormatFromPath(path)
+
+	raw, err := UnmarshalConfig(format, bb)
+	if err != nil {
+		file.errors = append(file.errors, ValidationError{
+			File:    path,
+			Line:    syntaxErrorLine(bb, err),
+			Message: err.Error(),
+		})
+		return file
+	}
+	file.raw = raw
+
+	switch format {
+	case ConfigFormatYAML:
+		file.lines = yamlKeyLines(bb)
+	case ConfigFormatTOML:
+		file.lines = tomlKeyLines(bb)
+	default:
+		file.lines = jsonKeyLines(bb)
+	}
+	return file
+}
+
+var lineRegexp = regexp.MustCompile(`line (\d+)`)
+
+func syntaxErrorLine(bb []byte, err error) int {
+	var jsonErr *json.SyntaxError
+	if errors.As(err, &jsonErr) {
+		return offsetLine(bb, jsonErr.Offset)
+	}
+
+	var tomlErr toml.ParseError
+	if errors.As(err, &tomlErr) {
+		return tomlErr.Line
+	}
+
+	// YAML errors only carry the line in their message
+	if match := lineRegexp.FindStringSubmatch(err.Error()); match != nil {
+		line, _ := strconv.Atoi(match[1])
+		return line
+	}
+	return 0
+}
+
+func offsetLine(bb []byte, offset int64) int {
+	if offset > int64(len(bb)) {
+		offset = int64(len(bb))
+	}
+	return bytes.Count(bb[:offset], []byte{'\n'}) + 1
+}
+
+// fieldPath formats a key path like "playerLists.whitelist[0].name"
+func fieldPath(path []string) string {
+	var sb strings.Builder
+	for _, key := range path {
+		if _, err := strconv.Atoi(key); err == nil {
+			sb.WriteString("[" + key + "]")
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte('.')
+		}
+		sb.WriteString(key)
+	}
+	return sb.String()
+}
+
+func jsonKeyLines(bb []byte) map[string]int {
+	lines := map[string]int{}
+	decoder := json.NewDecoder(bytes.NewReader(bb))
+	walkJSONValue(decoder, bb, nil, lines)
+	return lines
+}
+
+// walkJSONValue records the lines of the keys of the next value and returns the line the value starts on
+func walkJSONValue(decoder *json.Decoder, bb []byte, path []string, lines map[string]int) int {
+	token, err := decoder.Token()
+	if err != nil {
+		return 0
+	}
+	line := offsetLine(bb, decoder.InputOffset())
+
+	switch token {
+	case json.Delim('{'):
+		for decoder.More() {
+			keyToken, err := decoder.Token()
+			if err != nil {
+				return line
+			}
+			key, _ := keyToken.(string)
+			keyPath := append(append([]string{}, path...), key)
+			lines[strings.Join(keyPath, ".")] = offsetLine(bb, decoder.InputOffset())
+			walkJSONValue(decoder, bb, keyPath, lines)
+		}
+		decoder.Token()
+	case json.Delim('['):
+		for i := 0; decoder.More(); i++ {
+			itemPath := append(append([]string{}, path...), strconv.Itoa(i))
+			lines[strings.Join(itemPath, ".")] = walkJSONValue(decoder, bb, itemPath, lines)
+		}
+		decoder.Token()
+	}
+	return line
+}
+
+func yamlKeyLines(bb []byte) map[string]int {
+	lines := map[string]int{}
+	var node yaml.Node
+	if err := yaml.Unmarshal(bb, &node); err != nil {
+		return lines
+	}
+	walkYAMLNode(&node, nil, lines)
+	return lines
+}
+
+func walkYAMLNode(node *yaml.Node, path []string, lines map[string]int) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			walkYAMLNode(child, path, lines)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keyPath := append(append([]string{}, path...), node.Content[i].Value)
+			lines[strings.Join(keyPath, ".")] = node.Content[i].Line
+			walkYAMLNode(node.Content[i+1], keyPath, lines)
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			itemPath := append(append([]string{}, path...), strconv.Itoa(i))
+			lines[strings.Join(itemPath, ".")] = child.Line
+			walkYAMLNode(child, itemPath, lines)
+		}
+	}
+}
+
+var (
+	tomlTableRegexp      = regexp.MustCompile(`^\s*\[\s*([^\[\]]+?)\s*\]`)
+	tomlArrayTableRegexp = regexp.MustCompile(`^\s*\[\[\s*([^\[\]]+?)\s*\]\]`)
+	tomlKeyRegexp        = regexp.MustCompile(`^\s*([A-Za-z0-9_\-."' ]+?)\s*=`)
+)
+
+// tomlKeyLines finds the lines of table headers and keys.
+// Keys of inline tables get the line of their table.
+func tomlKeyLines(bb []byte) map[string]int {
+	lines := map[string]int{}
+	arrayTables := map[string]int{}
+	var table []string
+
+	for i, line := range strings.Split(string(bb), "\n") {
+		if match := tomlArrayTableRegexp.FindStringSubmatch(line); match != nil {
+			table = tomlKeyPath(match[1])
+			key := strings.Join(table, ".")
+			table = append(table, strconv.Itoa(arrayTables[key]))
+			arrayTables[key]++
+			lines[strings.Join(table, ".")] = i + 1
+			continue
+		}
+
+		if match := tomlTableRegexp.FindStringSubmatch(line); match != nil {
+			table = tomlKeyPath(match[1])
+			lines[strings.Join(table, ".")] = i + 1
+			continue
+		}
+
+		if match := tomlKeyRegexp.FindStringSubmatch(line); match != nil {
+			keyPath := append(append([]string{}, table...), tomlKeyPath(match[1])...)
+			lines[strings.Join(keyPath, ".")] = i + 1
+		}
+	}
+	return lines
+}
+
+func tomlKeyPath(key string) []string {
+	var path []string
+	for _, part := range strings.Split(key, ".") {
+		path = append(path, strings.Trim(strings.TrimSpace(part), `"'`))
+	}
+	return path
+}
+
+// checkUnknownKeys reports keys of raw that are not a JSON field of the type
+func (file *configFile) checkUnknownKeys(raw interface{}, t reflect.Type, path []string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := raw.(map[string]interface{})
+		if !ok {
+			return
+		}
+
+		fields := jsonFields(t)
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			keyPath := append(append([]string{}, path...), key)
+			field, ok := fields[key]
+			if !ok {
+				file.addStrictError(keyPath, "unknown field%s", suggestField(key, fields))
+				continue
+			}
+			file.checkUnknownKeys(m[key], field.Type, keyPath)
+		}
+	case reflect.Map:
+		m, ok := raw.(map[string]interface{})
+		if !ok {
+			return
+		}
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			file.checkUnknownKeys(m[key], t.Elem(), append(append([]string{}, path...), key))
+		}
+	case reflect.Slice, reflect.Array:
+		items, ok := raw.([]interface{})
+		if !ok {
+			return
+		}
+		for i, item := range items {
+			file.checkUnknownKeys(item, t.Elem(), append(append([]string{}, path...), strconv.Itoa(i)))
+		}
+	}
+}
+
+// jsonFields returns the fields of a struct type by their JSON name
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := jsonFieldName(field)
+		if name == "" {
+			continue
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	if field.PkgPath != "" || field.Anonymous {
+		return ""
+	}
+
+	tag := strings.Split(field.Tag.Get("json"), ",")[0]
+	switch tag {
+	case "-":
+		return ""
+	case "":
+		return field.Name
+	default:
+		return tag
+	}
+}
+
+// suggestField returns a hint for a field with the same name in a different case
+func suggestField(key string, fields map[string]reflect.StructField) string {
+	for name := range fields {
+		if strings.EqualFold(name, key) {
+			return fmt.Sprintf("; did you mean %q?", name)
+		}
+	}
+	return ""
+}
+
+// decode unmarshals the raw config into v and reports type errors
+func (file *configFile) decode(raw map[string]interface{}, v interface{}) bool {
+	bb, err := json.Marshal(raw)
+	if err != nil {
+		file.addError(nil, "%s", err)
+		return false
+	}
+
+	if err := json.Unmarshal(bb, v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			path := strings.Split(typeErr.Field, ".")
+			file.addError(path, "must be of type %s, not %s", typeErr.Type, typeErr.Value)
+			return false
+		}
+		file.addError(nil, "%s", err)
+		return false
+	}
+	return true
+}
+
+// ValidateProxyConfig checks a proxy config file for unknown fields, wrong types,
+// invalid addresses, conflicting options and missing files
+func ValidateProxyConfig(path string, bb []byte) error {
+	_, _, errs := validateProxyConfig(path, bb)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateProxyConfig validates a config file strictly
+func validateProxyConfig(path string, bb []byte) (*ProxyConfig, *configFile, ValidationErrors) {
+	return validateConfig(path, bb, configOriginFile, true)
+}
+
+// validateDiscoveredConfig validates a config that was created from labels or annotations.
+// Its values are taken literally instead of being interpolated, and it cannot extend files
+// or set fields that run commands, call URLs or read files on the host of Infrared.
+func validateDiscoveredConfig(name string, bb []byte) (*ProxyConfig, *configFile, ValidationErrors) {
+	return validateConfig(name, bb, configOriginDiscovered, true)
+}
+
+// undiscoverableKeyPaths are the fields that a discovered config cannot set
+var undiscoverableKeyPaths = [][]string{
+	{"extends"},
+	{"callbackServer"},
+	{"docker", "exec"},
+	{"docker", "webhook"},
+	{"docker", "portainer"},
+	{"docker", "pterodactyl"},
+	{"onlineStatus", "iconPath"},
+	{"offlineStatus", "iconPath"},
+	{"maintenance", "status", "iconPath"},
+}
+
+// validateConfig validates a config for the fields that its origin can set. If it is not strict,
+// the problems that configs did not have to pass before are returned as warnings of the file.
+func validateConfig(path string, bb []byte, origin configOrigin, strict bool) (*ProxyConfig, *configFile, ValidationErrors) {
+	file := parseConfigFile(path, bb)
+	file.strict = strict
+	if file.raw == nil {
+		return nil, file, file.errors
+	}
+
+	file.checkUnknownKeys(file.raw, reflect.TypeOf(ProxyConfig{}), nil)
+	discovered := origin == configOriginDiscovered
+	switch origin {
+	case configOriginDiscovered:
+		for _, keyPath := range undiscoverableKeyPaths {
+			if hasKeyPath(file.raw, keyPath) {
+				file.addError(keyPath, "cannot be discovered")
+			}
+		}
+	case configOriginHTTP:
+		if hasKeyPath(file.raw, []string{"extends"}) {
+			file.addError([]string{"extends"}, "cannot be used by configs of an endpoint")
+		}
+	default:
+		file.resolveBases(nil)
+	}
+
+	defaults, err := defaultProxyConfigMap()
+	if err != nil {
+		file.addError(nil, "%s", err)
+		return nil, file, file.errors
+	}
+
+	interpolate := func(raw map[string]interface{}) map[string]interface{} {
+		return interpolateConfig(raw, nil, func(path []string, err error) {
+			file.addError(path, "%s", err)
+		}).(map[string]interface{})
+	}
+
+	merged := map[string]interface{}{}
+	for k, value := range defaults {
+		merged[k] = value
+	}
+	if discovered {
+		// Only the defaults are interpolated, the values of labels and annotations are taken literally
+		merged = interpolate(merged)
+	}
+	for k, value := range file.mergeBases() {
+		merged[k] = value
+	}
+
+	if !discovered {
+		merged = interpolate(merged)
+	}
+	if len(file.errors) > 0 {
+		return nil, file, file.errors
+	}
+	file.merged = merged
+
+	var cfg ProxyConfig
+	if !file.decode(merged, &cfg) {
+		return nil, file, file.errors
+	}
+	addConfigSecrets(&cfg)
+
+	file.checkProxyConfig(&cfg)
+	return &cfg, file, file.errors
+}
+
+func (file *configFile) checkProxyConfig(cfg *ProxyConfig) {
+	// The fields that configs had before they were validated are only checked strictly
+	if cfg.DomainName == "" {
+		file.addStrictError([]string{"domainName"}, "is required")
+	}
+
+	if err := validateAddress(cfg.ListenTo); err != nil {
+		file.addStrictError([]string{"listenTo"}, "%s", err)
+	}
+
+	if cfg.ProxyTo == "" {
+		file.addStrictError([]string{"proxyTo"}, "is required")
+	} else if err := validateAddress(cfg.ProxyTo); err != nil {
+		file.addStrictError([]string{"proxyTo"}, "%s", err)
+	}
+
+	if cfg.ProxyBind != "" && net.ParseIP(cfg.ProxyBind) == nil {
+		file.addStrictError([]string{"proxyBind"}, "%q is not an IP", cfg.ProxyBind)
+	}
+
+	if cfg.ProxyProtocol && cfg.RealIP {
+		file.addStrictError([]string{"realIp"}, "can not be used together with proxyProtocol")
+	}
+
+	if cfg.ProxyProtocolVersion != 1 && cfg.ProxyProtocolVersion != 2 {
+		file.addError([]string{"proxyProtocolVersion"}, "must be 1 or 2")
+	}
+
+	if k8s := cfg.Docker.Kubernetes; k8s.Deployment != "" && k8s.StatefulSet != "" {
+		file.addError([]string{"docker", "kubernetes", "statefulSet"}, "can not be used together with deployment")
+	} else if cfg.Docker.IsKubernetes() && k8s.Namespace == "" {
+		file.addError([]string{"docker", "kubernetes", "namespace"}, "is required")
+	}
+
+	if cfg.Docker.IsExec() {
+		if err := cfg.Docker.ExecConfig().Validate(); err != nil {
+			file.addError([]string{"docker", "exec", "stop"}, "%s", err)
+		}
+	}
+
+	if cfg.Docker.IsPterodactyl() {
+		pterodactyl := cfg.Docker.Pterodactyl
+		if pterodactyl.URL == "" {
+			file.addError([]string{"docker", "pterodactyl", "url"}, "is required")
+		}
+		if pterodactyl.ServerID == "" {
+			file.addError([]string{"docker", "pterodactyl", "serverId"}, "is required")
+		}
+		if pterodactyl.APIKey == "" {
+			file.addError([]string{"docker", "pterodactyl", "apiKey"}, "is required")
+		}
+	}
+
+	if cfg.Docker.IsWebhook() {
+		if err := cfg.Docker.WebhookConfig().Validate(); err != nil {
+			file.addError([]string{"docker", "webhook"}, "%s", err)
+		}
+	}
+
+	file.checkIcon([]string{"onlineStatus", "iconPath"}, cfg.OnlineStatus.IconPath, file.addStrictError)
+	file.checkIcon([]string{"offlineStatus", "iconPath"}, cfg.OfflineStatus.IconPath, file.addStrictError)
+	file.checkIcon([]string{"maintenance", "status", "iconPath"}, cfg.Maintenance.Status.IconPath, file.addError)
+
+	if _, err := NewAccessList(cfg.AccessList); err != nil {
+		file.addError([]string{"accessList"}, "%s", err)
+	}
+
+	switch cfg.DuplicateLogin.Policy {
+	case "", DuplicateLoginPolicyAllow, DuplicateLoginPolicyReject, DuplicateLoginPolicyKick:
+	default:
+		file.addError([]string{"duplicateLogin", "policy"}, "must be one of allow, reject or kick")
+	}
+}
+
+func (file *configFile) checkIcon(path []string, iconPath string, addError func(path []string, format string, a ...interface{})) {
+	if iconPath == "" {
+		return
+	}
+
+	if _, err := os.Stat(iconPath); err != nil {
+		addError(path, "icon %s does not exist", iconPath)
+	}
+}
+
+// validateAddress checks that the address has the form host:port with an optional host
+func validateAddress(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%q is not an address like host:port", addr)
+	}
+
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("%q has an invalid port", addr)
+	}
+	return nil
+}
+
+// ValidateProxyConfigFiles validates every file and checks that no two files have the same UID
+func ValidateProxyConfigFiles(paths []string) error {
+	var errs ValidationErrors
+	uidFiles := map[string]string{}
+
+	for _, path := range paths {
+		bb, err := ioutil.ReadFile(path)
+		if err != nil {
+			errs = append(errs, ValidationError{File: path, Message: err.Error()})
+			continue
+		}
+
+		cfg, file, _ := validateProxyConfig(path, bb)
+		if cfg != nil {
+			uid := proxyUID(cfg.DomainName, cfg.ListenTo)
+			if otherPath, ok := uidFiles[uid]; ok {
+				file.addError([]string{"domainName"}, "UID %s is already used by %s", uid, otherPath)
+			} else {
+				uidFiles[uid] = path
+			}
+		}
+		errs = append(errs, file.errors...)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// ValidateGlobalConfig checks a global config file for unknown fields and wrong types.
+// The defaults are checked like a proxy config.
+func ValidateGlobalConfig(path string, bb []byte) error {
+	if file := validateGlobalConfig(path, bb, true); len(file.errors) > 0 {
+		return file.errors
+	}
+	return nil
+}
+
+func validateGlobalConfig(path string, bb []byte, strict bool) *configFile {
+	file := parseConfigFile(path, bb)
+	file.strict = strict
+	if file.raw == nil {
+		return file
+	}
+
+	file.checkUnknownKeys(file.raw, reflect.TypeOf(GlobalConfig{}), nil)
+	if defaults, ok := file.raw["defaults"]; ok {
+		file.checkUnknownKeys(defaults, reflect.TypeOf(ProxyConfig{}), []string{"defaults"})
+	}
+
+	var cfg GlobalConfig
+	file.decode(file.raw, &cfg)
+	return file
+}
+
+// logWarnings logs the problems that did not stop the file from loading
+func (file *configFile) logWarnings() {
+	for _, warning := range file.warnings {
+		log.Printf("[w] %s", warning)
+	}
+}
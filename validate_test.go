@@ -0,0 +1,340 @@
+package infrared
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateProxyConfig(t *testing.T) {
+	tt := []struct {
+		name     string
+		fileName string
+		content  string
+		errs     []string
+	}{
+		{
+			name:     "Valid",
+			fileName: "proxy.yml",
+			content: `domainName: mc.example.com
+proxyTo: ":25566"
+`,
+		},
+		{
+			name:     "UnknownKeyJSON",
+			fileName: "proxy.json",
+			content: `{
+  "domainName": "mc.example.com",
+  "proxyTo": ":25566",
+  "onlineStatus": {
+    "maxplayers": 10
+  }
+}`,
+			errs: []string{`proxy.json:5: onlineStatus.maxplayers: unknown field; did you mean "maxPlayers"?`},
+		},
+		{
+			name:     "UnknownKeyYAML",
+			fileName: "proxy.yml",
+			content: `domainName: mc.example.com
+proxyTo: ":25566"
+playerLists:
+  whitelist:
+    - name: Notch
+    - nmae: jeb_
+`,
+			errs: []string{"proxy.yml:6: playerLists.whitelist[1].nmae: unknown field"},
+		},
+		{
+			name:     "UnknownKeyTOML",
+			fileName: "proxy.toml",
+			content: `domainName = "mc.example.com"
+proxyTo = ":25566"
+
+[maintenance]
+enabled = true
+motd = "Be right back"
+`,
+			errs: []string{"proxy.toml:6: maintenance.motd: unknown field"},
+		},
+		{
+			name:     "WrongType",
+			fileName: "proxy.yml",
+			content: `domainName: mc.example.com
+proxyTo: ":25566"
+maxConnections: lots
+`,
+			errs: []string{"proxy.yml:3: maxConnections: must be of type int, not string"},
+		},
+		{
+			name:     "SyntaxError",
+			fileName: "proxy.json",
+			content: `{
+  "domainName": "mc.example.com",
+  "proxyTo": ":25566",,
+}`,
+			errs: []string{"proxy.json:3: invalid json"},
+		},
+		{
+			name:     "InvalidAddress",
+			fileName: "proxy.yml",
+			content: `domainName: mc.example.com
+listenTo: ":99999"
+proxyTo: localhost
+`,
+			errs: []string{
+				`proxy.yml:2: listenTo: ":99999" has an invalid port`,
+				`proxy.yml:3: proxyTo: "localhost" is not an address like host:port`,
+			},
+		},
+		{
+			name:     "MissingProxyTo",
+			fileName: "proxy.yml",
+			content: `domainName: mc.example.com
+`,
+			errs: []string{"proxy.yml: proxyTo: is required"},
+		},
+		{
+			name:     "ProxyProtocolAndRealIP",
+			fileName: "proxy.yml",
+			content: `domainName: mc.example.com
+proxyTo: ":25566"
+proxyProtocol: true
+realIp: true
+`,
+			errs: []string{"proxy.yml:4: realIp: can not be used together with proxyProtocol"},
+		},
+		{
+			name:     "MissingIcon",
+			fileName: "proxy.yml",
+			content: `domainName: mc.example.com
+proxyTo: ":25566"
+maintenance:
+  status:
+    iconPath: missing.png
+`,
+			errs: []string{"proxy.yml:5: maintenance.status.iconPath: icon missing.png does not exist"},
+		},
+		{
+			name:     "InvalidDuplicateLoginPolicy",
+			fileName: "proxy.yml",
+			content: `domainName: mc.example.com
+proxyTo: ":25566"
+duplicateLogin:
+  policy: kickk
+`,
+			errs: []string{"proxy.yml:4: duplicateLogin.policy: must be one of allow, reject or kick"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateProxyConfig(tc.fileName, []byte(tc.content))
+			if len(tc.errs) == 0 {
+				if err != nil {
+					t.Fatalf("expected no error, got: %s", err)
+				}
+				return
+			}
+
+			var errs ValidationErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("expected validation errors, got: %v", err)
+			}
+
+			if len(errs) != len(tc.errs) {
+				t.Fatalf("expected %d errors, got:\n%s", len(tc.errs), errs)
+			}
+
+			for i, expected := range tc.errs {
+				if !strings.HasPrefix(errs[i].Error(), expected) {
+					t.Errorf("expected error %q, got %q", expected, errs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateDiscoveredConfig(t *testing.T) {
+	setenv(t, "INFRARED_TEST_SECRET", "hunter2")
+
+	cfg, _, errs := validateDiscoveredConfig("docker://survival", []byte(`{
+  "domainName": "mc.example.com",
+  "proxyTo": "survival:25565",
+  "offlineStatus": {"motd": "${env:INFRARED_TEST_SECRET}"}
+}`))
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	if cfg.OfflineStatus.MOTD != "${env:INFRARED_TEST_SECRET}" {
+		t.Errorf("expected the motd to be taken literally, got %q", cfg.OfflineStatus.MOTD)
+	}
+
+	_, _, errs = validateDiscoveredConfig("docker://survival", []byte(`{
+  "extends": "base.yml",
+  "domainName": "mc.example.com",
+  "proxyTo": "survival:25565",
+  "docker": {"exec": {"command": "sh"}},
+  "onlineStatus": {"iconPath": "/etc/passwd"}
+}`))
+
+	expected := []string{"extends: cannot be discovered", "docker.exec: cannot be discovered", "onlineStatus.iconPath: cannot be discovered"}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got:\n%s", len(expected), errs)
+	}
+
+	for i, message := range expected {
+		if !strings.Contains(errs[i].Error(), message) {
+			t.Errorf("expected error %q, got %q", message, errs[i])
+		}
+	}
+}
+
+func TestValidateConfig_HTTPExtends(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "_base.yml")
+	if err := ioutil.WriteFile(base, []byte("proxyTo: \":25566\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, errs := validateConfig("http://127.0.0.1#lobby", []byte(`{
+  "extends": "`+filepath.ToSlash(base)+`",
+  "domainName": "mc.example.com"
+}`), configOriginHTTP, true)
+
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "extends: cannot be used by configs of an endpoint") {
+		t.Fatalf("expected extends to be rejected, got:\n%s", errs)
+	}
+}
+
+func TestValidateProxyConfigFiles_DuplicateUID(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.yml":  "domainName: mc.example.com\nproxyTo: \":25566\"\n",
+		"b.json": `{"domainName": "mc.example.com", "proxyTo": ":25567"}`,
+		"c.yml":  "domainName: mc.example.com\nlistenTo: \":25575\"\nproxyTo: \":25568\"\n",
+	}
+
+	var paths []string
+	for _, name := range []string{"a.yml", "b.json", "c.yml"} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(files[name]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	err := ValidateProxyConfigFiles(paths)
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected validation errors, got: %v", err)
+	}
+
+	if len(errs) != 1 {
+		t.Fatalf("expected one error, got:\n%s", errs)
+	}
+
+	if errs[0].File != paths[1] || errs[0].Field != "domainName" ||
+		!strings.Contains(errs[0].Message, paths[0]) {
+		t.Errorf("unexpected error: %s", errs[0])
+	}
+}
+
+func TestProxyConfig_LoadWarnings(t *testing.T) {
+	content := `domainName: mc.example.com
+realIp: true
+proxyProtocol: true
+onlineStatus:
+  maxplayers: 10
+`
+
+	var cfg ProxyConfig
+	if err := cfg.load("proxy.yml", []byte(content)); err != nil {
+		t.Fatalf("expected the config to load with warnings, got: %v", err)
+	}
+	if cfg.DomainName != "mc.example.com" {
+		t.Errorf("got domain %q", cfg.DomainName)
+	}
+
+	_, file, errs := validateConfig("proxy.yml", []byte(content), configOriginFile, false)
+	if len(errs) > 0 {
+		t.Fatalf("expected no errors, got:\n%s", errs)
+	}
+
+	expected := []string{
+		"proxy.yml:5: onlineStatus.maxplayers: unknown field",
+		"proxy.yml: proxyTo: is required",
+		"proxy.yml:2: realIp: can not be used together with proxyProtocol",
+	}
+	if len(file.warnings) != len(expected) {
+		t.Fatalf("expected %d warnings, got:\n%s", len(expected), file.warnings)
+	}
+	for _, e := range expected {
+		if !strings.Contains(file.warnings.Error(), e) {
+			t.Errorf("expected warning %q, got:\n%s", e, file.warnings)
+		}
+	}
+
+	if err := ValidateProxyConfig("proxy.yml", []byte(content)); err == nil {
+		t.Error("expected strict validation to fail")
+	}
+
+	// Fields of new features are checked when configs are loaded as well
+	err := cfg.load("proxy.yml", []byte("domainName: mc.example.com\nproxyTo: \":25566\"\nduplicateLogin:\n  policy: ban\n"))
+	if err == nil {
+		t.Error("expected an invalid duplicate login policy to fail")
+	}
+}
+
+func TestValidateGlobalConfig(t *testing.T) {
+	content := `configPath: ./configs
+listener:
+  timeouts:
+    handshake: 5s
+  maxConection: 10
+defaults:
+  proxyProtocl: true
+`
+	err := ValidateGlobalConfig("infrared.yml", []byte(content))
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected validation errors, got: %v", err)
+	}
+
+	expected := []string{
+		"infrared.yml:7: defaults.proxyProtocl: unknown field",
+		"infrared.yml:5: listener.maxConection: unknown field",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got:\n%s", len(expected), errs)
+	}
+
+	for _, e := range expected {
+		if !strings.Contains(errs.Error(), e) {
+			t.Errorf("expected error %q, got:\n%s", e, errs)
+		}
+	}
+}
+
+func TestProxyConfigSchema(t *testing.T) {
+	schema := ProxyConfigSchema()
+	if schema["additionalProperties"] != false {
+		t.Error("schema allows unknown fields")
+	}
+
+	properties, ok := schema["properties"].(map[string]interface{})
+	if !ok {
+		t.Fatal("schema has no properties")
+	}
+
+	domainName, ok := properties["domainName"].(map[string]interface{})
+	if !ok || domainName["type"] != "string" || domainName["default"] != "localhost" {
+		t.Errorf("unexpected domainName schema: %v", properties["domainName"])
+	}
+
+	maintenance, ok := properties["maintenance"].(map[string]interface{})
+	if !ok || maintenance["type"] != "object" {
+		t.Errorf("unexpected maintenance schema: %v", properties["maintenance"])
+	}
+}